@@ -0,0 +1,142 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// walletAttribute is the certificate attribute carrying the wallet of the client.
+	walletAttribute = "wallet"
+	// roleAttribute is the certificate attribute carrying the role of the client.
+	roleAttribute = "role"
+
+	roleAdmin = "admin"
+	// permissionModerate is the permission to moderate content, which includes creating tags.
+	permissionModerate = "moderate"
+
+	// userprofileChaincode is the chaincode name the user profiles are stored in.
+	userprofileChaincode = "userprofile"
+)
+
+// profile is the subset of the userprofile Profile read by this chaincode.
+type profile struct {
+	Wallet        string   `json:"wallet"`
+	RolesAssigned []string `json:"rolesAssigned"`
+}
+
+// getSubmittingWallet returns the wallet of the client submitting the transaction.
+// The wallet certificate attribute takes precedence over the client ID.
+func getSubmittingWallet(ctx contractapi.TransactionContextInterface) (string, error) {
+	wallet, found, err := ctx.GetClientIdentity().GetAttributeValue(walletAttribute)
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+	if found && wallet != "" {
+		return wallet, nil
+	}
+
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to base64 decode client identity: %v", err)
+	}
+
+	return string(decodeID), nil
+}
+
+// resolveCreator returns the submitting wallet, rejecting payloads claiming another creator.
+func resolveCreator(ctx contractapi.TransactionContextInterface, creator string) (string, error) {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if creator != "" && creator != wallet {
+		return "", fmt.Errorf("the creator %s does not match the submitting client %s", creator, wallet)
+	}
+
+	return wallet, nil
+}
+
+// readProfile queries the userprofile chaincode for the profile of the given wallet.
+func readProfile(ctx contractapi.TransactionContextInterface, wallet string) (*profile, error) {
+	args := [][]byte{[]byte("ReadUser"), []byte(wallet)}
+	response := ctx.GetStub().InvokeChaincode(userprofileChaincode, args, "")
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("failed to query userprofile: %s", response.Message)
+	}
+
+	var user profile
+	err := json.Unmarshal(response.Payload, &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user profile: %v", err)
+	}
+
+	return &user, nil
+}
+
+// hasRole returns true when the submitting wallet holds one of the roles, either
+// through the role certificate attribute or the roles assigned to its profile.
+func hasRole(ctx contractapi.TransactionContextInterface, wallet string, roles ...string) (bool, error) {
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return false, fmt.Errorf("failed to read client identity: %v", err)
+	}
+	if found && contains(roles, role) {
+		return true, nil
+	}
+
+	user, err := readProfile(ctx, wallet)
+	if err != nil {
+		return false, err
+	}
+	// userprofile answers for a rotated wallet with the profile under its new wallet,
+	// whose roles the old wallet no longer holds
+	if user.Wallet != wallet {
+		return false, nil
+	}
+
+	for _, assigned := range user.RolesAssigned {
+		if contains(roles, assigned) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hasPermission queries the userprofile chaincode whether the wallet holds the
+// permission, through the roles assigned to its profile or the role certificate
+// attribute of the submitting client.
+func hasPermission(ctx contractapi.TransactionContextInterface, wallet string, permission string) (bool, error) {
+	args := [][]byte{[]byte("HasPermission"), []byte(wallet), []byte(permission)}
+	response := ctx.GetStub().InvokeChaincode(userprofileChaincode, args, "")
+	if response.Status != shim.OK {
+		return false, fmt.Errorf("failed to query userprofile: %s", response.Message)
+	}
+
+	var permitted bool
+	err := json.Unmarshal(response.Payload, &permitted)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode permission: %v", err)
+	}
+	return permitted, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// mergePatch applies the JSON Merge Patch (RFC 7396) in the payload to the struct target
+// points at. Only the fields whose JSON names are listed in mutable are changed. The
+// fields listed in keys identify what is patched and are left alone, any other field
+// of the patch is rejected with ErrInvalidPayload. A null value clears the field.
+func mergePatch(target interface{}, payload string, mutable []string, keys []string) error {
+	var patch map[string]json.RawMessage
+	err := json.Unmarshal([]byte(payload), &patch)
+	if err != nil {
+		return err
+	}
+
+	targetJSON, _ := json.Marshal(target)
+	var document map[string]json.RawMessage
+	json.Unmarshal(targetJSON, &document)
+
+	// the fields are visited in order so every peer rejects the same one
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		value := patch[field]
+		if contains(keys, field) {
+			continue
+		}
+		if !contains(mutable, field) {
+			return fmt.Errorf("%w: the field %s may not be changed", ErrInvalidPayload, field)
+		}
+		if isNull(value) {
+			delete(document, field)
+			continue
+		}
+		document[field] = mergeValue(document[field], value)
+	}
+
+	// decode into a zeroed struct so cleared fields do not keep their previous values
+	merged := reflect.New(reflect.TypeOf(target).Elem())
+	documentJSON, _ := json.Marshal(document)
+	err = json.Unmarshal(documentJSON, merged.Interface())
+	if err != nil {
+		return err
+	}
+
+	reflect.ValueOf(target).Elem().Set(merged.Elem())
+	return nil
+}
+
+// mergeValue merges the patch into the target value. Objects are merged member by
+// member, any other patch replaces the target.
+func mergeValue(target json.RawMessage, patch json.RawMessage) json.RawMessage {
+	var patchObject map[string]json.RawMessage
+	if json.Unmarshal(patch, &patchObject) != nil {
+		return patch
+	}
+
+	var targetObject map[string]json.RawMessage
+	if json.Unmarshal(target, &targetObject) != nil || targetObject == nil {
+		targetObject = map[string]json.RawMessage{}
+	}
+
+	for member, value := range patchObject {
+		if isNull(value) {
+			delete(targetObject, member)
+			continue
+		}
+		targetObject[member] = mergeValue(targetObject[member], value)
+	}
+
+	merged, _ := json.Marshal(targetObject)
+	return merged
+}
+
+func isNull(value json.RawMessage) bool {
+	return string(value) == "null"
+}
@@ -0,0 +1,72 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrateKeys moves tags, categories and categoryGroups stored under their bare name, as
+// written before composite keys were introduced, to their composite key. Entries are told
+// apart by the fields only their type carries. It returns the number of entries moved.
+func (s *SmartContract) MigrateKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkPermission(ctx, taxonomyRule)
+	if err != nil {
+		return 0, err
+	}
+
+	// range queries only cover simple keys, so this visits legacy entries only
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(queryResponse.Value, &fields) != nil {
+			continue
+		}
+
+		var name string
+		if json.Unmarshal(fields["name"], &name) != nil || name == "" {
+			continue
+		}
+
+		var key string
+		switch {
+		case fields["categories"] != nil:
+			key, err = categoryGroupKey(ctx, name)
+		case fields["categoryGroupName"] != nil:
+			key, err = categoryKey(ctx, name)
+		case fields["creatorWallet"] != nil:
+			key, err = tagKey(ctx, name)
+		default:
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().PutState(key, queryResponse.Value)
+		if err != nil {
+			return 0, fmt.Errorf("failed to put to world state: %v", err)
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		migrated++
+	}
+
+	return migrated, ctx.GetStub().SetEvent("MigrateKeys", []byte(strconv.Itoa(migrated)))
+}
@@ -0,0 +1,132 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TagPage is a page of tags along with the bookmark the next page starts at.
+type TagPage struct {
+	Records             []*Tag `json:"records"`
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"`
+}
+
+// GetAllTagsWithPagination returns a page of at most pageSize tags starting at the bookmark.
+func (s *SmartContract) GetAllTagsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*TagPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(tagObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var tags []*Tag
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var tag Tag
+		json.Unmarshal(queryResponse.Value, &tag)
+		tags = append(tags, &tag)
+	}
+
+	return &TagPage{
+		Records:             tags,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// CategoryPage is a page of categorys along with the bookmark the next page starts at.
+type CategoryPage struct {
+	Records             []*Category `json:"records"`
+	FetchedRecordsCount int32       `json:"fetchedRecordsCount"`
+	Bookmark            string      `json:"bookmark"`
+}
+
+// GetAllCategorysWithPagination returns a page of at most pageSize categorys starting at the bookmark.
+func (s *SmartContract) GetAllCategorysWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*CategoryPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(categoryObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var categorys []*Category
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var category Category
+		json.Unmarshal(queryResponse.Value, &category)
+		categorys = append(categorys, &category)
+	}
+
+	return &CategoryPage{
+		Records:             categorys,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// CategoryGroupPage is a page of categoryGroups along with the bookmark the next page starts at.
+type CategoryGroupPage struct {
+	Records             []*CategoryGroup `json:"records"`
+	FetchedRecordsCount int32            `json:"fetchedRecordsCount"`
+	Bookmark            string           `json:"bookmark"`
+}
+
+// GetAllCategoryGroupsWithPagination returns a page of at most pageSize categoryGroups starting at the bookmark.
+func (s *SmartContract) GetAllCategoryGroupsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*CategoryGroupPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(categoryGroupObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var categoryGroups []*CategoryGroup
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var categoryGroup CategoryGroup
+		json.Unmarshal(queryResponse.Value, &categoryGroup)
+		categoryGroups = append(categoryGroups, &categoryGroup)
+	}
+
+	return &CategoryGroupPage{
+		Records:             categoryGroups,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+func checkPageSize(pageSize int32) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("the page size %d is not positive", pageSize)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Rule describes the clients allowed to perform an operation. A client satisfies
+// the rule when it matches any of the listed MSPs, certificate attributes or roles, or
+// holds any of the listed permissions through the roles userprofile defines.
+type Rule struct {
+	Anyone      bool     `json:"anyone"`
+	MSPIDs      []string `json:"mspIds"`
+	Attributes  []string `json:"attributes"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// Permissions holds the rules guarding writes to tags, categories and category groups.
+type Permissions struct {
+	Taxonomy Rule `json:"taxonomy"`
+	Tag      Rule `json:"tag"`
+}
+
+const (
+	taxonomyRule = "taxonomy"
+	tagRule      = "tag"
+)
+
+// defaultPermissions returns the rules that apply until SetPermissions stores others.
+// The stored rules are decoded over them, so every call builds its own slices.
+func defaultPermissions() Permissions {
+	return Permissions{
+		Taxonomy: Rule{Roles: []string{roleAdmin}},
+		Tag:      Rule{Roles: []string{roleAdmin}, Permissions: []string{permissionModerate}},
+	}
+}
+
+// permissionsKey returns the world state key the permissions are stored under.
+func permissionsKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey("config", []string{"permissions"})
+}
+
+// GetPermissions returns the permissions in effect.
+func (s *SmartContract) GetPermissions(ctx contractapi.TransactionContextInterface) (*Permissions, error) {
+	return readPermissions(ctx)
+}
+
+// readPermissions returns the stored permissions, falling back to the defaults.
+func readPermissions(ctx contractapi.TransactionContextInterface) (*Permissions, error) {
+	key, err := permissionsKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	permissionsJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	defaults := defaultPermissions()
+	permissions := defaultPermissions()
+	if permissionsJSON != nil {
+		json.Unmarshal(permissionsJSON, &permissions)
+	}
+
+	// a rule left empty falls back to its default rather than locking everyone out
+	if permissions.Taxonomy.isEmpty() {
+		permissions.Taxonomy = defaults.Taxonomy
+	}
+	if permissions.Tag.isEmpty() {
+		permissions.Tag = defaults.Tag
+	}
+
+	return &permissions, nil
+}
+
+// SetPermissions replaces the permissions. Only clients satisfying the taxonomy rule may change them.
+func (s *SmartContract) SetPermissions(ctx contractapi.TransactionContextInterface, payload string) error {
+	permissions := Permissions{}
+	err := decodePayload(payload, &permissions)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkPermission(ctx, taxonomyRule)
+	if err != nil {
+		return err
+	}
+
+	key, err := permissionsKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	permissionsJSON, _ := json.Marshal(permissions)
+	err = ctx.GetStub().PutState(key, permissionsJSON)
+
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	return ctx.GetStub().SetEvent("SetPermissions", permissionsJSON)
+}
+
+func (r *Rule) isEmpty() bool {
+	return !r.Anyone && len(r.MSPIDs) == 0 && len(r.Attributes) == 0 && len(r.Roles) == 0 && len(r.Permissions) == 0
+}
+
+// allows reports whether the submitting client satisfies the rule.
+func (r *Rule) allows(ctx contractapi.TransactionContextInterface, wallet string) (bool, error) {
+	if r.Anyone {
+		return true, nil
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return false, fmt.Errorf("failed to read client identity: %v", err)
+	}
+	if contains(r.MSPIDs, mspID) {
+		return true, nil
+	}
+
+	for _, attribute := range r.Attributes {
+		value, found, err := ctx.GetClientIdentity().GetAttributeValue(attribute)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client identity: %v", err)
+		}
+		if found && value == "true" {
+			return true, nil
+		}
+	}
+
+	if len(r.Roles) > 0 {
+		allowed, err := hasRole(ctx, wallet, r.Roles...)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+
+	for _, permission := range r.Permissions {
+		allowed, err := hasPermission(ctx, wallet, permission)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+	return false, nil
+}
+
+// checkPermission returns an error unless the submitting client satisfies the named rule.
+func checkPermission(ctx contractapi.TransactionContextInterface, name string) error {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return err
+	}
+
+	allowed, action, err := permitted(ctx, wallet, name)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("the client %s is not permitted to %s", wallet, action)
+	}
+	return nil
+}
+
+// permitted reports whether the wallet satisfies the named rule, along with the action
+// the rule guards. Errors are only returned when the rule could not be checked.
+func permitted(ctx contractapi.TransactionContextInterface, wallet string, name string) (bool, string, error) {
+	permissions, err := readPermissions(ctx)
+	if err != nil {
+		return false, "", err
+	}
+
+	rule, action := permissions.Taxonomy, "change the taxonomy"
+	if name == tagRule {
+		rule, action = permissions.Tag, "create tags"
+	}
+
+	allowed, err := rule.allows(ctx, wallet)
+	return allowed, action, err
+}
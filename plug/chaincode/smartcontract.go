@@ -3,7 +3,6 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
-	"reflect"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -12,34 +11,80 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+const (
+	// object types namespacing the composite keys entities are stored under
+	tagObjectType           = "tag~name"
+	categoryObjectType      = "category~name"
+	categoryGroupObjectType = "categoryGroup~name"
+)
+
+// tagFields, categoryFields and categoryGroupFields are the fields clients may change
+// through UpdateTag, UpdateCategory and UpdateCategoryGroup.
+var (
+	tagFields           = []string{"description"}
+	categoryFields      = []string{"categoryGroupName", "color"}
+	categoryGroupFields = []string{"color", "categories"}
+)
+
+// entityKeys are the fields of an update payload naming the tag, category or category
+// group, its creator and the version the patch was made against.
+var entityKeys = []string{"name", "creatorWallet", "expectedVersion"}
+
 type Tag struct {
 	Name          string `json:"name"`
 	CreatorWallet string `json:"creatorWallet"`
 	Description   string `json:"description"`
+
+	Version   uint64 `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
 }
 
 type Category struct {
 	CategoryGroupName string `json:"categoryGroupName"`
 	Color             string `json:"color"`
 	Name              string `json:"name"`
+
+	Version   uint64 `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
 }
 
 type CategoryGroup struct {
 	Name       string   `json:"name"`
 	Color      string   `json:"color"`
 	Categories []string `json:"categories"`
+
+	Version   uint64 `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
 }
 
 // CreateTag creates a tag.
 func (s *SmartContract) CreateTag(ctx contractapi.TransactionContextInterface, payload string) error {
 
 	tag := Tag{}
-	err := json.Unmarshal([]byte(payload), &tag)
+	err := decodePayload(payload, &tag)
 
 	if err != nil {
 		return err
 	}
 
+	tag.CreatorWallet, err = resolveCreator(ctx, tag.CreatorWallet)
+	if err != nil {
+		return err
+	}
+
+	err = tag.validate()
+	if err != nil {
+		return err
+	}
+
+	err = checkPermission(ctx, tagRule)
+	if err != nil {
+		return err
+	}
+
 	exists, err := s.TagExists(ctx, tag.Name)
 	if err != nil {
 		return err
@@ -48,18 +93,36 @@ func (s *SmartContract) CreateTag(ctx contractapi.TransactionContextInterface, p
 		return fmt.Errorf("the tag %s already exists", tag.Name)
 	}
 
-	err = ctx.GetStub().PutState(tag.Name, []byte(payload))
+	// versions and timestamps are kept by the chaincode, never taken from the client
+	tag.Version, tag.CreatedAt = 0, 0
+	err = touch(ctx, &tag.Version, &tag.CreatedAt, &tag.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	key, err := tagKey(ctx, tag.Name)
+	if err != nil {
+		return err
+	}
+
+	tagJSON, _ := json.Marshal(tag)
+	err = ctx.GetStub().PutState(key, tagJSON)
 
 	if err != nil {
 		return fmt.Errorf("failed to put to world state: %v", err)
 	}
 
-	return ctx.GetStub().SetEvent("CreateTag", []byte(payload))
+	return ctx.GetStub().SetEvent("CreateTag", tagJSON)
 }
 
 // TagExists returns true when tag with given name exists in world state
 func (s *SmartContract) TagExists(ctx contractapi.TransactionContextInterface, tagName string) (bool, error) {
-	tagJSON, err := ctx.GetStub().GetState(tagName)
+	key, err := tagKey(ctx, tagName)
+	if err != nil {
+		return false, err
+	}
+
+	tagJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -69,7 +132,12 @@ func (s *SmartContract) TagExists(ctx contractapi.TransactionContextInterface, t
 
 // ReadTag returns the tag stored in the world state with given name.
 func (s *SmartContract) ReadTag(ctx contractapi.TransactionContextInterface, tagName string) (*Tag, error) {
-	tagJSON, err := ctx.GetStub().GetState(tagName)
+	key, err := tagKey(ctx, tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	tagJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -83,11 +151,21 @@ func (s *SmartContract) ReadTag(ctx contractapi.TransactionContextInterface, tag
 	return &tag, nil
 }
 
-// UpdateTag updates an existing tag in the world state with provided parameters.
+// UpdateTag applies the JSON Merge Patch in the payload to an existing tag. The
+// name identifies the tag, and only the fields in tagFields are changed.
+// The payload may carry the expectedVersion the patch was made against.
 func (s *SmartContract) UpdateTag(ctx contractapi.TransactionContextInterface, payload string) error {
-	next := Tag{}
-	err := json.Unmarshal([]byte(payload), &next)
+	next, expectation := Tag{}, Expectation{}
+	err := decodePayload(payload, &struct {
+		*Tag
+		*Expectation
+	}{&next, &expectation})
+
+	if err != nil {
+		return err
+	}
 
+	next.CreatorWallet, err = resolveCreator(ctx, next.CreatorWallet)
 	if err != nil {
 		return err
 	}
@@ -100,24 +178,49 @@ func (s *SmartContract) UpdateTag(ctx contractapi.TransactionContextInterface, p
 		return fmt.Errorf("the tag %s does not exist", next.Name)
 	}
 
-	prev, _ := s.ReadTag(ctx, next.Name)
-
-	x := reflect.ValueOf(&next).Elem()
-	y := reflect.ValueOf(prev).Elem()
+	prev, err := s.ReadTag(ctx, next.Name)
+	if err != nil {
+		return err
+	}
 
-	// use reflection package to dynamically update non-zero value
-	for i := 0; i < x.NumField(); i++ {
-		name := x.Type().Field(i).Name
-		yf := y.FieldByName(name)
-		xf := x.FieldByName(name)
-		if yf.CanSet() && !xf.IsZero() {
-			yf.Set(xf)
+	if prev.CreatorWallet != next.CreatorWallet {
+		allowed, _, err := permitted(ctx, next.CreatorWallet, taxonomyRule)
+		if err != nil {
+			return err
 		}
+		if !allowed {
+			return fmt.Errorf("the tag %s is not created by %s", next.Name, next.CreatorWallet)
+		}
+	}
+
+	err = expectation.check("tag", prev.Name, prev.Version)
+	if err != nil {
+		return err
+	}
+
+	err = mergePatch(prev, payload, tagFields, entityKeys)
+	if err != nil {
+		return err
+	}
+
+	err = prev.validate()
+	if err != nil {
+		return err
+	}
+
+	err = touch(ctx, &prev.Version, &prev.CreatedAt, &prev.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	key, err := tagKey(ctx, prev.Name)
+	if err != nil {
+		return err
 	}
 
 	// overwriting original tag with new tag
 	yJSON, _ := json.Marshal(prev)
-	err = ctx.GetStub().PutState(prev.Name, yJSON)
+	err = ctx.GetStub().PutState(key, yJSON)
 
 	if err != nil {
 		return fmt.Errorf("failed to put to world state: %v", err)
@@ -128,7 +231,7 @@ func (s *SmartContract) UpdateTag(ctx contractapi.TransactionContextInterface, p
 
 // GetAllTags returns all tags found in world state
 func (s *SmartContract) GetAllTags(ctx contractapi.TransactionContextInterface) ([]*Tag, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(tagObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -153,8 +256,18 @@ func (s *SmartContract) GetAllTags(ctx contractapi.TransactionContextInterface)
 func (s *SmartContract) CreateCategory(ctx contractapi.TransactionContextInterface, payload string) error {
 
 	category := Category{}
-	err := json.Unmarshal([]byte(payload), &category)
+	err := decodePayload(payload, &category)
+
+	if err != nil {
+		return err
+	}
+
+	err = category.validate()
+	if err != nil {
+		return err
+	}
 
+	err = checkPermission(ctx, taxonomyRule)
 	if err != nil {
 		return err
 	}
@@ -167,18 +280,36 @@ func (s *SmartContract) CreateCategory(ctx contractapi.TransactionContextInterfa
 		return fmt.Errorf("the category %s already exists", category.Name)
 	}
 
-	err = ctx.GetStub().PutState(category.Name, []byte(payload))
+	// versions and timestamps are kept by the chaincode, never taken from the client
+	category.Version, category.CreatedAt = 0, 0
+	err = touch(ctx, &category.Version, &category.CreatedAt, &category.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	key, err := categoryKey(ctx, category.Name)
+	if err != nil {
+		return err
+	}
+
+	categoryJSON, _ := json.Marshal(category)
+	err = ctx.GetStub().PutState(key, categoryJSON)
 
 	if err != nil {
 		return fmt.Errorf("failed to put to world state: %v", err)
 	}
 
-	return ctx.GetStub().SetEvent("CreateCategory", []byte(payload))
+	return ctx.GetStub().SetEvent("CreateCategory", categoryJSON)
 }
 
 // CategoryExists returns true when category with given name exists in world state
 func (s *SmartContract) CategoryExists(ctx contractapi.TransactionContextInterface, categoryName string) (bool, error) {
-	categoryJSON, err := ctx.GetStub().GetState(categoryName)
+	key, err := categoryKey(ctx, categoryName)
+	if err != nil {
+		return false, err
+	}
+
+	categoryJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -188,7 +319,12 @@ func (s *SmartContract) CategoryExists(ctx contractapi.TransactionContextInterfa
 
 // ReadCategory returns the category stored in the world state with given name.
 func (s *SmartContract) ReadCategory(ctx contractapi.TransactionContextInterface, categoryName string) (*Category, error) {
-	categoryJSON, err := ctx.GetStub().GetState(categoryName)
+	key, err := categoryKey(ctx, categoryName)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -202,11 +338,21 @@ func (s *SmartContract) ReadCategory(ctx contractapi.TransactionContextInterface
 	return &category, nil
 }
 
-// UpdateCategory updates an existing category in the world state with provided parameters.
+// UpdateCategory applies the JSON Merge Patch in the payload to an existing category. The
+// name identifies the category, and only the fields in categoryFields are changed.
+// The payload may carry the expectedVersion the patch was made against.
 func (s *SmartContract) UpdateCategory(ctx contractapi.TransactionContextInterface, payload string) error {
-	next := Category{}
-	err := json.Unmarshal([]byte(payload), &next)
+	next, expectation := Category{}, Expectation{}
+	err := decodePayload(payload, &struct {
+		*Category
+		*Expectation
+	}{&next, &expectation})
+
+	if err != nil {
+		return err
+	}
 
+	err = checkPermission(ctx, taxonomyRule)
 	if err != nil {
 		return err
 	}
@@ -221,22 +367,34 @@ func (s *SmartContract) UpdateCategory(ctx contractapi.TransactionContextInterfa
 
 	prev, _ := s.ReadCategory(ctx, next.Name)
 
-	x := reflect.ValueOf(&next).Elem()
-	y := reflect.ValueOf(prev).Elem()
+	err = expectation.check("category", prev.Name, prev.Version)
+	if err != nil {
+		return err
+	}
 
-	// use reflection package to dynamically update non-zero value
-	for i := 0; i < x.NumField(); i++ {
-		name := x.Type().Field(i).Name
-		yf := y.FieldByName(name)
-		xf := x.FieldByName(name)
-		if yf.CanSet() && !xf.IsZero() {
-			yf.Set(xf)
-		}
+	err = mergePatch(prev, payload, categoryFields, entityKeys)
+	if err != nil {
+		return err
+	}
+
+	err = prev.validate()
+	if err != nil {
+		return err
+	}
+
+	err = touch(ctx, &prev.Version, &prev.CreatedAt, &prev.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	key, err := categoryKey(ctx, prev.Name)
+	if err != nil {
+		return err
 	}
 
 	// overwriting original category with new category
 	yJSON, _ := json.Marshal(prev)
-	err = ctx.GetStub().PutState(prev.Name, yJSON)
+	err = ctx.GetStub().PutState(key, yJSON)
 
 	if err != nil {
 		return fmt.Errorf("failed to put to world state: %v", err)
@@ -247,7 +405,7 @@ func (s *SmartContract) UpdateCategory(ctx contractapi.TransactionContextInterfa
 
 // GetAllCategorys returns all categorys found in world state
 func (s *SmartContract) GetAllCategorys(ctx contractapi.TransactionContextInterface) ([]*Category, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(categoryObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -272,8 +430,18 @@ func (s *SmartContract) GetAllCategorys(ctx contractapi.TransactionContextInterf
 func (s *SmartContract) CreateCategoryGroup(ctx contractapi.TransactionContextInterface, payload string) error {
 
 	categoryGroup := CategoryGroup{}
-	err := json.Unmarshal([]byte(payload), &categoryGroup)
+	err := decodePayload(payload, &categoryGroup)
+
+	if err != nil {
+		return err
+	}
+
+	err = categoryGroup.validate()
+	if err != nil {
+		return err
+	}
 
+	err = checkPermission(ctx, taxonomyRule)
 	if err != nil {
 		return err
 	}
@@ -286,18 +454,36 @@ func (s *SmartContract) CreateCategoryGroup(ctx contractapi.TransactionContextIn
 		return fmt.Errorf("the categoryGroup %s already exists", categoryGroup.Name)
 	}
 
-	err = ctx.GetStub().PutState(categoryGroup.Name, []byte(payload))
+	// versions and timestamps are kept by the chaincode, never taken from the client
+	categoryGroup.Version, categoryGroup.CreatedAt = 0, 0
+	err = touch(ctx, &categoryGroup.Version, &categoryGroup.CreatedAt, &categoryGroup.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	key, err := categoryGroupKey(ctx, categoryGroup.Name)
+	if err != nil {
+		return err
+	}
+
+	categoryGroupJSON, _ := json.Marshal(categoryGroup)
+	err = ctx.GetStub().PutState(key, categoryGroupJSON)
 
 	if err != nil {
 		return fmt.Errorf("failed to put to world state: %v", err)
 	}
 
-	return ctx.GetStub().SetEvent("CreateCategoryGroup", []byte(payload))
+	return ctx.GetStub().SetEvent("CreateCategoryGroup", categoryGroupJSON)
 }
 
 // CategoryGroupExists returns true when categoryGroup with given name exists in world state
 func (s *SmartContract) CategoryGroupExists(ctx contractapi.TransactionContextInterface, categoryGroupName string) (bool, error) {
-	categoryGroupJSON, err := ctx.GetStub().GetState(categoryGroupName)
+	key, err := categoryGroupKey(ctx, categoryGroupName)
+	if err != nil {
+		return false, err
+	}
+
+	categoryGroupJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -307,7 +493,12 @@ func (s *SmartContract) CategoryGroupExists(ctx contractapi.TransactionContextIn
 
 // ReadCategoryGroup returns the categoryGroup stored in the world state with given name.
 func (s *SmartContract) ReadCategoryGroup(ctx contractapi.TransactionContextInterface, categoryGroupName string) (*CategoryGroup, error) {
-	categoryGroupJSON, err := ctx.GetStub().GetState(categoryGroupName)
+	key, err := categoryGroupKey(ctx, categoryGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryGroupJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -321,11 +512,21 @@ func (s *SmartContract) ReadCategoryGroup(ctx contractapi.TransactionContextInte
 	return &categoryGroup, nil
 }
 
-// UpdateCategoryGroup updates an existing categoryGroup in the world state with provided parameters.
+// UpdateCategoryGroup applies the JSON Merge Patch in the payload to an existing categoryGroup. The
+// name identifies the categoryGroup, and only the fields in categoryGroupFields are changed.
+// The payload may carry the expectedVersion the patch was made against.
 func (s *SmartContract) UpdateCategoryGroup(ctx contractapi.TransactionContextInterface, payload string) error {
-	next := CategoryGroup{}
-	err := json.Unmarshal([]byte(payload), &next)
+	next, expectation := CategoryGroup{}, Expectation{}
+	err := decodePayload(payload, &struct {
+		*CategoryGroup
+		*Expectation
+	}{&next, &expectation})
+
+	if err != nil {
+		return err
+	}
 
+	err = checkPermission(ctx, taxonomyRule)
 	if err != nil {
 		return err
 	}
@@ -340,22 +541,34 @@ func (s *SmartContract) UpdateCategoryGroup(ctx contractapi.TransactionContextIn
 
 	prev, _ := s.ReadCategoryGroup(ctx, next.Name)
 
-	x := reflect.ValueOf(&next).Elem()
-	y := reflect.ValueOf(prev).Elem()
+	err = expectation.check("categoryGroup", prev.Name, prev.Version)
+	if err != nil {
+		return err
+	}
 
-	// use reflection package to dynamically update non-zero value
-	for i := 0; i < x.NumField(); i++ {
-		name := x.Type().Field(i).Name
-		yf := y.FieldByName(name)
-		xf := x.FieldByName(name)
-		if yf.CanSet() && !xf.IsZero() {
-			yf.Set(xf)
-		}
+	err = mergePatch(prev, payload, categoryGroupFields, entityKeys)
+	if err != nil {
+		return err
+	}
+
+	err = prev.validate()
+	if err != nil {
+		return err
+	}
+
+	err = touch(ctx, &prev.Version, &prev.CreatedAt, &prev.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	key, err := categoryGroupKey(ctx, prev.Name)
+	if err != nil {
+		return err
 	}
 
 	// overwriting original categoryGroup with new categoryGroup
 	yJSON, _ := json.Marshal(prev)
-	err = ctx.GetStub().PutState(prev.Name, yJSON)
+	err = ctx.GetStub().PutState(key, yJSON)
 
 	if err != nil {
 		return fmt.Errorf("failed to put to world state: %v", err)
@@ -366,7 +579,7 @@ func (s *SmartContract) UpdateCategoryGroup(ctx contractapi.TransactionContextIn
 
 // GetAllCategoryGroups returns all categoryGroups found in world state
 func (s *SmartContract) GetAllCategoryGroups(ctx contractapi.TransactionContextInterface) ([]*CategoryGroup, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(categoryGroupObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -386,3 +599,18 @@ func (s *SmartContract) GetAllCategoryGroups(ctx contractapi.TransactionContextI
 
 	return categoryGroups, nil
 }
+
+// tagKey returns the world state key of the tag with given name.
+func tagKey(ctx contractapi.TransactionContextInterface, name string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(tagObjectType, []string{name})
+}
+
+// categoryKey returns the world state key of the category with given name.
+func categoryKey(ctx contractapi.TransactionContextInterface, name string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(categoryObjectType, []string{name})
+}
+
+// categoryGroupKey returns the world state key of the categoryGroup with given name.
+func categoryGroupKey(ctx contractapi.TransactionContextInterface, name string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(categoryGroupObjectType, []string{name})
+}
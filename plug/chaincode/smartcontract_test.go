@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"plug/chaincode"
@@ -16,7 +17,9 @@ import (
 
 	// _ "github.com/maxbrunsfeld/counterfeiter/v6"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -48,12 +51,15 @@ const myOrg2PrivCollection = "Org2TestmspPrivateCollection"
 
 var sampleTag = &chaincode.Tag{
 	Name:          "tag1",
-	CreatorWallet: "1",
+	CreatorWallet: myOrg1Clientid,
 	Description:   "tag1",
 }
 
 var sampleInput1, _ = json.Marshal(sampleTag)
 
+// samplePatch1 changes the fields of sampleTag clients may update to their own values.
+const samplePatch1 = `{"name":"tag1","creatorWallet":"` + myOrg1Clientid + `","description":"tag1"}`
+
 func TestCreateTag(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	tag := chaincode.SmartContract{}
@@ -79,6 +85,29 @@ func TestCreateTag(t *testing.T) {
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
+func TestCreateTagIdentity(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	tag := chaincode.SmartContract{}
+
+	err := tag.CreateTag(transactionContext, string(sampleInput1))
+	require.EqualError(t, err, "the creator myOrg1Userid does not match the submitting client myOrg2Userid")
+
+	anonymousTag := &chaincode.Tag{Name: "tag2"}
+	bytes, _ := json.Marshal(anonymousTag)
+	err = tag.CreateTag(transactionContext, string(bytes))
+	require.NoError(t, err)
+
+	key, tagJSON := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00tag~name\x00tag2\x00", key)
+	stored := &chaincode.Tag{}
+	json.Unmarshal(tagJSON, stored)
+	require.Equal(t, myOrg2Clientid, stored.CreatorWallet)
+
+	transactionContext, _ = prepMocksIllegalId()
+	err = tag.CreateTag(transactionContext, string(sampleInput1))
+	require.EqualError(t, err, "failed to read client identity: failure")
+}
+
 func TestReadTag(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	tag := chaincode.SmartContract{}
@@ -105,29 +134,45 @@ func TestUpdateTag(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	tag := chaincode.SmartContract{}
 
-	err := tag.UpdateTag(transactionContext, string(sampleInput1))
+	err := tag.UpdateTag(transactionContext, samplePatch1)
 	require.EqualError(t, err, "the tag tag1 does not exist")
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = tag.UpdateTag(transactionContext, string(sampleInput1))
+	err = tag.UpdateTag(transactionContext, samplePatch1)
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpTag := &chaincode.Tag{Name: "1", CreatorWallet: "1"}
+	tmpTag := &chaincode.Tag{Name: "1", CreatorWallet: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpTag)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
-	err = tag.UpdateTag(transactionContext, string(sampleInput1))
+	err = tag.UpdateTag(transactionContext, samplePatch1)
 	require.NoError(t, err)
 
 	err = tag.UpdateTag(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
-	tmpTag = &chaincode.Tag{Name: "1", CreatorWallet: "1"}
+	tmpTag = &chaincode.Tag{Name: "1", CreatorWallet: myOrg2Clientid}
+	bytes, _ = json.Marshal(tmpTag)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = tag.UpdateTag(transactionContext, samplePatch1)
+	require.NoError(t, err)
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid))
+	err = tag.UpdateTag(transactionContext, samplePatch1)
+	require.EqualError(t, err, "the tag tag1 is not created by myOrg1Userid")
+
+	// failing to check the permission is not mistaken for a denial
+	chaincodeStub.InvokeChaincodeReturns(shim.Error("userprofile unavailable"))
+	err = tag.UpdateTag(transactionContext, samplePatch1)
+	require.EqualError(t, err, "failed to query userprofile: userprofile unavailable")
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	tmpTag = &chaincode.Tag{Name: "1", CreatorWallet: myOrg1Clientid}
 	bytes, _ = json.Marshal(tmpTag)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = tag.UpdateTag(transactionContext, string(sampleInput1))
+	err = tag.UpdateTag(transactionContext, samplePatch1)
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
@@ -145,7 +190,7 @@ func TestGetAllTags(t *testing.T) {
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	userprofile := &chaincode.SmartContract{}
 	assets, err := userprofile.GetAllTags(transactionContext)
 	require.NoError(t, err)
@@ -157,7 +202,7 @@ func TestGetAllTags(t *testing.T) {
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, assets)
 
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
 	assets, err = userprofile.GetAllTags(transactionContext)
 	require.EqualError(t, err, "failed retrieving all assets")
 	require.Nil(t, assets)
@@ -165,12 +210,15 @@ func TestGetAllTags(t *testing.T) {
 
 var sampleCategory = &chaincode.Category{
 	Name:              "category1",
-	Color:             "1",
+	Color:             "#111",
 	CategoryGroupName: "1",
 }
 
 var sampleInput2, _ = json.Marshal(sampleCategory)
 
+// samplePatch2 changes the fields of sampleCategory clients may update to their own values.
+const samplePatch2 = `{"name":"category1","color":"#111","categoryGroupName":"1"}`
+
 func TestCreateCategory(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	category := chaincode.SmartContract{}
@@ -222,18 +270,18 @@ func TestUpdateCategory(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	category := chaincode.SmartContract{}
 
-	err := category.UpdateCategory(transactionContext, string(sampleInput2))
+	err := category.UpdateCategory(transactionContext, samplePatch2)
 	require.EqualError(t, err, "the category category1 does not exist")
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = category.UpdateCategory(transactionContext, string(sampleInput2))
+	err = category.UpdateCategory(transactionContext, samplePatch2)
 	require.EqualError(t, err, "failed to read from world state: failure")
 
 	tmpCategory := &chaincode.Category{Name: "1"}
 	bytes, _ := json.Marshal(tmpCategory)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
-	err = category.UpdateCategory(transactionContext, string(sampleInput2))
+	err = category.UpdateCategory(transactionContext, samplePatch2)
 	require.NoError(t, err)
 
 	err = category.UpdateCategory(transactionContext, "sad")
@@ -244,7 +292,7 @@ func TestUpdateCategory(t *testing.T) {
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = category.UpdateCategory(transactionContext, string(sampleInput2))
+	err = category.UpdateCategory(transactionContext, samplePatch2)
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
@@ -262,7 +310,7 @@ func TestGetAllCategorys(t *testing.T) {
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	userprofile := &chaincode.SmartContract{}
 	assets, err := userprofile.GetAllCategorys(transactionContext)
 	require.NoError(t, err)
@@ -274,7 +322,7 @@ func TestGetAllCategorys(t *testing.T) {
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, assets)
 
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
 	assets, err = userprofile.GetAllCategorys(transactionContext)
 	require.EqualError(t, err, "failed retrieving all assets")
 	require.Nil(t, assets)
@@ -282,11 +330,14 @@ func TestGetAllCategorys(t *testing.T) {
 
 var sampleCategoryGroup = &chaincode.CategoryGroup{
 	Name:  "categoryGroup1",
-	Color: "1",
+	Color: "#111",
 }
 
 var sampleInput3, _ = json.Marshal(sampleCategoryGroup)
 
+// samplePatch3 changes the fields of sampleCategoryGroup clients may update to their own values.
+const samplePatch3 = `{"name":"categoryGroup1","color":"#111"}`
+
 func TestCreateCategoryGroup(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	categoryGroup := chaincode.SmartContract{}
@@ -338,18 +389,18 @@ func TestUpdateCategoryGroup(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	categoryGroup := chaincode.SmartContract{}
 
-	err := categoryGroup.UpdateCategoryGroup(transactionContext, string(sampleInput3))
+	err := categoryGroup.UpdateCategoryGroup(transactionContext, samplePatch3)
 	require.EqualError(t, err, "the categoryGroup categoryGroup1 does not exist")
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = categoryGroup.UpdateCategoryGroup(transactionContext, string(sampleInput3))
+	err = categoryGroup.UpdateCategoryGroup(transactionContext, samplePatch3)
 	require.EqualError(t, err, "failed to read from world state: failure")
 
 	tmpCategoryGroup := &chaincode.CategoryGroup{Name: "1"}
 	bytes, _ := json.Marshal(tmpCategoryGroup)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
-	err = categoryGroup.UpdateCategoryGroup(transactionContext, string(sampleInput3))
+	err = categoryGroup.UpdateCategoryGroup(transactionContext, samplePatch3)
 	require.NoError(t, err)
 
 	err = categoryGroup.UpdateCategoryGroup(transactionContext, "sad")
@@ -360,7 +411,7 @@ func TestUpdateCategoryGroup(t *testing.T) {
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = categoryGroup.UpdateCategoryGroup(transactionContext, string(sampleInput3))
+	err = categoryGroup.UpdateCategoryGroup(transactionContext, samplePatch3)
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
@@ -378,7 +429,7 @@ func TestGetAllCategoryGroups(t *testing.T) {
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	userprofile := &chaincode.SmartContract{}
 	assets, err := userprofile.GetAllCategoryGroups(transactionContext)
 	require.NoError(t, err)
@@ -390,12 +441,355 @@ func TestGetAllCategoryGroups(t *testing.T) {
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, assets)
 
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
 	assets, err = userprofile.GetAllCategoryGroups(transactionContext)
 	require.EqualError(t, err, "failed retrieving all assets")
 	require.Nil(t, assets)
 }
 
+func TestUpdateMergePatch(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := chaincode.SmartContract{}
+
+	stored, _ := json.Marshal(&chaincode.CategoryGroup{Name: "1", Color: "#f00", Categories: []string{"1"}, CreatedAt: 1})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err := plug.UpdateCategoryGroup(transactionContext, `{"name":"1","color":null,"categories":[],"createdAt":2}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field createdAt may not be changed")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	err = plug.UpdateCategoryGroup(transactionContext, `{"name":"1","color":null,"categories":[]}`)
+	require.NoError(t, err)
+
+	_, categoryGroupJSON := chaincodeStub.PutStateArgsForCall(0)
+	updated := &chaincode.CategoryGroup{}
+	json.Unmarshal(categoryGroupJSON, updated)
+	require.Equal(t, "", updated.Color)
+	require.Equal(t, []string{}, updated.Categories)
+	require.Equal(t, int64(1), updated.CreatedAt)
+
+	stored, _ = json.Marshal(&chaincode.Tag{Name: "1", CreatorWallet: myOrg2Clientid, Description: "1"})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err = plug.UpdateTag(transactionContext, `{"name":"1","description":""}`)
+	require.NoError(t, err)
+
+	_, tagJSON := chaincodeStub.PutStateArgsForCall(1)
+	updatedTag := &chaincode.Tag{}
+	json.Unmarshal(tagJSON, updatedTag)
+	require.Equal(t, "", updatedTag.Description)
+	require.Equal(t, myOrg2Clientid, updatedTag.CreatorWallet)
+
+	err = plug.UpdateCategory(transactionContext, `{"name":"1","color":1}`)
+	require.EqualError(t, err, "json: cannot unmarshal number into Go struct field .color of type string")
+}
+
+func TestValidate(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := chaincode.SmartContract{}
+
+	err := plug.CreateTag(transactionContext, `{"name":"1","colour":"#fff"}`)
+	require.EqualError(t, err, `json: unknown field "colour"`)
+
+	err = plug.CreateCategory(transactionContext, `{"name":"1"},`)
+	require.EqualError(t, err, "invalid data after the payload")
+
+	err = plug.CreateTag(transactionContext, `{"description":"1"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the name is required")
+
+	err = plug.CreateTag(transactionContext, `{"name":"1","description":"`+strings.Repeat("1", 257)+`"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the description is longer than 256 characters")
+
+	err = plug.CreateCategory(transactionContext, `{"name":"1","color":"red"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the color is not a hex color")
+
+	err = plug.CreateCategoryGroup(transactionContext, `{"name":"1","color":"#ABCDEF","categories":["1\t2"]}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the categories contains control characters")
+
+	stored, _ := json.Marshal(&chaincode.Category{Name: "1", Color: "#fff"})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err = plug.UpdateCategory(transactionContext, `{"name":"1","color":"#ffff"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the color is not a hex color")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	err = plug.SetPermissions(transactionContext, `{"taxonomy":{"anyone":true},"posts":{}}`)
+	require.EqualError(t, err, `json: unknown field "posts"`)
+}
+
+func TestVersions(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := chaincode.SmartContract{}
+
+	err := plug.CreateCategory(transactionContext, `{"name":"1","version":7}`)
+	require.NoError(t, err)
+
+	_, categoryJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Category{}
+	json.Unmarshal(categoryJSON, stored)
+	require.Equal(t, uint64(1), stored.Version)
+
+	chaincodeStub.GetStateReturns(categoryJSON, nil)
+	err = plug.UpdateCategory(transactionContext, `{"name":"1","color":"#f00","expectedVersion":1}`)
+	require.NoError(t, err)
+
+	_, categoryJSON = chaincodeStub.PutStateArgsForCall(1)
+	stored = &chaincode.Category{}
+	json.Unmarshal(categoryJSON, stored)
+	require.Equal(t, uint64(2), stored.Version)
+
+	chaincodeStub.GetStateReturns(categoryJSON, nil)
+	err = plug.UpdateCategory(transactionContext, `{"name":"1","color":"#00f","expectedVersion":1}`)
+	require.ErrorIs(t, err, chaincode.ErrVersionConflict)
+	require.EqualError(t, err, "ERR_VERSION_CONFLICT: the category 1 is at version 2, not 1")
+}
+
+func TestTimestamps(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := chaincode.SmartContract{}
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	err := plug.CreateCategory(transactionContext, `{"name":"1","createdAt":1,"updatedAt":1}`)
+	require.NoError(t, err)
+
+	_, categoryJSON := chaincodeStub.PutStateArgsForCall(0)
+	var created chaincode.Category
+	json.Unmarshal(categoryJSON, &created)
+	require.Equal(t, int64(1000000), created.CreatedAt)
+	require.Equal(t, int64(1000000), created.UpdatedAt)
+
+	chaincodeStub.GetStateReturns(categoryJSON, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	err = plug.UpdateCategory(transactionContext, `{"name":"1","color":"#f00","createdAt":1}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+
+	err = plug.UpdateCategory(transactionContext, `{"name":"1","color":"#f00"}`)
+	require.NoError(t, err)
+
+	_, categoryJSON = chaincodeStub.PutStateArgsForCall(1)
+	var updated chaincode.Category
+	json.Unmarshal(categoryJSON, &updated)
+	require.Equal(t, int64(1000000), updated.CreatedAt)
+	require.Equal(t, int64(2000000), updated.UpdatedAt)
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	chaincodeStub.GetTxTimestampReturns(nil, fmt.Errorf("failure"))
+	err = plug.CreateTag(transactionContext, string(sampleInput1))
+	require.EqualError(t, err, "failed to read transaction timestamp: failure")
+}
+
+func TestPermissions(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	plug := chaincode.SmartContract{}
+
+	permissions, err := plug.GetPermissions(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, []string{"admin"}, permissions.Taxonomy.Roles)
+	require.Equal(t, []string{"admin"}, permissions.Tag.Roles)
+	require.Equal(t, []string{"moderate"}, permissions.Tag.Permissions)
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "moderator"), "moderate")
+	err = plug.CreateTag(transactionContext, string(sampleInput1))
+	require.EqualError(t, err, "the creator myOrg1Userid does not match the submitting client myOrg2Userid")
+
+	tagInput, _ := json.Marshal(&chaincode.Tag{Name: "tag2"})
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.NoError(t, err)
+
+	err = plug.CreateCategory(transactionContext, string(sampleInput2))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to change the taxonomy")
+
+	err = plug.UpdateCategoryGroup(transactionContext, samplePatch3)
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to change the taxonomy")
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid))
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to create tags")
+
+	// the roles of a rotated profile stay with its new wallet
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse("wallet2", "moderator"))
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to create tags")
+
+	chaincodeStub.InvokeChaincodeStub = nil
+	chaincodeStub.InvokeChaincodeReturns(peer.Response{Status: shim.ERROR, Message: "the user myOrg2Userid does not exist"})
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.EqualError(t, err, "failed to query userprofile: the user myOrg2Userid does not exist")
+
+	err = plug.SetPermissions(transactionContext, "{}")
+	require.EqualError(t, err, "failed to query userprofile: the user myOrg2Userid does not exist")
+
+	stored, _ := json.Marshal(&chaincode.Permissions{
+		Taxonomy: chaincode.Rule{MSPIDs: []string{myOrg2Msp}},
+		Tag:      chaincode.Rule{Anyone: true},
+	})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err = plug.CreateCategory(transactionContext, string(sampleInput2))
+	require.EqualError(t, err, "the category category1 already exists")
+
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.EqualError(t, err, "the tag tag2 already exists")
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(stored, nil)
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid))
+	err = plug.SetPermissions(transactionContext, string(stored))
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to change the taxonomy")
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetMSPIDReturns(myOrg1Msp, nil)
+	clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+		switch name {
+		case "wallet":
+			return myOrg1Clientid, true, nil
+		case "plug.admin":
+			return "true", true, nil
+		}
+		return "", false, nil
+	}
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	stored, _ = json.Marshal(&chaincode.Permissions{
+		Taxonomy: chaincode.Rule{Attributes: []string{"plug.admin"}},
+	})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err = plug.SetPermissions(transactionContext, string(stored))
+	require.NoError(t, err)
+
+	err = plug.SetPermissions(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+
+	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	err = plug.SetPermissions(transactionContext, string(stored))
+	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("failure"))
+	_, err = plug.GetPermissions(transactionContext)
+	require.EqualError(t, err, "failed to read from world state: failure")
+}
+
+func TestPermissionDefaults(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := chaincode.SmartContract{}
+
+	chaincodeStub.GetStateReturns([]byte(`{"tag":{"roles":["x"]}}`), nil)
+	permissions, err := plug.GetPermissions(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, []string{"x"}, permissions.Tag.Roles)
+
+	// the stored rules read before leave the defaults alone
+	chaincodeStub.GetStateReturns(nil, nil)
+	permissions, err = plug.GetPermissions(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, []string{"admin"}, permissions.Taxonomy.Roles)
+	require.Equal(t, []string{"admin"}, permissions.Tag.Roles)
+	require.Equal(t, []string{"moderate"}, permissions.Tag.Permissions)
+}
+
+func TestTagPermission(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	plug := chaincode.SmartContract{}
+	tagInput, _ := json.Marshal(&chaincode.Tag{Name: "tag2"})
+
+	// tags follow the permissions userprofile defines for roles, whatever their names
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "warden"), "moderate")
+	err := plug.CreateTag(transactionContext, string(tagInput))
+	require.NoError(t, err)
+
+	name, args, _ := chaincodeStub.InvokeChaincodeArgsForCall(chaincodeStub.InvokeChaincodeCallCount() - 1)
+	require.Equal(t, "userprofile", name)
+	require.Equal(t, [][]byte{[]byte("HasPermission"), []byte(myOrg2Clientid), []byte("moderate")}, args)
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "moderator"))
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to create tags")
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid), "moderate", "other")
+	stored, _ := json.Marshal(&chaincode.Permissions{Tag: chaincode.Rule{Permissions: []string{"other"}}})
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if key == "\x00config\x00permissions\x00" {
+			return stored, nil
+		}
+		return nil, nil
+	}
+	err = plug.CreateTag(transactionContext, string(tagInput))
+	require.NoError(t, err)
+	_, args, _ = chaincodeStub.InvokeChaincodeArgsForCall(chaincodeStub.InvokeChaincodeCallCount() - 1)
+	require.Equal(t, "other", string(args[2]))
+}
+
+func TestGetAllWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := &chaincode.SmartContract{}
+
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, false)
+	iterator.NextReturns(&queryresult.KV{Value: sampleInput1}, nil)
+	metadata := &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "next"}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(iterator, metadata, nil)
+
+	tags, err := plug.GetAllTagsWithPagination(transactionContext, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.TagPage{Records: []*chaincode.Tag{sampleTag}, FetchedRecordsCount: 1, Bookmark: "next"}, tags)
+
+	objectType, _, pageSize, _ := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, "tag~name", objectType)
+	require.Equal(t, int32(1), pageSize)
+
+	categories, err := plug.GetAllCategorysWithPagination(transactionContext, 1, "next")
+	require.NoError(t, err)
+	require.Equal(t, "next", categories.Bookmark)
+	objectType, _, _, _ = chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(1)
+	require.Equal(t, "category~name", objectType)
+
+	_, err = plug.GetAllCategoryGroupsWithPagination(transactionContext, 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = plug.GetAllCategoryGroupsWithPagination(transactionContext, 1, "")
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	plug := chaincode.SmartContract{}
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid))
+	_, err := plug.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to change the taxonomy")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, true)
+	iterator.HasNextReturnsOnCall(3, true)
+	iterator.HasNextReturnsOnCall(4, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Key: "tag1", Value: sampleInput1}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Key: "category1", Value: sampleInput2}, nil)
+	iterator.NextReturnsOnCall(2, &queryresult.KV{Key: "categoryGroup1", Value: sampleInput3}, nil)
+	iterator.NextReturnsOnCall(3, &queryresult.KV{Key: "sad", Value: []byte("sad")}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+
+	migrated, err := plug.MigrateKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 3, migrated)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00tag~name\x00tag1\x00", key)
+	require.Equal(t, sampleInput1, value)
+	key, _ = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00category~name\x00category1\x00", key)
+	key, _ = chaincodeStub.PutStateArgsForCall(2)
+	require.Equal(t, "\x00categoryGroup~name\x00categoryGroup1\x00", key)
+	require.Equal(t, 3, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "tag1", chaincodeStub.DelStateArgsForCall(0))
+
+	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = plug.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
 func prepMocksAsOrg1() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	return prepMocks(myOrg1Msp, myOrg1Clientid)
 }
@@ -404,6 +798,7 @@ func prepMocksAsOrg2() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 }
 func prepMocks(orgMSP, clientId string) (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
@@ -413,9 +808,31 @@ func prepMocks(orgMSP, clientId string) (*mocks.TransactionContext, *mocks.Chain
 	// set matching msp ID using peer shim env variable
 	os.Setenv("CORE_PEER_LOCALMSPID", orgMSP)
 	transactionContext.GetClientIdentityReturns(clientIdentity)
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(clientId, "admin"))
 	return transactionContext, chaincodeStub
 }
 
+// userprofileStub answers the invocations of userprofile with the user, and whether the
+// user holds a permission with the permissions given.
+func userprofileStub(user peer.Response, permissions ...string) func(string, [][]byte, string) peer.Response {
+	return func(name string, args [][]byte, channel string) peer.Response {
+		if string(args[0]) != "HasPermission" {
+			return user
+		}
+		for _, permission := range permissions {
+			if permission == string(args[2]) {
+				return shim.Success([]byte("true"))
+			}
+		}
+		return shim.Success([]byte("false"))
+	}
+}
+
+func profileResponse(wallet string, roles ...string) peer.Response {
+	user, _ := json.Marshal(map[string]interface{}{"wallet": wallet, "rolesAssigned": roles})
+	return shim.Success(user)
+}
+
 func prepMocksIllegalId() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
@@ -0,0 +1,34 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// getTxTime returns the time the transaction was proposed in milliseconds since the
+// epoch. Every endorser reads the same time, unlike the clock of the peer.
+func getTxTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime().UnixMilli(), nil
+}
+
+// touch stamps an entity written by the transaction: the version is bumped and the
+// update time set to the transaction time, which is also the creation time of a new
+// entity.
+func touch(ctx contractapi.TransactionContextInterface, version *uint64, createdAt *int64, updatedAt *int64) error {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *createdAt == 0 {
+		*createdAt = now
+	}
+	*updatedAt = now
+	*version++
+	return nil
+}
@@ -0,0 +1,114 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidPayload is returned when a payload breaks the rules for the fields of a tag,
+// category or category group.
+var ErrInvalidPayload = errors.New("ERR_INVALID_PAYLOAD")
+
+// Limits on the fields of tags, categories and category groups.
+const (
+	maxNameLength        = 32
+	maxDescriptionLength = 256
+	maxCategories        = 64
+)
+
+// colorPattern matches hex colors such as #fff and #ff8800.
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// decodePayload decodes the JSON payload into v, rejecting fields v does not have and
+// anything following the payload.
+func decodePayload(payload string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after the payload")
+	}
+	return nil
+}
+
+// validate returns ErrInvalidPayload when a field of the tag breaks its rules.
+func (t *Tag) validate() error {
+	return firstError(
+		checkText("name", t.Name, maxNameLength, true),
+		checkText("description", t.Description, maxDescriptionLength, false),
+	)
+}
+
+// validate returns ErrInvalidPayload when a field of the category breaks its rules.
+func (c *Category) validate() error {
+	return firstError(
+		checkText("name", c.Name, maxNameLength, true),
+		checkText("categoryGroupName", c.CategoryGroupName, maxNameLength, false),
+		checkColor("color", c.Color),
+	)
+}
+
+// validate returns ErrInvalidPayload when a field of the category group breaks its rules.
+func (g *CategoryGroup) validate() error {
+	return firstError(
+		checkText("name", g.Name, maxNameLength, true),
+		checkColor("color", g.Color),
+		checkList("categories", g.Categories, maxCategories, maxNameLength),
+	)
+}
+
+// checkText checks the value is at most max characters long and free of control characters.
+func checkText(field string, value string, max int, required bool) error {
+	if value == "" && required {
+		return fmt.Errorf("%w: the %s is required", ErrInvalidPayload, field)
+	}
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%w: the %s is longer than %d characters", ErrInvalidPayload, field, max)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: the %s contains control characters", ErrInvalidPayload, field)
+	}
+	return nil
+}
+
+// checkList checks the list holds at most max names, each at most maxLength characters long.
+func checkList(field string, values []string, max int, maxLength int) error {
+	if len(values) > max {
+		return fmt.Errorf("%w: the %s hold more than %d values", ErrInvalidPayload, field, max)
+	}
+	for _, value := range values {
+		err := checkText(field, value, maxLength, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColor checks the value is empty or a hex color.
+func checkColor(field string, value string) error {
+	if value != "" && !colorPattern.MatchString(value) {
+		return fmt.Errorf("%w: the %s is not a hex color", ErrInvalidPayload, field)
+	}
+	return nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrVersionConflict is returned when an entity changed since the version a client expected.
+var ErrVersionConflict = errors.New("ERR_VERSION_CONFLICT")
+
+// Expectation carries the version of a tag, category or category group a client last
+// read. Updates fail with ErrVersionConflict when the stored version differs, and skip
+// the check when no version is expected.
+type Expectation struct {
+	ExpectedVersion *uint64 `json:"expectedVersion,omitempty"`
+}
+
+// check returns ErrVersionConflict unless the current version of the named entity of
+// the kind is the expected one.
+func (e *Expectation) check(kind string, name string, current uint64) error {
+	if e.ExpectedVersion != nil && *e.ExpectedVersion != current {
+		return fmt.Errorf("%w: the %s %s is at version %d, not %d", ErrVersionConflict, kind, name, current, *e.ExpectedVersion)
+	}
+	return nil
+}
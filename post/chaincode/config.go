@@ -0,0 +1,87 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Config holds the settings of the chaincode admins may change.
+type Config struct {
+	// MaxReplyDepth is how deeply replies may be nested below a post that replies to none.
+	MaxReplyDepth int `json:"maxReplyDepth"`
+}
+
+// defaultConfig applies until SetConfig stores other settings.
+var defaultConfig = Config{
+	MaxReplyDepth: 8,
+}
+
+// configKey returns the world state key the config is stored under.
+func configKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey("config", []string{"settings"})
+}
+
+// GetConfig returns the settings in effect.
+func (s *SmartContract) GetConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	return readConfig(ctx)
+}
+
+// readConfig returns the stored settings, falling back to the defaults.
+func readConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	key, err := configKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	configJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	config := defaultConfig
+	if configJSON != nil {
+		json.Unmarshal(configJSON, &config)
+	}
+
+	// a setting left unset falls back to its default
+	if config.MaxReplyDepth <= 0 {
+		config.MaxReplyDepth = defaultConfig.MaxReplyDepth
+	}
+
+	return &config, nil
+}
+
+// SetConfig replaces the settings. Only admins may change them.
+func (s *SmartContract) SetConfig(ctx contractapi.TransactionContextInterface, payload string) error {
+	config := Config{}
+	err := decodePayload(payload, &config)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkAdmin(ctx, "configure posts")
+	if err != nil {
+		return err
+	}
+
+	if config.MaxReplyDepth < 0 {
+		return fmt.Errorf("%w: the maxReplyDepth may not be negative", ErrInvalidPayload)
+	}
+
+	key, err := configKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	configJSON, _ := json.Marshal(config)
+	err = ctx.GetStub().PutState(key, configJSON)
+
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	return ctx.GetStub().SetEvent("SetConfig", configJSON)
+}
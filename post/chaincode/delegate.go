@@ -0,0 +1,64 @@
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Scopes of the delegates userprofile grants to wallets that cover post transactions.
+const (
+	// scopeWrite covers creating, updating, deleting and restoring posts.
+	scopeWrite = "write"
+	// scopeVote covers upvotes and downvotes.
+	scopeVote = "vote"
+	// scopeReact covers adding and removing emojis.
+	scopeReact = "react"
+	// scopeModerate covers hiding and locking posts.
+	scopeModerate = "moderate"
+)
+
+// ErrDelegateExpired is returned when an envelope is signed by a delegate past its expiry.
+var ErrDelegateExpired = errors.New("ERR_DELEGATE_EXPIRED")
+
+// delegate is a session key a wallet granted in userprofile to sign envelopes on its
+// behalf, limited to the transactions of its scopes until it expires.
+type delegate struct {
+	ID        string   `json:"id"`
+	PublicKey string   `json:"publicKey"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expiresAt"`
+}
+
+// signingKey returns the public key the envelope must be signed with. That is the key
+// of the delegate the envelope names, which must not have expired and must be granted
+// the scope, or else the key of the wallet.
+func signingKey(ctx contractapi.TransactionContextInterface, env *Envelope, user *profile, scope string) (string, error) {
+	if env.Delegate == "" {
+		if user.PublicKey == "" {
+			return "", fmt.Errorf("%w: the wallet %s has no public key registered", ErrInvalidSignature, env.Wallet)
+		}
+		return user.PublicKey, nil
+	}
+
+	for _, delegate := range user.Delegates {
+		if delegate.ID != env.Delegate {
+			continue
+		}
+
+		now, err := getTxTime(ctx)
+		if err != nil {
+			return "", err
+		}
+		if delegate.ExpiresAt <= now {
+			return "", fmt.Errorf("%w: the delegate %s of the wallet %s has expired", ErrDelegateExpired, delegate.ID, env.Wallet)
+		}
+		if !contains(delegate.Scopes, scope) {
+			return "", fmt.Errorf("%w: the delegate %s of the wallet %s may not sign %s", ErrInvalidSignature, delegate.ID, env.Wallet, env.Function)
+		}
+		return delegate.PublicKey, nil
+	}
+
+	return "", fmt.Errorf("%w: the wallet %s has no delegate %s", ErrInvalidSignature, env.Wallet, env.Delegate)
+}
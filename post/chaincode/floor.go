@@ -0,0 +1,157 @@
+package chaincode
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// floorCounter keys hold the last floor number handed out in a topic.
+	floorCounter = "floorCounter~belongTo"
+	// floorIndex keys map a floor of a topic to the hash of its post. They are kept when
+	// the post is deleted or hidden so that floor numbers are never reused.
+	floorIndex = "belongTo~floor"
+
+	// maxFloorRange is how many floors GetPostsByFloorRange reads at once.
+	maxFloorRange = 100
+)
+
+// floorKey returns the floorIndex key of the floor. Floors are zero-padded so that the
+// keys of a topic sort by floor.
+func floorKey(ctx contractapi.TransactionContextInterface, topicHash string, floor uint64) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(floorIndex, []string{topicHash, fmt.Sprintf("%020d", floor)})
+}
+
+// assignFloor numbers the post with the next floor of its topic and records the floor
+// in the floor index. Posts outside topics have no floor.
+func assignFloor(ctx contractapi.TransactionContextInterface, post *Post) error {
+	post.Floor = 0
+	if post.BelongTo == "" {
+		return nil
+	}
+
+	last, err := readFloorCounter(ctx, post.BelongTo)
+	if err != nil {
+		return err
+	}
+	post.Floor = last + 1
+
+	err = putFloorCounter(ctx, post.BelongTo, post.Floor)
+	if err != nil {
+		return err
+	}
+	return putFloor(ctx, post)
+}
+
+// readFloorCounter returns the last floor handed out in the topic, or 0 when none was.
+func readFloorCounter(ctx contractapi.TransactionContextInterface, topicHash string) (uint64, error) {
+	counterKey, err := ctx.GetStub().CreateCompositeKey(floorCounter, []string{topicHash})
+	if err != nil {
+		return 0, err
+	}
+
+	counterJSON, err := ctx.GetStub().GetState(counterKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if counterJSON == nil {
+		return 0, nil
+	}
+
+	last, err := strconv.ParseUint(string(counterJSON), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("the floor counter of the topic %s is malformed: %v", topicHash, err)
+	}
+	return last, nil
+}
+
+// putFloorCounter records last as the last floor handed out in the topic.
+func putFloorCounter(ctx contractapi.TransactionContextInterface, topicHash string, last uint64) error {
+	counterKey, err := ctx.GetStub().CreateCompositeKey(floorCounter, []string{topicHash})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(counterKey, []byte(strconv.FormatUint(last, 10)))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// putFloor records the floor of the post in the floor index.
+func putFloor(ctx contractapi.TransactionContextInterface, post *Post) error {
+	key, err := floorKey(ctx, post.BelongTo, post.Floor)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, []byte(post.Hash))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// deleteFloor removes the floor index entry of a post being purged. The floor counter
+// is left alone, so the floor stays empty rather than being handed out again.
+func deleteFloor(ctx contractapi.TransactionContextInterface, post *Post) error {
+	if post.Floor == 0 {
+		return nil
+	}
+
+	key, err := floorKey(ctx, post.BelongTo, post.Floor)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+	return nil
+}
+
+// GetPostsByFloorRange returns the posts of the topic on the floors from to to, both
+// included, ordered by floor. Floors of deleted, hidden and purged posts are skipped.
+func (s *SmartContract) GetPostsByFloorRange(ctx contractapi.TransactionContextInterface, topicHash string, from uint64, to uint64) ([]*Post, error) {
+	if from == 0 || from > to {
+		return nil, fmt.Errorf("the floor range %d to %d is invalid", from, to)
+	}
+	if to-from >= maxFloorRange {
+		return nil, fmt.Errorf("the floor range %d to %d spans more than %d floors", from, to, maxFloorRange)
+	}
+
+	var posts []*Post
+	for floor := from; floor <= to; floor++ {
+		key, err := floorKey(ctx, topicHash, floor)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if hash == nil {
+			continue
+		}
+
+		post, err := readPost(ctx, string(hash))
+		if err != nil {
+			return nil, err
+		}
+		if post.isListed() {
+			posts = append(posts, post)
+		}
+	}
+
+	err := fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
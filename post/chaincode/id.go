@@ -0,0 +1,38 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// contentDigest returns the hex encoded SHA-256 digest of the creator, the CID and the
+// time in milliseconds since the epoch, separated by NUL bytes.
+func contentDigest(creator string, cid string, timestamp int64) string {
+	digest := sha256.Sum256([]byte(strings.Join([]string{creator, cid, strconv.FormatInt(timestamp, 10)}, "\x00")))
+	return hex.EncodeToString(digest[:])
+}
+
+// assignPostID sets the hash a new post is stored under. Posts created without a hash
+// are stored under the ID of the transaction creating them. A hash chosen by the client
+// must be the digest of the creator, the CID and the transaction time, so that clients
+// cannot claim IDs unrelated to their content.
+func assignPostID(ctx contractapi.TransactionContextInterface, post *Post) error {
+	if post.Hash == "" {
+		post.Hash = ctx.GetStub().GetTxID()
+		return nil
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if post.Hash != contentDigest(post.Creator, post.CID, now) {
+		return fmt.Errorf("%w: the hash %s is not the digest of the creator, cid and timestamp", ErrInvalidPayload, post.Hash)
+	}
+	return nil
+}
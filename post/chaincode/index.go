@@ -0,0 +1,180 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Secondary indexes are composite keys of an attribute value and a post hash with an
+// empty value. Unlike rich queries they work on LevelDB and are checked for phantom reads.
+const (
+	creatorIndex  = "creator~hash"
+	belongToIndex = "belongTo~hash"
+	replyToIndex  = "replyTo~hash"
+)
+
+// threadIndex lists the posts of a topic under the post they reply to, oldest first,
+// for GetThread to page through. Its entries are kept by indexThread.
+const threadIndex = "thread~belongTo~replyTo~createdAt~hash"
+
+var postIndexes = []string{creatorIndex, belongToIndex, replyToIndex}
+
+// indexValues returns the attribute values the post is listed under in the index.
+// Deleted and hidden posts are left out of every index.
+func (p *Post) indexValues(index string) []string {
+	if !p.isListed() {
+		return nil
+	}
+
+	switch index {
+	case creatorIndex:
+		return []string{p.Creator}
+	case belongToIndex:
+		return []string{p.BelongTo}
+	case replyToIndex:
+		return []string{p.ReplyTo}
+	}
+	return nil
+}
+
+// indexPost updates the index entries of a post changing from prev to next, either of
+// which may be nil for a post being created or removed.
+func indexPost(ctx contractapi.TransactionContextInterface, prev *Post, next *Post) error {
+	for _, index := range postIndexes {
+		var stale, fresh []string
+		hash := ""
+		if prev != nil {
+			stale, hash = prev.indexValues(index), prev.Hash
+		}
+		if next != nil {
+			fresh, hash = next.indexValues(index), next.Hash
+		}
+
+		for _, value := range stale {
+			if value == "" || contains(fresh, value) {
+				continue
+			}
+
+			key, err := ctx.GetStub().CreateCompositeKey(index, []string{value, hash})
+			if err != nil {
+				return err
+			}
+
+			err = ctx.GetStub().DelState(key)
+			if err != nil {
+				return fmt.Errorf("failed to delete from world state: %v", err)
+			}
+		}
+
+		for _, value := range fresh {
+			if value == "" || contains(stale, value) {
+				continue
+			}
+
+			key, err := ctx.GetStub().CreateCompositeKey(index, []string{value, hash})
+			if err != nil {
+				return err
+			}
+
+			err = ctx.GetStub().PutState(key, []byte{0x00})
+			if err != nil {
+				return fmt.Errorf("failed to put to world state: %v", err)
+			}
+		}
+	}
+
+	return indexThread(ctx, prev, next)
+}
+
+// getPostsByIndex returns the posts listed under the value in the index.
+func getPostsByIndex(ctx contractapi.TransactionContextInterface, index string, value string) ([]*Post, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{value})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	posts, err := constructPostsFromIndexIterator(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// getPostsByIndexWithPagination returns a page of at most pageSize posts listed under
+// the value in the index, starting at the bookmark.
+func getPostsByIndexWithPagination(ctx contractapi.TransactionContextInterface, index string, value string, pageSize int32, bookmark string) (*PostPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, []string{value}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	posts, err := constructPostsFromIndexIterator(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostPage{
+		Records:             posts,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// constructPostsFromIndexIterator reads the posts the index entries of the resultsIterator point at
+func constructPostsFromIndexIterator(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]*Post, error) {
+	var posts []*Post
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(attributes) != 2 {
+			return nil, fmt.Errorf("the index key %s is malformed", queryResponse.Key)
+		}
+
+		key, err := postKey(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+
+		postJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if postJSON == nil {
+			continue
+		}
+
+		var post Post
+		json.Unmarshal(postJSON, &post)
+		posts = append(posts, &post)
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,135 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// restoreWindow is how long after its deletion a post can be restored, in milliseconds.
+const restoreWindow = 30 * 24 * 60 * 60 * 1000
+
+type Restore struct {
+	Hash    string `json:"hash"`
+	Creator string `json:"creator"`
+}
+
+// isListed reports whether the post shows up in lists and queries by default.
+func (p *Post) isListed() bool {
+	return !p.Deleted && !p.Hidden
+}
+
+// RestorePost undoes the deletion of a post. The creator and moderators may restore
+// a post within the restore window after it was deleted, but only moderators undo the
+// deletions of others, such as a moderator removing the post.
+func (s *SmartContract) RestorePost(ctx contractapi.TransactionContextInterface, payload string) error {
+	restore := Restore{}
+
+	err := decodePayload(payload, &restore)
+	if err != nil {
+		return err
+	}
+
+	restore.Creator, err = resolveCreator(ctx, restore.Creator)
+	if err != nil {
+		return err
+	}
+
+	post, err := readPost(ctx, restore.Hash)
+	if err != nil {
+		return err
+	}
+	if !post.Deleted {
+		return fmt.Errorf("the post %s is not deleted", restore.Hash)
+	}
+
+	err = checkModifiable(ctx, post, restore.Creator)
+	if err != nil {
+		return err
+	}
+
+	// deletions recorded before DeletedBy was kept are left to checkModifiable
+	if post.DeletedBy != "" && post.DeletedBy != restore.Creator {
+		err = checkModerator(ctx, restore.Creator)
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	// deletions recorded before DeletedAt was kept have no known time and are not
+	// held to the restore window
+	if post.DeletedAt != 0 && now-post.DeletedAt > restoreWindow {
+		return fmt.Errorf("the post %s was deleted too long ago to be restored", restore.Hash)
+	}
+
+	post.Deleted, post.DeletedAt, post.DeletedBy = false, 0, ""
+	err = touchPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	err = putPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	restoreJSON, _ := json.Marshal(restore)
+	return ctx.GetStub().SetEvent("RestorePost", restoreJSON)
+}
+
+// PurgePost removes a post along with its index entries and votes from the world state.
+// Only admins may purge posts, and the post then remains only in the history.
+func (s *SmartContract) PurgePost(ctx contractapi.TransactionContextInterface, postId string) error {
+	err := checkAdmin(ctx, "purge posts")
+	if err != nil {
+		return err
+	}
+
+	post, err := readPost(ctx, postId)
+	if err != nil {
+		return err
+	}
+
+	err = indexPost(ctx, post, nil)
+	if err != nil {
+		return err
+	}
+
+	err = deleteFloor(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	err = deleteVotes(ctx, postId)
+	if err != nil {
+		return err
+	}
+
+	key, err := postKey(ctx, postId)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+
+	return ctx.GetStub().SetEvent("PurgePost", []byte(postId))
+}
+
+// listedPosts returns the posts that are neither deleted nor hidden.
+func listedPosts(posts []*Post) []*Post {
+	var listed []*Post
+	for _, post := range posts {
+		if post.isListed() {
+			listed = append(listed, post)
+		}
+	}
+	return listed
+}
@@ -0,0 +1,254 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrateKeys moves posts stored under their bare hash, as written before composite
+// keys were introduced, to their composite key, indexes them and moves their votes to
+// vote keys. It returns the number of posts moved.
+func (s *SmartContract) MigrateKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "migrate keys")
+	if err != nil {
+		return 0, err
+	}
+
+	// range queries only cover simple keys, so this visits legacy entries only
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var post Post
+		if json.Unmarshal(queryResponse.Value, &post) != nil || post.Hash == "" {
+			continue
+		}
+
+		err = explodeVotes(ctx, &post)
+		if err != nil {
+			return 0, err
+		}
+
+		err = putPost(ctx, &post)
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		migrated++
+	}
+
+	return migrated, ctx.GetStub().SetEvent("MigrateKeys", []byte(strconv.Itoa(migrated)))
+}
+
+// RebuildIndexes writes the index entries of every post, as needed for posts stored
+// before the indexes were introduced. It returns the number of posts indexed.
+func (s *SmartContract) RebuildIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "rebuild indexes")
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var post Post
+		json.Unmarshal(queryResponse.Value, &post)
+
+		err = indexPost(ctx, nil, &post)
+		if err != nil {
+			return 0, err
+		}
+		indexed++
+	}
+
+	return indexed, ctx.GetStub().SetEvent("RebuildIndexes", []byte(strconv.Itoa(indexed)))
+}
+
+// MigrateVotes moves the votes and emojis stored inside posts, as written before vote
+// keys were introduced, to vote keys. It returns the number of posts changed.
+func (s *SmartContract) MigrateVotes(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "migrate votes")
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var post Post
+		json.Unmarshal(queryResponse.Value, &post)
+		if len(post.Upvotes) == 0 && len(post.Downvotes) == 0 && len(post.Emojis) == 0 {
+			continue
+		}
+
+		err = explodeVotes(ctx, &post)
+		if err != nil {
+			return 0, err
+		}
+
+		err = putPost(ctx, &post)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, ctx.GetStub().SetEvent("MigrateVotes", []byte(strconv.Itoa(migrated)))
+}
+
+// BackfillFloors numbers the posts stored before floors were introduced. The posts of
+// a topic that have no floor are numbered after the floors already handed out, in the
+// order they were created, so that no post changes its floor. Posts stored before
+// timestamps were introduced are dated by the first write to them. It returns the
+// number of posts numbered.
+func (s *SmartContract) BackfillFloors(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "backfill floors")
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	topics := map[string][]*Post{}
+	var topicHashes []string
+	createdAt := map[string]int64{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var post Post
+		json.Unmarshal(queryResponse.Value, &post)
+		if post.BelongTo == "" || post.Floor != 0 {
+			continue
+		}
+
+		createdAt[post.Hash] = post.CreatedAt
+		if post.CreatedAt == 0 {
+			createdAt[post.Hash], err = createTime(ctx, post.Hash, queryResponse.Key)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if topics[post.BelongTo] == nil {
+			topicHashes = append(topicHashes, post.BelongTo)
+		}
+		topics[post.BelongTo] = append(topics[post.BelongTo], &post)
+	}
+
+	numbered := 0
+	for _, topicHash := range topicHashes {
+		posts := topics[topicHash]
+		sort.SliceStable(posts, func(i, j int) bool {
+			if createdAt[posts[i].Hash] != createdAt[posts[j].Hash] {
+				return createdAt[posts[i].Hash] < createdAt[posts[j].Hash]
+			}
+			return posts[i].Hash < posts[j].Hash
+		})
+
+		last, err := readFloorCounter(ctx, topicHash)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, post := range posts {
+			last++
+			post.Floor = last
+			err = putFloor(ctx, post)
+			if err != nil {
+				return 0, err
+			}
+
+			err = putPost(ctx, post)
+			if err != nil {
+				return 0, err
+			}
+			numbered++
+		}
+
+		err = putFloorCounter(ctx, topicHash, last)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return numbered, ctx.GetStub().SetEvent("BackfillFloors", []byte(strconv.Itoa(numbered)))
+}
+
+// createTime returns the time the post was first written, in milliseconds since the
+// epoch. Posts stored before composite keys were introduced were first written under
+// their bare hash, as the history of their composite key only starts at MigrateKeys.
+func createTime(ctx contractapi.TransactionContextInterface, postId string, key string) (int64, error) {
+	created, err := firstWriteTime(ctx, postId)
+	if err != nil || created != 0 {
+		return created, err
+	}
+	return firstWriteTime(ctx, key)
+}
+
+// firstWriteTime returns the time of the oldest write in the history of the key, in
+// milliseconds since the epoch. The history database must be enabled on the peer.
+func firstWriteTime(ctx contractapi.TransactionContextInterface, key string) (int64, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var first int64
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		timestamp := modification.Timestamp.AsTime().UnixMilli()
+		if first == 0 || timestamp < first {
+			first = timestamp
+		}
+	}
+	return first, nil
+}
@@ -0,0 +1,107 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// PostPage is a page of posts along with the bookmark the next page starts at.
+type PostPage struct {
+	Records             []*Post `json:"records"`
+	FetchedRecordsCount int32   `json:"fetchedRecordsCount"`
+	Bookmark            string  `json:"bookmark"`
+}
+
+// GetAllPostsWithPagination returns a page of at most pageSize posts starting at the bookmark.
+// Deleted and hidden posts are left out, so a page may hold fewer than pageSize posts.
+func (s *SmartContract) GetAllPostsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PostPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(postObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page, err := constructPageFromIterator(ctx, resultsIterator, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Records = listedPosts(page.Records)
+	page.FetchedRecordsCount = int32(len(page.Records))
+	return page, nil
+}
+
+func (s *SmartContract) QueryPostsByCreatorWithPagination(ctx contractapi.TransactionContextInterface, creator string, pageSize int32, bookmark string) (*PostPage, error) {
+	return getPostsByIndexWithPagination(ctx, creatorIndex, creator, pageSize, bookmark)
+}
+
+func (s *SmartContract) QueryPostsByBelongToWithPagination(ctx contractapi.TransactionContextInterface, belongTo string, pageSize int32, bookmark string) (*PostPage, error) {
+	return getPostsByIndexWithPagination(ctx, belongToIndex, belongTo, pageSize, bookmark)
+}
+
+func (s *SmartContract) QueryPostsByReplyToWithPagination(ctx contractapi.TransactionContextInterface, replyTo string, pageSize int32, bookmark string) (*PostPage, error) {
+	return getPostsByIndexWithPagination(ctx, replyToIndex, replyTo, pageSize, bookmark)
+}
+
+// QueryPostsWithPagination returns a page of at most pageSize posts matching the filter
+// in the payload, starting at the bookmark. The limit of the filter is ignored.
+func (s *SmartContract) QueryPostsWithPagination(ctx contractapi.TransactionContextInterface, payload string, pageSize int32, bookmark string) (*PostPage, error) {
+	q, err := parsePostFilter(payload)
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = 0
+
+	return getQueryResultForQueryStringWithPagination(ctx, q.String(), pageSize, bookmark)
+}
+
+// getQueryResultForQueryStringWithPagination executes the passed in query string and
+// returns a page of at most pageSize posts starting at the bookmark.
+func getQueryResultForQueryStringWithPagination(ctx contractapi.TransactionContextInterface, queryString string, pageSize int32, bookmark string) (*PostPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructPageFromIterator(ctx, resultsIterator, metadata)
+}
+
+// constructPageFromIterator constructs a page of posts from the resultsIterator and its metadata
+func constructPageFromIterator(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface, metadata *peer.QueryResponseMetadata) (*PostPage, error) {
+	posts, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostPage{
+		Records:             posts,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+func checkPageSize(pageSize int32) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("the page size %d is not positive", pageSize)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// selector is a CouchDB selector. Queries are always marshalled with encoding/json,
+// so values supplied by clients can never alter the structure of a query.
+type selector map[string]interface{}
+
+// query is a CouchDB query made of a selector and its options.
+type query struct {
+	Selector selector            `json:"selector"`
+	Sort     []map[string]string `json:"sort,omitempty"`
+	Limit    int                 `json:"limit,omitempty"`
+	UseIndex []string            `json:"use_index,omitempty"`
+}
+
+// sortIndexes maps the fields posts can be sorted by to the design document and
+// name of the index in META-INF/statedb/couchdb/indexes backing the sort.
+var sortIndexes = map[string][]string{
+	"creator":   {"_design/indexCreatorDoc", "indexCreator"},
+	"belongTo":  {"_design/indexBelongToDoc", "indexBelongTo"},
+	"replyTo":   {"_design/indexReplyToDoc", "indexReplyTo"},
+	"createdAt": {"_design/indexCreatedAtDoc", "indexCreatedAt"},
+	"updatedAt": {"_design/indexUpdatedAtDoc", "indexUpdatedAt"},
+	"floor":     {"_design/indexFloorDoc", "indexFloor"},
+}
+
+// PostFilter holds conditions a post must all satisfy to be returned. Conditions
+// left empty match every post. Deleted and hidden posts are excluded unless
+// IncludeDeleted and IncludeHidden are set.
+type PostFilter struct {
+	Creator        string `json:"creator"`
+	BelongTo       string `json:"belongTo"`
+	ReplyTo        string `json:"replyTo"`
+	IncludeDeleted bool   `json:"includeDeleted"`
+	IncludeHidden  bool   `json:"includeHidden"`
+
+	SortBy     string `json:"sortBy"`
+	Descending bool   `json:"descending"`
+	Limit      int    `json:"limit"`
+}
+
+func newQuery(s selector) *query {
+	return &query{Selector: s}
+}
+
+// sortBy orders the results by the field, which must be backed by an index.
+func (q *query) sortBy(field string, descending bool) error {
+	index, ok := sortIndexes[field]
+	if !ok {
+		return fmt.Errorf("the posts cannot be sorted by %s", field)
+	}
+
+	// CouchDB only sorts with an index whose fields the selector constrains
+	if _, ok := q.Selector[field]; !ok {
+		q.Selector[field] = selector{"$gt": nil}
+	}
+
+	direction := "asc"
+	if descending {
+		direction = "desc"
+	}
+	q.Sort = []map[string]string{{field: direction}}
+	q.UseIndex = index
+	return nil
+}
+
+func (q *query) String() string {
+	queryJSON, _ := json.Marshal(q)
+	return string(queryJSON)
+}
+
+// QueryPosts returns the posts matching the filter in the payload. It runs a rich
+// query and so requires CouchDB.
+func (s *SmartContract) QueryPosts(ctx contractapi.TransactionContextInterface, payload string) ([]*Post, error) {
+	q, err := parsePostFilter(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return getQueryResultForQueryString(ctx, q.String())
+}
+
+// parsePostFilter decodes the filter in the payload into a query.
+func parsePostFilter(payload string) (*query, error) {
+	filter := PostFilter{}
+	err := decodePayload(payload, &filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.query()
+}
+
+func (f *PostFilter) query() (*query, error) {
+	// the hash keeps the query to posts even when no other field is constrained, as the
+	// other documents in the world state have none
+	q := newQuery(selector{"hash": selector{"$exists": true}})
+	if f.Creator != "" {
+		q.Selector["creator"] = f.Creator
+	}
+	if f.BelongTo != "" {
+		q.Selector["belongTo"] = f.BelongTo
+	}
+	if f.ReplyTo != "" {
+		q.Selector["replyTo"] = f.ReplyTo
+	}
+	if !f.IncludeDeleted {
+		q.Selector["deleted"] = false
+	}
+	if !f.IncludeHidden {
+		q.Selector["hidden"] = false
+	}
+
+	if f.SortBy != "" {
+		err := q.sortBy(f.SortBy, f.Descending)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Limit < 0 {
+		return nil, fmt.Errorf("the limit %d is negative", f.Limit)
+	}
+	q.Limit = f.Limit
+
+	return q, nil
+}
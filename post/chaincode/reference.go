@@ -0,0 +1,97 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// topic is the subset of the topic Topic read by this chaincode.
+type topic struct {
+	Hash    string `json:"hash"`
+	Deleted bool   `json:"deleted"`
+	Locked  bool   `json:"locked"`
+	Hidden  bool   `json:"hidden"`
+}
+
+// readTopic queries the topic chaincode for the topic with the given hash. The topic is
+// read without its votes, which would otherwise join the read set of the transaction.
+func readTopic(ctx contractapi.TransactionContextInterface, topicId string) (*topic, error) {
+	args := [][]byte{[]byte("ReadTopicState"), []byte(topicId)}
+	response := ctx.GetStub().InvokeChaincode(topicChaincode, args, "")
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("failed to query topic: %s", response.Message)
+	}
+
+	var t topic
+	err := json.Unmarshal(response.Payload, &t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode topic: %v", err)
+	}
+
+	return &t, nil
+}
+
+// checkReferences returns an error unless the topic the post belongs to exists and is
+// open, and the post it replies to exists and is not deleted. The reply must stay within the topic of the post
+// it replies to, and replies may not be nested deeper than the configured limit.
+func checkReferences(ctx contractapi.TransactionContextInterface, post *Post) error {
+	if post.BelongTo != "" {
+		topic, err := readTopic(ctx, post.BelongTo)
+		if err != nil {
+			return err
+		}
+		if topic.Deleted {
+			return fmt.Errorf("the topic %s is deleted", post.BelongTo)
+		}
+		if topic.Locked {
+			return fmt.Errorf("the topic %s is locked", post.BelongTo)
+		}
+		if topic.Hidden {
+			return fmt.Errorf("the topic %s is hidden", post.BelongTo)
+		}
+	}
+
+	if post.ReplyTo == "" {
+		return nil
+	}
+
+	parent, err := readPost(ctx, post.ReplyTo)
+	if err != nil {
+		return err
+	}
+	if parent.Deleted {
+		return fmt.Errorf("the post %s is deleted", parent.Hash)
+	}
+	if parent.BelongTo != post.BelongTo {
+		return fmt.Errorf("the post %s belongs to another topic", parent.Hash)
+	}
+
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// walk up the replies to the post starting the thread
+	visited := map[string]bool{post.Hash: true}
+	depth := 1
+	for ancestor := parent; ancestor.ReplyTo != ""; depth++ {
+		if depth >= config.MaxReplyDepth {
+			return fmt.Errorf("replies may not be nested deeper than %d levels", config.MaxReplyDepth)
+		}
+
+		visited[ancestor.Hash] = true
+		if visited[ancestor.ReplyTo] {
+			return fmt.Errorf("the post %s is part of a reply cycle", ancestor.ReplyTo)
+		}
+
+		ancestor, err = readPost(ctx, ancestor.ReplyTo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package chaincode
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Signature schemes of the public keys wallets register.
+const (
+	schemeEd25519   = "ed25519"
+	schemeSecp256k1 = "secp256k1"
+)
+
+// publicKey is the public key registered to a wallet. It is written as the scheme and
+// the base64 encoded key separated by a colon, such as "ed25519:<key>".
+type publicKey struct {
+	scheme    string
+	ed25519   ed25519.PublicKey
+	secp256k1 *secp256k1.PublicKey
+}
+
+// parsePublicKey decodes a public key written as its scheme and base64 encoded key.
+func parsePublicKey(value string) (*publicKey, error) {
+	scheme, encoded, found := strings.Cut(value, ":")
+	if !found {
+		return nil, fmt.Errorf("the public key %q lacks its scheme", value)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64 decode the public key: %v", err)
+	}
+
+	switch scheme {
+	case schemeEd25519:
+		if len(data) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("an ed25519 key must be %d bytes long", ed25519.PublicKeySize)
+		}
+		return &publicKey{scheme: scheme, ed25519: data}, nil
+	case schemeSecp256k1:
+		key, err := secp256k1.ParsePubKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return &publicKey{scheme: scheme, secp256k1: key}, nil
+	}
+	return nil, fmt.Errorf("unsupported signature scheme %q", scheme)
+}
+
+// verify reports whether the signature is the key's signature of the message. Ed25519
+// signs the message itself, secp256k1 signs its SHA-256 digest with ECDSA and writes
+// the signature as r || s.
+func (k *publicKey) verify(message []byte, signature []byte) bool {
+	switch k.scheme {
+	case schemeEd25519:
+		return len(signature) == ed25519.SignatureSize && ed25519.Verify(k.ed25519, message, signature)
+	case schemeSecp256k1:
+		digest := sha256.Sum256(message)
+		return verifySecp256k1(k.secp256k1, digest[:], signature)
+	}
+	return false
+}
+
+// verifySecp256k1 reports whether the 64 byte signature r || s is a valid ECDSA
+// signature of the digest by the key.
+func verifySecp256k1(key *secp256k1.PublicKey, digest []byte, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+
+	var r, s secp256k1.ModNScalar
+	if r.SetByteSlice(signature[:32]) || s.SetByteSlice(signature[32:]) || r.IsZero() || s.IsZero() {
+		return false
+	}
+	return ecdsa.NewSignature(&r, &s).Verify(digest, key)
+}
@@ -3,7 +3,6 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
-	"reflect"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -21,13 +20,35 @@ type Post struct {
 	BelongTo string   `json:"belongTo"`
 	Assets   []string `json:"assets,omitempty"`
 
+	// Floor numbers the posts of a topic in the order they were created, starting at 1.
+	// Posts outside topics have no floor.
+	Floor uint64 `json:"floor"`
+
 	Deleted bool `json:"deleted"`
+	Hidden  bool `json:"hidden"`
+	Locked  bool `json:"locked"`
+
+	DeletedAt    int64  `json:"deletedAt"`
+	DeletedBy    string `json:"deletedBy"`
+	HiddenReason string `json:"hiddenReason"`
+	HiddenBy     string `json:"hiddenBy"`
+
+	Version   uint64 `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
 
 	Upvotes   []string            `json:"upvotes,omitempty"`
 	Downvotes []string            `json:"downvotes,omitempty"`
 	Emojis    map[string][]string `json:"emojis,omitempty"`
 }
 
+// postFields are the fields of a post clients may change through UpdatePost.
+var postFields = []string{"cid", "assets"}
+
+// postKeys are the fields of an UpdatePost payload naming the post, its creator and
+// the version the patch was made against.
+var postKeys = []string{"hash", "creator", "expectedVersion"}
+
 type Upvote struct {
 	Hash    string `json:"hash"`
 	Creator string `json:"creator"`
@@ -47,38 +68,117 @@ type Emoji struct {
 type Delete struct {
 	Hash    string `json:"hash"`
 	Creator string `json:"creator"`
+	Expectation
+}
+
+type Hide struct {
+	Hash    string `json:"hash"`
+	Creator string `json:"creator"`
+	Hidden  bool   `json:"hidden"`
+	Reason  string `json:"reason"`
 }
 
-// CreatePost creates a post.
-func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface, payload string) error {
+type Lock struct {
+	Hash    string `json:"hash"`
+	Creator string `json:"creator"`
+	Locked  bool   `json:"locked"`
+}
+
+// CreatePost creates a post and returns the hash it is stored under. The hash is
+// assigned by the chaincode when the payload does not carry one, see assignPostID.
+func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface, payload string) (string, error) {
 
 	post := Post{}
-	err := json.Unmarshal([]byte(payload), &post)
+	err := decodePayload(payload, &post)
 
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	post.Creator, err = resolveCreator(ctx, post.Creator)
+	if err != nil {
+		return "", err
+	}
+
+	err = assignPostID(ctx, &post)
+	if err != nil {
+		return "", err
+	}
+
+	err = post.validate()
+	if err != nil {
+		return "", err
 	}
 
 	exists, err := s.PostExists(ctx, post.Hash)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if exists {
-		return fmt.Errorf("the post %s already exists", post.Hash)
+		return "", fmt.Errorf("the post %s already exists", post.Hash)
 	}
 
-	err = ctx.GetStub().PutState(post.Hash, []byte(payload))
+	err = checkActive(ctx, post.Creator)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return "", err
+	}
+
+	err = checkReferences(ctx, &post)
+	if err != nil {
+		return "", err
+	}
+
+	// votes are cast through their own transactions
+	post.Upvotes, post.Downvotes, post.Emojis = nil, nil, nil
+
+	// deletion, hiding and locking are moderation actions of their own transactions
+	post.Deleted, post.DeletedAt, post.DeletedBy = false, 0, ""
+	post.Hidden, post.HiddenReason, post.HiddenBy = false, "", ""
+	post.Locked = false
+
+	// versions and timestamps are kept by the chaincode, never taken from the client
+	post.Version = 0
+	post.CreatedAt, post.UpdatedAt = 0, 0
+	err = touchPost(ctx, &post)
+	if err != nil {
+		return "", err
+	}
+
+	err = assignFloor(ctx, &post)
+	if err != nil {
+		return "", err
+	}
+
+	err = putPost(ctx, &post)
+	if err != nil {
+		return "", err
+	}
+
+	if post.BelongTo != "" {
+		err = renewTopic(ctx, post.BelongTo)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	postJSON, _ := json.Marshal(post)
+	err = ctx.GetStub().SetEvent("CreatePost", postJSON)
+	if err != nil {
+		return "", err
 	}
 
-	return ctx.GetStub().SetEvent("CreatePost", []byte(payload))
+	return post.Hash, nil
 }
 
 func (s *SmartContract) DeletePost(ctx contractapi.TransactionContextInterface, payload string) error {
 	delete := Delete{}
 
-	err := json.Unmarshal([]byte(payload), &delete)
+	err := decodePayload(payload, &delete)
+	if err != nil {
+		return err
+	}
+
+	delete.Creator, err = resolveCreator(ctx, delete.Creator)
 	if err != nil {
 		return err
 	}
@@ -92,24 +192,128 @@ func (s *SmartContract) DeletePost(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the post %s does not exist", delete.Hash)
 	}
 
-	post, _ := s.ReadPost(ctx, delete.Hash)
-	if post.Creator != delete.Creator {
-		return fmt.Errorf("the post %s is not created by %s", delete.Hash, delete.Creator)
+	post, _ := readPost(ctx, delete.Hash)
+	err = checkModifiable(ctx, post, delete.Creator)
+	if err != nil {
+		return err
 	}
 
-	post.Deleted = true
-	postJSON, _ := json.Marshal(post)
-	err = ctx.GetStub().PutState(delete.Hash, postJSON)
+	err = delete.check(post.Hash, post.Version)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("DeletePost", []byte(payload))
+	post.Deleted, post.DeletedBy = true, delete.Creator
+	err = touchPost(ctx, post)
+	if err != nil {
+		return err
+	}
+	post.DeletedAt = post.UpdatedAt
+
+	err = putPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	deleteJSON, _ := json.Marshal(delete)
+	return ctx.GetStub().SetEvent("DeletePost", deleteJSON)
+}
+
+// HidePost hides or reveals a post, recording the reason and the moderator hiding it.
+// Only moderators and admins may hide posts, and hiding one requires a reason.
+func (s *SmartContract) HidePost(ctx contractapi.TransactionContextInterface, payload string) error {
+	hide := Hide{}
+
+	err := decodePayload(payload, &hide)
+	if err != nil {
+		return err
+	}
+
+	err = checkText("reason", hide.Reason, maxReasonLength, hide.Hidden)
+	if err != nil {
+		return err
+	}
+
+	hide.Creator, err = resolveCreator(ctx, hide.Creator)
+	if err != nil {
+		return err
+	}
+
+	post, err := readPost(ctx, hide.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = checkModerator(ctx, hide.Creator)
+	if err != nil {
+		return err
+	}
+
+	post.Hidden, post.HiddenReason, post.HiddenBy = hide.Hidden, "", ""
+	if hide.Hidden {
+		post.HiddenReason, post.HiddenBy = hide.Reason, hide.Creator
+	}
+	err = touchPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	err = putPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	hideJSON, _ := json.Marshal(hide)
+	return ctx.GetStub().SetEvent("HidePost", hideJSON)
+}
+
+// LockPost locks or unlocks a post. Locked posts can only be changed by moderators and admins.
+func (s *SmartContract) LockPost(ctx contractapi.TransactionContextInterface, payload string) error {
+	lock := Lock{}
+
+	err := decodePayload(payload, &lock)
+	if err != nil {
+		return err
+	}
+
+	lock.Creator, err = resolveCreator(ctx, lock.Creator)
+	if err != nil {
+		return err
+	}
+
+	post, err := readPost(ctx, lock.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = checkModerator(ctx, lock.Creator)
+	if err != nil {
+		return err
+	}
+
+	post.Locked = lock.Locked
+	err = touchPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	err = putPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	lockJSON, _ := json.Marshal(lock)
+	return ctx.GetStub().SetEvent("LockPost", lockJSON)
 }
 
 // PostExists returns true when post with given ID exists in world state
 func (s *SmartContract) PostExists(ctx contractapi.TransactionContextInterface, postId string) (bool, error) {
-	postJSON, err := ctx.GetStub().GetState(postId)
+	key, err := postKey(ctx, postId)
+	if err != nil {
+		return false, err
+	}
+
+	postJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -119,25 +323,34 @@ func (s *SmartContract) PostExists(ctx contractapi.TransactionContextInterface,
 
 // ReadPost returns the post stored in the world state with given id.
 func (s *SmartContract) ReadPost(ctx contractapi.TransactionContextInterface, postId string) (*Post, error) {
-	postJSON, err := ctx.GetStub().GetState(postId)
+	post, err := readPost(ctx, postId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %v", err)
-	}
-	if postJSON == nil {
-		return nil, fmt.Errorf("the post %s does not exist", postId)
+		return nil, err
 	}
 
-	var post Post
-	json.Unmarshal(postJSON, &post)
+	err = fillVotes(ctx, post)
+	if err != nil {
+		return nil, err
+	}
 
-	return &post, nil
+	return post, nil
 }
 
-// UpdatePost updates an existing post in the world state with provided parameters.
+// UpdatePost applies the JSON Merge Patch in the payload to an existing post. The
+// hash and creator identify the post, and only the fields in postFields are changed.
+// The payload may carry the expectedVersion the patch was made against.
 func (s *SmartContract) UpdatePost(ctx contractapi.TransactionContextInterface, payload string) error {
-	next := Post{}
-	err := json.Unmarshal([]byte(payload), &next)
+	next, expectation := Post{}, Expectation{}
+	err := decodePayload(payload, &struct {
+		*Post
+		*Expectation
+	}{&next, &expectation})
+
+	if err != nil {
+		return err
+	}
 
+	wallet, err := resolveCreator(ctx, next.Creator)
 	if err != nil {
 		return err
 	}
@@ -150,27 +363,41 @@ func (s *SmartContract) UpdatePost(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the post %s does not exist", next.Hash)
 	}
 
-	prev, _ := s.ReadPost(ctx, next.Hash)
+	err = checkActive(ctx, wallet)
+	if err != nil {
+		return err
+	}
 
-	x := reflect.ValueOf(&next).Elem()
-	y := reflect.ValueOf(prev).Elem()
+	prev, _ := readPost(ctx, next.Hash)
+	err = checkModifiable(ctx, prev, wallet)
+	if err != nil {
+		return err
+	}
 
-	// use reflection package to dynamically update non-zero value
-	for i := 0; i < x.NumField(); i++ {
-		name := x.Type().Field(i).Name
-		yf := y.FieldByName(name)
-		xf := x.FieldByName(name)
-		if name != "Hash" && yf.CanSet() && !xf.IsZero() {
-			yf.Set(xf)
-		}
+	err = expectation.check(prev.Hash, prev.Version)
+	if err != nil {
+		return err
 	}
 
-	// overwriting original post with new post
-	yJSON, _ := json.Marshal(prev)
-	err = ctx.GetStub().PutState(prev.Hash, yJSON)
+	err = mergePatch(prev, payload, postFields, postKeys)
+	if err != nil {
+		return err
+	}
 
+	err = prev.validate()
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
+	}
+
+	err = touchPost(ctx, prev)
+	if err != nil {
+		return err
+	}
+
+	// overwriting original post with new post
+	err = putPost(ctx, prev)
+	if err != nil {
+		return err
 	}
 
 	return ctx.GetStub().SetEvent("UpdatePost", []byte(payload))
@@ -178,7 +405,12 @@ func (s *SmartContract) UpdatePost(ctx contractapi.TransactionContextInterface,
 
 func (s *SmartContract) UpvotePost(ctx contractapi.TransactionContextInterface, payload string) error {
 	upvote := Upvote{}
-	err := json.Unmarshal([]byte(payload), &upvote)
+	err := decodePayload(payload, &upvote)
+	if err != nil {
+		return err
+	}
+
+	upvote.Creator, err = resolveCreator(ctx, upvote.Creator)
 	if err != nil {
 		return err
 	}
@@ -191,42 +423,28 @@ func (s *SmartContract) UpvotePost(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the post %s does not exist", upvote.Hash)
 	}
 
-	post, _ := s.ReadPost(ctx, upvote.Hash)
-	if post.Upvotes == nil {
-		post.Upvotes = make([]string, 0)
-	}
-	flag := false
-	for _, v := range post.Upvotes {
-		if v == upvote.Creator {
-			flag = true
-			break
-		}
-	}
-	if !flag {
-		post.Upvotes = append(post.Upvotes, upvote.Creator)
-		if post.Downvotes != nil {
-			for i, v := range post.Downvotes {
-				if v == upvote.Creator {
-					post.Downvotes = append(post.Downvotes[:i], post.Downvotes[i+1:]...)
-					break
-				}
-			}
-		}
+	user, err := readActiveProfile(ctx, upvote.Creator)
+	if err != nil {
+		return err
 	}
 
-	PostJSON, _ := json.Marshal(post)
-	err = ctx.GetStub().PutState(upvote.Hash, PostJSON)
-
+	err = toggleVote(ctx, upvote.Hash, user, voteUp)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("UpvotePost", []byte(payload))
+	upvoteJSON, _ := json.Marshal(upvote)
+	return ctx.GetStub().SetEvent("UpvotePost", upvoteJSON)
 }
 
 func (s *SmartContract) DownvotePost(ctx contractapi.TransactionContextInterface, payload string) error {
 	downvote := Downvote{}
-	err := json.Unmarshal([]byte(payload), &downvote)
+	err := decodePayload(payload, &downvote)
+	if err != nil {
+		return err
+	}
+
+	downvote.Creator, err = resolveCreator(ctx, downvote.Creator)
 	if err != nil {
 		return err
 	}
@@ -239,42 +457,28 @@ func (s *SmartContract) DownvotePost(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("the post %s does not exist", downvote.Hash)
 	}
 
-	post, _ := s.ReadPost(ctx, downvote.Hash)
-	if post.Downvotes == nil {
-		post.Downvotes = make([]string, 0)
-	}
-	flag := false
-	for _, v := range post.Downvotes {
-		if v == downvote.Creator {
-			flag = true
-			break
-		}
-	}
-	if !flag {
-		post.Downvotes = append(post.Downvotes, downvote.Creator)
-		if post.Upvotes != nil {
-			for i, v := range post.Upvotes {
-				if v == downvote.Creator {
-					post.Upvotes = append(post.Upvotes[:i], post.Upvotes[i+1:]...)
-					break
-				}
-			}
-		}
+	user, err := readActiveProfile(ctx, downvote.Creator)
+	if err != nil {
+		return err
 	}
 
-	PostJSON, _ := json.Marshal(post)
-
-	err = ctx.GetStub().PutState(downvote.Hash, PostJSON)
+	err = toggleVote(ctx, downvote.Hash, user, voteDown)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("DownvotePost", []byte(payload))
+	downvoteJSON, _ := json.Marshal(downvote)
+	return ctx.GetStub().SetEvent("DownvotePost", downvoteJSON)
 }
 
 func (s *SmartContract) AddEmojiPost(ctx contractapi.TransactionContextInterface, payload string) error {
 	emoji := Emoji{}
-	err := json.Unmarshal([]byte(payload), &emoji)
+	err := decodePayload(payload, &emoji)
+	if err != nil {
+		return err
+	}
+
+	emoji.Creator, err = resolveCreator(ctx, emoji.Creator)
 	if err != nil {
 		return err
 	}
@@ -287,24 +491,28 @@ func (s *SmartContract) AddEmojiPost(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("the post %s does not exist", emoji.Hash)
 	}
 
-	Post, _ := s.ReadPost(ctx, emoji.Hash)
-	if Post.Emojis == nil {
-		Post.Emojis = make(map[string][]string)
+	user, err := readActiveProfile(ctx, emoji.Creator)
+	if err != nil {
+		return err
 	}
-	Post.Emojis[emoji.Code] = append(Post.Emojis[emoji.Code], emoji.Creator)
-	PostJSON, _ := json.Marshal(Post)
 
-	err = ctx.GetStub().PutState(emoji.Hash, PostJSON)
+	err = reactEmoji(ctx, emoji.Hash, emoji.Code, user, true)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("AddEmojiPost", []byte(payload))
+	emojiJSON, _ := json.Marshal(emoji)
+	return ctx.GetStub().SetEvent("AddEmojiPost", emojiJSON)
 }
 
 func (s *SmartContract) RemoveEmojiPost(ctx contractapi.TransactionContextInterface, payload string) error {
 	emoji := Emoji{}
-	err := json.Unmarshal([]byte(payload), &emoji)
+	err := decodePayload(payload, &emoji)
+	if err != nil {
+		return err
+	}
+
+	emoji.Creator, err = resolveCreator(ctx, emoji.Creator)
 	if err != nil {
 		return err
 	}
@@ -317,32 +525,23 @@ func (s *SmartContract) RemoveEmojiPost(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("the post %s does not exist", emoji.Hash)
 	}
 
-	Post, _ := s.ReadPost(ctx, emoji.Hash)
-	if Post.Emojis[emoji.Code] != nil {
-		for i, v := range Post.Emojis[emoji.Code] {
-			if v == emoji.Creator {
-				Post.Emojis[emoji.Code] = append(Post.Emojis[emoji.Code][:i], Post.Emojis[emoji.Code][i+1:]...)
-				break
-			}
-		}
-		if len(Post.Emojis[emoji.Code]) == 0 {
-			delete(Post.Emojis, emoji.Code)
-		}
+	user, err := readProfile(ctx, emoji.Creator)
+	if err != nil {
+		return err
 	}
 
-	PostJSON, _ := json.Marshal(Post)
-
-	err = ctx.GetStub().PutState(emoji.Hash, PostJSON)
+	err = reactEmoji(ctx, emoji.Hash, emoji.Code, user, false)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("RemoveEmojiPost", []byte(payload))
+	emojiJSON, _ := json.Marshal(emoji)
+	return ctx.GetStub().SetEvent("RemoveEmojiPost", emojiJSON)
 }
 
-// GetAllPosts returns all posts found in world state
+// GetAllPosts returns all posts found in world state that are neither deleted nor hidden
 func (s *SmartContract) GetAllPosts(ctx contractapi.TransactionContextInterface) ([]*Post, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -359,23 +558,125 @@ func (s *SmartContract) GetAllPosts(ctx contractapi.TransactionContextInterface)
 		json.Unmarshal(queryResponse.Value, &post)
 		posts = append(posts, &post)
 	}
+	posts = listedPosts(posts)
+
+	err = fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
 
 	return posts, nil
 }
 
 func (s *SmartContract) QueryPostsByCreator(ctx contractapi.TransactionContextInterface, creator string) ([]*Post, error) {
-	queryString := fmt.Sprintf(`{"selector":{"creator":"%s"}}`, creator)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getPostsByIndex(ctx, creatorIndex, creator)
 }
 
 func (s *SmartContract) QueryPostsByBelongTo(ctx contractapi.TransactionContextInterface, belongTo string) ([]*Post, error) {
-	queryString := fmt.Sprintf(`{"selector":{"belongTo":"%s"}}`, belongTo)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getPostsByIndex(ctx, belongToIndex, belongTo)
 }
 
 func (s *SmartContract) QueryPostsByReplyTo(ctx contractapi.TransactionContextInterface, replyTo string) ([]*Post, error) {
-	queryString := fmt.Sprintf(`{"selector":{"replyTo":"%s"}}`, replyTo)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getPostsByIndex(ctx, replyToIndex, replyTo)
+}
+
+// postObjectType namespaces the composite keys posts are stored under.
+const postObjectType = "post~hash"
+
+// postKey returns the world state key of the post with given hash.
+func postKey(ctx contractapi.TransactionContextInterface, hash string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(postObjectType, []string{hash})
+}
+
+// putPost writes the post to the world state under its composite key and
+// brings its index entries in line with the post it replaces.
+func putPost(ctx contractapi.TransactionContextInterface, post *Post) error {
+	key, err := postKey(ctx, post.Hash)
+	if err != nil {
+		return err
+	}
+
+	prevJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	var prev *Post
+	if prevJSON != nil {
+		prev = &Post{}
+		json.Unmarshal(prevJSON, prev)
+	}
+
+	// votes live under vote keys and are only filled in for clients
+	stored := *post
+	stored.Upvotes, stored.Downvotes, stored.Emojis = nil, nil, nil
+
+	postJSON, _ := json.Marshal(stored)
+	err = ctx.GetStub().PutState(key, postJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	return indexPost(ctx, prev, post)
+}
+
+// readPost returns the stored post without reading its votes, so that transactions
+// changing the post do not conflict with concurrent votes.
+func readPost(ctx contractapi.TransactionContextInterface, postId string) (*Post, error) {
+	key, err := postKey(ctx, postId)
+	if err != nil {
+		return nil, err
+	}
+
+	postJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if postJSON == nil {
+		return nil, fmt.Errorf("the post %s does not exist", postId)
+	}
+
+	var post Post
+	json.Unmarshal(postJSON, &post)
+
+	return &post, nil
+}
+
+// checkModifiable returns an error unless the wallet may change the post, which
+// requires being its creator while it is unlocked, or being a moderator.
+func checkModifiable(ctx contractapi.TransactionContextInterface, post *Post, wallet string) error {
+	creator, err := isCreator(ctx, post.Creator, wallet)
+	if err != nil {
+		return err
+	}
+	if creator && !post.Locked {
+		return nil
+	}
+
+	moderator, err := canModerate(ctx, wallet)
+	if err != nil {
+		return err
+	}
+	if moderator {
+		return nil
+	}
+
+	if !creator {
+		return fmt.Errorf("the post %s is not created by %s", post.Hash, wallet)
+	}
+	return fmt.Errorf("the post %s is locked", post.Hash)
+}
+
+// checkModerator returns an error unless the wallet holds the moderate permission.
+func checkModerator(ctx contractapi.TransactionContextInterface, wallet string) error {
+	moderator, err := canModerate(ctx, wallet)
+	if err != nil {
+		return err
+	}
+	if !moderator {
+		return fmt.Errorf("the client %s is not permitted to moderate posts", wallet)
+	}
+	return nil
 }
 
 // getQueryResultForQueryString executes the passed in query string.
@@ -387,7 +688,17 @@ func getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, q
 	}
 	defer resultsIterator.Close()
 
-	return constructQueryResponseFromIterator(resultsIterator)
+	posts, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return posts, nil
 }
 
 // constructQueryResponseFromIterator constructs a slice of posts from the resultsIterator
@@ -1,10 +1,15 @@
 package chaincode_test
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 
 	"post/chaincode"
@@ -16,7 +21,9 @@ import (
 
 	// _ "github.com/maxbrunsfeld/counterfeiter/v6"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -34,6 +41,11 @@ type stateQueryIterator interface {
 	shim.StateQueryIteratorInterface
 }
 
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/historyqueryiterator.go -fake-name HistoryQueryIterator . historyQueryIterator
+type historyQueryIterator interface {
+	shim.HistoryQueryIteratorInterface
+}
+
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/clientIdentity.go -fake-name ClientIdentity . clientIdentity
 type clientIdentity interface {
 	cid.ClientIdentity
@@ -46,25 +58,44 @@ const myOrg2Msp = "Org2Testmsp"
 const myOrg2Clientid = "myOrg2Userid"
 const myOrg2PrivCollection = "Org2TestmspPrivateCollection"
 
+// sampleCID, sampleCID2 and sampleCID3 are the CIDv0 of the contents 1, 2 and 3.
+const (
+	sampleCID  = "QmVaPTddRyjLjMoZnYufWc5M5CjyGNPmFEpp5HtPKEqZFG"
+	sampleCID2 = "QmcdyB29uHtqMRZy47MrhaqFqHpHuPr7eUxWWPJbGpSRxg"
+	sampleCID3 = "QmTbEvXabndtyFm6zYZBvYg82jy9Po9bX8dXbyPAEWYh1f"
+)
+
 var samplePost = &chaincode.Post{
 	Hash:     "1",
-	Creator:  "1",
-	CID:      "1",
+	Creator:  myOrg1Clientid,
+	CID:      sampleCID,
 	ReplyTo:  "1",
 	BelongTo: "1",
 }
 
 var sampleInput, _ = json.Marshal(samplePost)
 
+// samplePatch changes the fields of samplePost clients may update to their own values.
+var samplePatch = `{"hash":"1","cid":"` + sampleCID + `"}`
+
+// createInput leaves the hash to the chaincode, which the mocks give the transaction ID 1,
+// and replies to no post.
+var createInput = func() []byte {
+	post := *samplePost
+	post.Hash, post.ReplyTo = "", ""
+	input, _ := json.Marshal(post)
+	return input
+}()
+
 func TestCreatePost(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	post := chaincode.SmartContract{}
 
-	err := post.CreatePost(transactionContext, string(sampleInput))
+	_, err := post.CreatePost(transactionContext, string(createInput))
 	require.NoError(t, err)
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = post.CreatePost(transactionContext, string(sampleInput))
+	_, err = post.CreatePost(transactionContext, string(createInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
 	expectedPost := &chaincode.Post{Hash: "1"}
@@ -72,21 +103,161 @@ func TestCreatePost(t *testing.T) {
 	require.NoError(t, err)
 
 	chaincodeStub.GetStateReturns(bytes, nil)
-	err = post.CreatePost(transactionContext, string(sampleInput))
+	_, err = post.CreatePost(transactionContext, string(createInput))
 	require.EqualError(t, err, "the post 1 already exists")
 
-	err = post.CreatePost(transactionContext, "sad")
+	_, err = post.CreatePost(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
 	chaincodeStub.GetStateReturns(nil, nil)
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = post.CreatePost(transactionContext, string(sampleInput))
+	_, err = post.CreatePost(transactionContext, string(createInput))
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 
 }
 
+func TestCreatePostIdentity(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	post := chaincode.SmartContract{}
+
+	_, err := post.CreatePost(transactionContext, string(createInput))
+	require.EqualError(t, err, "the creator myOrg1Userid does not match the submitting client myOrg2Userid")
+
+	anonymousPost := &chaincode.Post{BelongTo: "1"}
+	bytes, _ := json.Marshal(anonymousPost)
+	_, err = post.CreatePost(transactionContext, string(bytes))
+	require.NoError(t, err)
+
+	key, postJSON := chaincodeStub.PutStateArgsForCall(2)
+	require.Equal(t, "\x00post~hash\x001\x00", key)
+	stored := &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.Equal(t, myOrg2Clientid, stored.Creator)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetAttributeValueReturns(myOrg1Clientid, true, nil)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid))
+	_, err = post.CreatePost(transactionContext, string(createInput))
+	require.NoError(t, err)
+
+	transactionContext, _ = prepMocksIllegalId()
+	_, err = post.CreatePost(transactionContext, string(createInput))
+	require.EqualError(t, err, "failed to read client identity: failure")
+}
+
+func TestCreatePostModerationFields(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.CreatePost(transactionContext, `{"cid":"`+sampleCID+`","belongTo":"1","deleted":true,"deletedAt":1,"deletedBy":"moderator1","hidden":true,"hiddenReason":"spam","hiddenBy":"moderator1","locked":true}`)
+	require.NoError(t, err)
+
+	key, postJSON := chaincodeStub.PutStateArgsForCall(2)
+	require.Equal(t, "\x00post~hash\x001\x00", key)
+	stored := &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.False(t, stored.Deleted)
+	require.Zero(t, stored.DeletedAt)
+	require.Empty(t, stored.DeletedBy)
+	require.False(t, stored.Hidden)
+	require.Empty(t, stored.HiddenReason)
+	require.Empty(t, stored.HiddenBy)
+	require.False(t, stored.Locked)
+}
+
+func TestPostIDs(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("tx1")
+	hash, err := post.CreatePost(transactionContext, `{"cid":"`+sampleCID+`"}`)
+	require.NoError(t, err)
+	require.Equal(t, "tx1", hash)
+
+	key, _ := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00post~hash\x00tx1\x00", key)
+	_, eventJSON := chaincodeStub.SetEventArgsForCall(0)
+	created := &chaincode.Post{}
+	json.Unmarshal(eventJSON, created)
+	require.Equal(t, "tx1", created.Hash)
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	digest := sha256.Sum256([]byte(myOrg1Clientid + "\x00" + sampleCID + "\x001000000"))
+	expected := hex.EncodeToString(digest[:])
+	hash, err = post.CreatePost(transactionContext, `{"hash":"`+expected+`","cid":"`+sampleCID+`"}`)
+	require.NoError(t, err)
+	require.Equal(t, expected, hash)
+
+	_, err = post.CreatePost(transactionContext, `{"hash":"`+expected+`","cid":"`+sampleCID2+`"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the hash "+expected+" is not the digest of the creator, cid and timestamp")
+
+	_, err = post.CreatePost(transactionContext, `{"hash":"1"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+}
+
+func TestValidatePost(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.CreatePost(transactionContext, `{"replyTo":"1","title":"1"}`)
+	require.EqualError(t, err, `json: unknown field "title"`)
+
+	_, err = post.CreatePost(transactionContext, `{"replyTo":"1"} {}`)
+	require.EqualError(t, err, "invalid data after the payload")
+
+	for payload, message := range map[string]string{
+		`{"cid":"ipfs://1"}`: "the cid is not a valid CID: invalid cid: selected encoding not supported",
+		`{"replyTo":"1 2"}`:  "the replyTo may only contain letters, digits, '-' and '_'",
+		`{"assets":[""]}`:    "the assets is required",
+		`{"assets":["` + strings.Repeat(`1","`, 20) + `1"]}`: "the assets hold more than 20 values",
+	} {
+		_, err = post.CreatePost(transactionContext, payload)
+		require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+		require.EqualError(t, err, "ERR_INVALID_PAYLOAD: "+message)
+	}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"a.b"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the cid is not a valid CID: invalid cid: selected encoding not supported")
+
+	err = post.UpdatePost(transactionContext, `{"hash":"1","text":"2"}`)
+	require.EqualError(t, err, `json: unknown field "text"`)
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+}
+
+func TestPostCIDs(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.CreatePost(transactionContext, `{"cid":"bafkreidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm","assets":["`+sampleCID2+`"]}`)
+	require.NoError(t, err)
+
+	_, err = post.CreatePost(transactionContext, `{"assets":["baguqeeranodle477gt6odhllqbhp6wr7k5d23jhkuixr2soadzjn3n4hlnfq"]}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the assets is not a valid CID: codec 0x129 is not allowed")
+
+	stored, _ := json.Marshal(&chaincode.Post{Hash: "1", CID: sampleCID, Assets: []string{sampleCID2}})
+	chaincodeStub.GetStateReturns(stored, nil)
+	verified, err := post.VerifyContent(transactionContext, "1", "bafybeidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm")
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	verified, err = post.VerifyContent(transactionContext, "1", sampleCID2)
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	verified, err = post.VerifyContent(transactionContext, "1", sampleCID3)
+	require.NoError(t, err)
+	require.False(t, verified)
+
+	_, err = post.VerifyContent(transactionContext, "1", "QmVaPTddRyjLjMoZnYufWc5M5CjyGNPmFEpp5HtPKEqZF0")
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the cid is not a valid CID: invalid cid: input isn't valid multihash")
+}
+
 func TestDeletePost(t *testing.T) {
-	deleteRequest := &chaincode.Delete{Hash: "1", Creator: "1"}
+	deleteRequest := &chaincode.Delete{Hash: "1", Creator: myOrg1Clientid}
 	deleteInput, _ := json.Marshal(deleteRequest)
 
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
@@ -96,7 +267,7 @@ func TestDeletePost(t *testing.T) {
 	err := post.DeletePost(transactionContext, string(deleteInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	expectedPost := &chaincode.Post{Hash: "1", Creator: "1"}
+	expectedPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, err := json.Marshal(expectedPost)
 	require.NoError(t, err)
 	chaincodeStub.GetStateReturns(bytes, nil)
@@ -112,9 +283,9 @@ func TestDeletePost(t *testing.T) {
 	require.NoError(t, err)
 	chaincodeStub.GetStateReturns(bytes, nil)
 	err = post.DeletePost(transactionContext, string(deleteInput))
-	require.EqualError(t, err, "the post 1 is not created by 1")
+	require.EqualError(t, err, "the post 1 is not created by myOrg1Userid")
 
-	expectedPost = &chaincode.Post{Hash: "1", Creator: "1"}
+	expectedPost = &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, err = json.Marshal(expectedPost)
 	require.NoError(t, err)
 	chaincodeStub.GetStateReturns(bytes, nil)
@@ -122,10 +293,261 @@ func TestDeletePost(t *testing.T) {
 	err = post.DeletePost(transactionContext, string(deleteInput))
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 
+	spoofedInput, _ := json.Marshal(&chaincode.Delete{Hash: "1", Creator: myOrg2Clientid})
+	err = post.DeletePost(transactionContext, string(spoofedInput))
+	require.EqualError(t, err, "the creator myOrg2Userid does not match the submitting client myOrg1Userid")
+
 	err = post.DeletePost(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 }
 
+func TestModeratePost(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	post := chaincode.SmartContract{}
+
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
+	bytes, _ := json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	hideInput, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true, Reason: "spam"})
+	err := post.HidePost(transactionContext, string(hideInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to moderate posts")
+
+	lockInput, _ := json.Marshal(&chaincode.Lock{Hash: "1", Locked: true})
+	err = post.LockPost(transactionContext, string(lockInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to moderate posts")
+
+	chaincodeStub.InvokeChaincodeReturns(peer.Response{Status: shim.ERROR, Message: "the user myOrg2Userid does not exist"})
+	err = post.HidePost(transactionContext, string(hideInput))
+	require.EqualError(t, err, "failed to query userprofile: the user myOrg2Userid does not exist")
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "moderator"), "moderate")
+	noReason, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true})
+	err = post.HidePost(transactionContext, string(noReason))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the reason is required")
+
+	longReason, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true, Reason: strings.Repeat("a", 501)})
+	err = post.HidePost(transactionContext, string(longReason))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the reason is longer than 500 characters")
+
+	err = post.HidePost(transactionContext, string(hideInput))
+	require.NoError(t, err)
+
+	_, postJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.True(t, stored.Hidden)
+	require.Equal(t, "spam", stored.HiddenReason)
+	require.Equal(t, myOrg2Clientid, stored.HiddenBy)
+
+	err = post.LockPost(transactionContext, string(lockInput))
+	require.NoError(t, err)
+
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1"})
+	err = post.DeletePost(transactionContext, string(deleteInput))
+	require.NoError(t, err)
+
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"`+sampleCID2+`"}`)
+	require.NoError(t, err)
+
+	_, postJSON = chaincodeStub.PutStateArgsForCall(3)
+	stored = &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.Equal(t, myOrg1Clientid, stored.Creator)
+	require.Equal(t, sampleCID2, stored.CID)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+		if name == "role" {
+			return "admin", true, nil
+		}
+		return "", false, nil
+	}
+	clientIdentity.GetIDReturns(base64.StdEncoding.EncodeToString([]byte(myOrg2Clientid)), nil)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	// userprofile grants the permissions of the role attribute of the submitting client
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid), "moderate")
+	err = post.HidePost(transactionContext, string(hideInput))
+	require.NoError(t, err)
+
+	err = post.HidePost(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	err = post.LockPost(transactionContext, string(lockInput))
+	require.EqualError(t, err, "the post 1 does not exist")
+}
+
+func TestRestorePost(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	restoreInput, _ := json.Marshal(&chaincode.Restore{Hash: "1"})
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	err := post.RestorePost(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the post 1 is not deleted")
+
+	deleted := *samplePost
+	deleted.Deleted, deleted.DeletedAt = true, 1000000
+	bytes, _ := json.Marshal(&deleted)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000 + 31*24*60*60}, nil)
+	err = post.RestorePost(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the post 1 was deleted too long ago to be restored")
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	err = post.RestorePost(transactionContext, string(restoreInput))
+	require.NoError(t, err)
+
+	_, postJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.False(t, stored.Deleted)
+	require.Zero(t, stored.DeletedAt)
+
+	// the restored post is indexed again
+	key, _ := chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00creator~hash\x00myOrg1Userid\x001\x00", key)
+
+	// deletions recorded before DeletedAt was kept can be restored at any time
+	legacy := *samplePost
+	legacy.Deleted = true
+	bytes, _ = json.Marshal(&legacy)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000 + 31*24*60*60}, nil)
+	err = post.RestorePost(transactionContext, string(restoreInput))
+	require.NoError(t, err)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+
+	// a deletion by a moderator is only undone by moderators
+	deleted.DeletedBy = myOrg2Clientid
+	bytes, _ = json.Marshal(&deleted)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = post.RestorePost(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to moderate posts")
+
+	deleted.Creator = myOrg2Clientid
+	bytes, _ = json.Marshal(&deleted)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = post.RestorePost(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the post 1 is not created by myOrg1Userid")
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg1Clientid, "moderator"), "moderate")
+	err = post.RestorePost(transactionContext, string(restoreInput))
+	require.NoError(t, err)
+
+	err = post.RestorePost(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+}
+
+func TestPurgePost(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	err := post.PurgePost(transactionContext, "1")
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to purge posts")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+	err = post.PurgePost(transactionContext, "1")
+	require.EqualError(t, err, "the post 1 does not exist")
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.NextReturns(&queryresult.KV{Key: "\x00vote~hash~wallet\x001\x00myOrg2Userid\x00"}, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturnsOnCall(1, iterator, nil)
+	err = post.PurgePost(transactionContext, "1")
+	require.NoError(t, err)
+
+	var deleted []string
+	for i := 0; i < chaincodeStub.DelStateCallCount(); i++ {
+		deleted = append(deleted, chaincodeStub.DelStateArgsForCall(i))
+	}
+	require.Equal(t, []string{
+		"\x00creator~hash\x00myOrg1Userid\x001\x00",
+		"\x00belongTo~hash\x001\x001\x00",
+		"\x00replyTo~hash\x001\x001\x00",
+		"\x00thread~belongTo~replyTo~createdAt~hash\x001\x001\x0000000000000000000000\x001\x00",
+		"\x00vote~hash~wallet\x001\x00myOrg2Userid\x00",
+		"\x00post~hash\x001\x00",
+	}, deleted)
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	// a purged post keeps its place in the thread while replies to it remain
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	replies := &mocks.StateQueryIterator{}
+	replies.HasNextReturns(true)
+	chaincodeStub.GetStateByPartialCompositeKeyReturnsOnCall(0, replies, nil)
+	err = post.PurgePost(transactionContext, "1")
+	require.NoError(t, err)
+	_, attributes := chaincodeStub.GetStateByPartialCompositeKeyArgsForCall(0)
+	require.Equal(t, []string{"1", "1"}, attributes)
+	for i := 0; i < chaincodeStub.DelStateCallCount(); i++ {
+		require.NotContains(t, chaincodeStub.DelStateArgsForCall(i), "thread~")
+	}
+
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
+	err = post.PurgePost(transactionContext, "1")
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+}
+
+func TestLockedPost(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid, Locked: true}
+	bytes, _ := json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	err := post.UpdatePost(transactionContext, samplePatch)
+	require.EqualError(t, err, "the post 1 is locked")
+
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1"})
+	err = post.DeletePost(transactionContext, string(deleteInput))
+	require.EqualError(t, err, "the post 1 is locked")
+}
+
+func TestInactivePost(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	mutedUser, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "muted": true})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(mutedUser))
+	_, err := post.CreatePost(transactionContext, string(createInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+	require.EqualError(t, err, "ERR_USER_MUTED: the user myOrg1Userid is muted")
+
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
+	bytes, _ := json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	err = post.UpvotePost(transactionContext, string(upvoteInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+
+	err = post.DownvotePost(transactionContext, string(upvoteInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+
+	err = post.AddEmojiPost(transactionContext, string(emojiInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+
+	bannedUser, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "muted": true, "banned": true})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(bannedUser))
+	err = post.UpdatePost(transactionContext, samplePatch)
+	require.ErrorIs(t, err, chaincode.ErrUserBanned)
+	require.EqualError(t, err, "ERR_USER_BANNED: the user myOrg1Userid is banned")
+
+	chaincodeStub.InvokeChaincodeReturns(shim.Success([]byte("sad")))
+	err = post.UpvotePost(transactionContext, string(upvoteInput))
+	require.EqualError(t, err, "failed to decode user profile: invalid character 's' looking for beginning of value")
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	chaincodeStub.InvokeChaincodeReturns(peer.Response{Status: shim.ERROR, Message: "the user myOrg1Userid does not exist"})
+	_, err = post.CreatePost(transactionContext, string(createInput))
+	require.EqualError(t, err, "failed to query userprofile: the user myOrg1Userid does not exist")
+}
+
 func TestReadPost(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	post := chaincode.SmartContract{}
@@ -149,30 +571,356 @@ func TestUpdatePost(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	post := chaincode.SmartContract{}
 
-	err := post.UpdatePost(transactionContext, string(sampleInput))
+	err := post.UpdatePost(transactionContext, samplePatch)
 	require.EqualError(t, err, "the post 1 does not exist")
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = post.UpdatePost(transactionContext, string(sampleInput))
+	err = post.UpdatePost(transactionContext, samplePatch)
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpPost := &chaincode.Post{Hash: "1", Creator: "1"}
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
-	err = post.UpdatePost(transactionContext, string(sampleInput))
+	err = post.UpdatePost(transactionContext, samplePatch)
 	require.NoError(t, err)
 
 	err = post.UpdatePost(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
+	tmpPost = &chaincode.Post{Hash: "1", Creator: myOrg2Clientid}
+	bytes, _ = json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = post.UpdatePost(transactionContext, samplePatch)
+	require.EqualError(t, err, "the post 1 is not created by myOrg1Userid")
+
+	tmpPost = &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
+	bytes, _ = json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = post.UpdatePost(transactionContext, string(sampleInput))
+	err = post.UpdatePost(transactionContext, samplePatch)
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 
 }
 
-var upvoteInput, _ = json.Marshal(&chaincode.Upvote{Hash: "1", Creator: "1"})
+func TestRotatedWallet(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	// userprofile answers for a rotated wallet with the profile under its new wallet
+	chaincodeStub.InvokeChaincodeReturns(profileResponse("wallet2"))
+	_, err := post.CreatePost(transactionContext, string(createInput))
+	require.ErrorIs(t, err, chaincode.ErrWalletRotated)
+	require.EqualError(t, err, "ERR_WALLET_ROTATED: the wallet myOrg1Userid was rotated to wallet2")
+
+	// the new wallet keeps the posts of the wallets it was rotated from
+	rotated, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "previousWallets": []string{"wallet0"}})
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(shim.Success(rotated))
+	tmpPost := &chaincode.Post{Hash: "1", Creator: "wallet0"}
+	bytes, _ := json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = post.UpdatePost(transactionContext, samplePatch)
+	require.NoError(t, err)
+
+	tmpPost = &chaincode.Post{Hash: "1", Creator: "wallet3"}
+	bytes, _ = json.Marshal(tmpPost)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = post.UpdatePost(transactionContext, samplePatch)
+	require.EqualError(t, err, "the post 1 is not created by myOrg1Userid")
+}
+
+func TestUpdatePostMergePatch(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	stored, _ := json.Marshal(&chaincode.Post{Hash: "1", Creator: myOrg1Clientid, CID: sampleCID, BelongTo: "1", Assets: []string{sampleCID}})
+	chaincodeStub.GetStateReturns(stored, nil)
+	patch := `{"hash":"1","cid":"` + sampleCID2 + `","assets":null,"belongTo":"2","deleted":true,"upvotes":["2"]}`
+	err := post.UpdatePost(transactionContext, patch)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field belongTo may not be changed")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"`+sampleCID2+`","assets":null}`)
+	require.NoError(t, err)
+
+	_, postJSON := chaincodeStub.PutStateArgsForCall(0)
+	updated := &chaincode.Post{}
+	json.Unmarshal(postJSON, updated)
+	require.Equal(t, sampleCID2, updated.CID)
+	require.Nil(t, updated.Assets)
+	require.Equal(t, "1", updated.BelongTo)
+	require.False(t, updated.Deleted)
+	require.Nil(t, updated.Upvotes)
+	require.Equal(t, myOrg1Clientid, updated.Creator)
+
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":2}`)
+	require.EqualError(t, err, "json: cannot unmarshal number into Go struct field .cid of type string")
+}
+
+func TestPostVersions(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	input, _ := json.Marshal(&chaincode.Post{Version: 7})
+	_, err := post.CreatePost(transactionContext, string(input))
+	require.NoError(t, err)
+
+	_, postJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.Equal(t, uint64(1), stored.Version)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(postJSON, nil)
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"`+sampleCID2+`","expectedVersion":1,"version":5}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field version may not be changed")
+
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"`+sampleCID2+`","expectedVersion":1}`)
+	require.NoError(t, err)
+
+	_, postJSON = chaincodeStub.PutStateArgsForCall(0)
+	stored = &chaincode.Post{}
+	json.Unmarshal(postJSON, stored)
+	require.Equal(t, uint64(2), stored.Version)
+
+	chaincodeStub.GetStateReturns(postJSON, nil)
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"`+sampleCID3+`","expectedVersion":1}`)
+	require.ErrorIs(t, err, chaincode.ErrVersionConflict)
+	require.EqualError(t, err, "ERR_VERSION_CONFLICT: the post 1 is at version 2, not 1")
+
+	err = post.UpdatePost(transactionContext, `{"hash":"1","cid":"`+sampleCID3+`"}`)
+	require.NoError(t, err)
+
+	expected := uint64(1)
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1", Expectation: chaincode.Expectation{ExpectedVersion: &expected}})
+	err = post.DeletePost(transactionContext, string(deleteInput))
+	require.ErrorIs(t, err, chaincode.ErrVersionConflict)
+
+	expected = 2
+	deleteInput, _ = json.Marshal(&chaincode.Delete{Hash: "1", Expectation: chaincode.Expectation{ExpectedVersion: &expected}})
+	err = post.DeletePost(transactionContext, string(deleteInput))
+	require.NoError(t, err)
+}
+
+func TestPostTimestamps(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	input, _ := json.Marshal(&chaincode.Post{BelongTo: "2", CreatedAt: 1, UpdatedAt: 1})
+	_, err := post.CreatePost(transactionContext, string(input))
+	require.NoError(t, err)
+
+	_, postJSON := chaincodeStub.PutStateArgsForCall(2)
+	var created chaincode.Post
+	json.Unmarshal(postJSON, &created)
+	require.Equal(t, int64(1000000), created.CreatedAt)
+	require.Equal(t, int64(1000000), created.UpdatedAt)
+
+	name, args, _ := chaincodeStub.InvokeChaincodeArgsForCall(chaincodeStub.InvokeChaincodeCallCount() - 1)
+	require.Equal(t, "topic", name)
+	require.Equal(t, [][]byte{[]byte("RenewTopicUpdateTime"), []byte("2")}, args)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(postJSON, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	input, _ = json.Marshal(&chaincode.Post{Hash: "1", BelongTo: "2", CreatedAt: 1, UpdatedAt: 1})
+	err = post.UpdatePost(transactionContext, string(input))
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+
+	err = post.UpdatePost(transactionContext, samplePatch)
+	require.NoError(t, err)
+
+	_, postJSON = chaincodeStub.PutStateArgsForCall(0)
+	var updated chaincode.Post
+	json.Unmarshal(postJSON, &updated)
+	require.Equal(t, int64(1000000), updated.CreatedAt)
+	require.Equal(t, int64(2000000), updated.UpdatedAt)
+
+	chaincodeStub.GetTxTimestampReturns(nil, fmt.Errorf("failure"))
+	err = post.UpdatePost(transactionContext, samplePatch)
+	require.EqualError(t, err, "failed to read transaction timestamp: failure")
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.InvokeChaincodeStub = func(name string, args [][]byte, channel string) peer.Response {
+		if name == "topic" && string(args[0]) == "RenewTopicUpdateTime" {
+			return shim.Error("the topic 2 is locked")
+		}
+		return profileResponse(myOrg1Clientid)
+	}
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"2"}`)
+	require.EqualError(t, err, "failed to renew topic 2: the topic 2 is locked")
+}
+
+func TestPostReferences(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	chaincodeStub.InvokeChaincodeStub = func(name string, args [][]byte, channel string) peer.Response {
+		if name == "topic" && string(args[0]) == "ReadTopicState" {
+			hash := string(args[1])
+			topic, _ := json.Marshal(map[string]interface{}{"hash": hash, "deleted": hash == "2", "locked": hash == "4", "hidden": hash == "5"})
+			return shim.Success(topic)
+		}
+		return profileResponse(myOrg1Clientid)
+	}
+
+	_, err := post.CreatePost(transactionContext, `{"belongTo":"2"}`)
+	require.EqualError(t, err, "the topic 2 is deleted")
+
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"4"}`)
+	require.EqualError(t, err, "the topic 4 is locked")
+
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"5"}`)
+	require.EqualError(t, err, "the topic 5 is hidden")
+
+	posts := map[string]*chaincode.Post{
+		"a": {Hash: "a", BelongTo: "1"},
+		"b": {Hash: "b", BelongTo: "1", ReplyTo: "a"},
+		"c": {Hash: "c", BelongTo: "1", ReplyTo: "b"},
+		"d": {Hash: "d", BelongTo: "1", Deleted: true},
+		"e": {Hash: "e", BelongTo: "3"},
+		"x": {Hash: "x", BelongTo: "1", ReplyTo: "y"},
+		"y": {Hash: "y", BelongTo: "1", ReplyTo: "x"},
+	}
+	states := map[string][]byte{"\x00config\x00settings\x00": []byte(`{"maxReplyDepth":2}`)}
+	for hash, stored := range posts {
+		states["\x00post~hash\x00"+hash+"\x00"], _ = json.Marshal(stored)
+	}
+	chaincodeStub.GetStateStub = worldState(states)
+
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"1","replyTo":"b"}`)
+	require.NoError(t, err)
+
+	for payload, message := range map[string]string{
+		`{"belongTo":"1","replyTo":"f"}`: "the post f does not exist",
+		`{"belongTo":"1","replyTo":"d"}`: "the post d is deleted",
+		`{"belongTo":"1","replyTo":"e"}`: "the post e belongs to another topic",
+		`{"replyTo":"a"}`:                "the post a belongs to another topic",
+		`{"belongTo":"1","replyTo":"c"}`: "replies may not be nested deeper than 2 levels",
+	} {
+		_, err = post.CreatePost(transactionContext, payload)
+		require.EqualError(t, err, message)
+	}
+
+	states["\x00config\x00settings\x00"] = nil
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"1","replyTo":"c"}`)
+	require.NoError(t, err)
+
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"1","replyTo":"x"}`)
+	require.EqualError(t, err, "the post x is part of a reply cycle")
+
+	chaincodeStub.InvokeChaincodeStub = func(name string, args [][]byte, channel string) peer.Response {
+		if name == "topic" {
+			return shim.Error("the topic 1 does not exist")
+		}
+		return profileResponse(myOrg1Clientid)
+	}
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"1"}`)
+	require.EqualError(t, err, "failed to query topic: the topic 1 does not exist")
+}
+
+func TestConfig(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	config, err := post.GetConfig(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 8, config.MaxReplyDepth)
+
+	err = post.SetConfig(transactionContext, `{"maxReplyDepth":3}`)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to configure posts")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+	err = post.SetConfig(transactionContext, `{"maxReplyDepth":-1}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+
+	err = post.SetConfig(transactionContext, `{"maxReplyDepth":3}`)
+	require.NoError(t, err)
+
+	key, configJSON := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00config\x00settings\x00", key)
+	chaincodeStub.GetStateReturns(configJSON, nil)
+	config, err = post.GetConfig(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 3, config.MaxReplyDepth)
+}
+
+func TestPostIndexes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	parent, _ := json.Marshal(&chaincode.Post{Hash: "0", BelongTo: "1"})
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{"\x00post~hash\x000\x00": parent})
+	_, err := post.CreatePost(transactionContext, `{"belongTo":"1","replyTo":"0"}`)
+	require.NoError(t, err)
+
+	var written []string
+	for i := 0; i < chaincodeStub.PutStateCallCount(); i++ {
+		key, _ := chaincodeStub.PutStateArgsForCall(i)
+		written = append(written, key)
+	}
+	require.Equal(t, []string{
+		"\x00floorCounter~belongTo\x001\x00",
+		"\x00belongTo~floor\x001\x0000000000000000000001\x00",
+		"\x00post~hash\x001\x00",
+		"\x00creator~hash\x00myOrg1Userid\x001\x00",
+		"\x00belongTo~hash\x001\x001\x00",
+		"\x00replyTo~hash\x000\x001\x00",
+		"\x00thread~belongTo~replyTo~createdAt~hash\x001\x000\x0000000000000000000000\x001\x00",
+	}, written)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1"})
+	err = post.DeletePost(transactionContext, string(deleteInput))
+	require.NoError(t, err)
+
+	// deleted posts leave every index but the thread, where they are collapsed
+	require.Equal(t, 1, chaincodeStub.PutStateCallCount())
+	var deleted []string
+	for i := 0; i < chaincodeStub.DelStateCallCount(); i++ {
+		deleted = append(deleted, chaincodeStub.DelStateArgsForCall(i))
+	}
+	require.Equal(t, []string{
+		"\x00creator~hash\x00myOrg1Userid\x001\x00",
+		"\x00belongTo~hash\x001\x001\x00",
+		"\x00replyTo~hash\x001\x001\x00",
+	}, deleted)
+
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
+	err = post.DeletePost(transactionContext, string(deleteInput))
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.RebuildIndexes(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to rebuild indexes")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, false)
+	iterator.NextReturns(&queryresult.KV{Value: sampleInput}, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
+
+	indexed, err := post.RebuildIndexes(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, indexed)
+	require.Equal(t, 4, chaincodeStub.PutStateCallCount())
+
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = post.RebuildIndexes(transactionContext)
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+var upvoteInput, _ = json.Marshal(&chaincode.Upvote{Hash: "1", Creator: myOrg1Clientid})
 
 func TestUpvotePost(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
@@ -185,28 +933,37 @@ func TestUpvotePost(t *testing.T) {
 	err = post.UpvotePost(transactionContext, string(upvoteInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpPost := &chaincode.Post{Hash: "1", Creator: "1"}
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	err = post.UpvotePost(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
-	tmpPost = &chaincode.Post{Hash: "1", Creator: "1", Upvotes: []string{"1"}}
-	bytes, _ = json.Marshal(tmpPost)
-	chaincodeStub.GetStateReturns(bytes, nil)
+	require.Equal(t, 1, chaincodeStub.PutStateCallCount())
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", key)
+	require.Equal(t, []byte("up"), value)
+
+	chaincodeStub.GetStateReturns([]byte("up"), nil)
 	err = post.UpvotePost(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", chaincodeStub.DelStateArgsForCall(0))
 
-	tmpPost = &chaincode.Post{Hash: "1", Creator: "1", Downvotes: []string{"1"}}
-	bytes, _ = json.Marshal(tmpPost)
-	chaincodeStub.GetStateReturns(bytes, nil)
+	chaincodeStub.GetStateReturns([]byte("down"), nil)
 	err = post.UpvotePost(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
+	_, value = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, []byte("up"), value)
 
 	err = post.UpvotePost(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
+	spoofedInput, _ := json.Marshal(&chaincode.Upvote{Hash: "1", Creator: myOrg2Clientid})
+	err = post.UpvotePost(transactionContext, string(spoofedInput))
+	require.EqualError(t, err, "the creator myOrg2Userid does not match the submitting client myOrg1Userid")
+
 	err = post.UpvotePost(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
@@ -230,20 +987,20 @@ func TestDownvotePost(t *testing.T) {
 	err = post.DownvotePost(transactionContext, string(upvoteInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpPost := &chaincode.Post{Hash: "1", Creator: "1"}
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	err = post.DownvotePost(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
-	tmpPost = &chaincode.Post{Hash: "1", Creator: "1", Downvotes: []string{"1"}}
+	tmpPost = &chaincode.Post{Hash: "1", Creator: myOrg1Clientid, Downvotes: []string{"1"}}
 	bytes, _ = json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 	err = post.DownvotePost(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
-	tmpPost = &chaincode.Post{Hash: "1", Creator: "1", Upvotes: []string{"1"}}
+	tmpPost = &chaincode.Post{Hash: "1", Creator: myOrg1Clientid, Upvotes: []string{"1"}}
 	bytes, _ = json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 	err = post.DownvotePost(transactionContext, string(upvoteInput))
@@ -261,7 +1018,7 @@ func TestDownvotePost(t *testing.T) {
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
-var emojiInput, _ = json.Marshal(&chaincode.Emoji{Hash: "1", Creator: "1", Code: "1"})
+var emojiInput, _ = json.Marshal(&chaincode.Emoji{Hash: "1", Creator: myOrg1Clientid, Code: "1"})
 
 func TestAddEmojiPost(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
@@ -274,7 +1031,7 @@ func TestAddEmojiPost(t *testing.T) {
 	err = post.AddEmojiPost(transactionContext, string(emojiInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpPost := &chaincode.Post{Hash: "1", Creator: "1"}
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
@@ -284,6 +1041,16 @@ func TestAddEmojiPost(t *testing.T) {
 	err = post.AddEmojiPost(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
+	err = post.AddEmojiPost(transactionContext, `{"hash":"1"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the code is required")
+
+	err = post.AddEmojiPost(transactionContext, `{"hash":"1","code":"`+strings.Repeat("a", 33)+`"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the code is longer than 32 characters")
+
+	err = post.AddEmojiPost(transactionContext, `{"hash":"1","code":"a\u0000b"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the code contains control characters")
+
 	tmpPost = &chaincode.Post{Hash: "1", Creator: myOrg2Clientid}
 	bytes, _ = json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
@@ -304,7 +1071,7 @@ func TestRemoveEmojiPost(t *testing.T) {
 	err = post.RemoveEmojiPost(transactionContext, string(emojiInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpPost := &chaincode.Post{Hash: "1", Creator: "1"}
+	tmpPost := &chaincode.Post{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpPost)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
@@ -314,20 +1081,299 @@ func TestRemoveEmojiPost(t *testing.T) {
 	err = post.RemoveEmojiPost(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
-	tmpPost = &chaincode.Post{Hash: "1", Creator: myOrg2Clientid}
-	bytes, _ = json.Marshal(tmpPost)
-	chaincodeStub.GetStateReturns(bytes, nil)
-
-	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
 	err = post.RemoveEmojiPost(transactionContext, string(emojiInput))
-	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+}
 
-	testRemoveEmojiPost := &chaincode.Post{Hash: "1", Creator: "1", Emojis: make(map[string][]string)}
-	testRemoveEmojiPost.Emojis["1"] = []string{"1"}
-	bytes, _ = json.Marshal(testRemoveEmojiPost)
-	chaincodeStub.GetStateReturns(bytes, nil)
-	err = post.RemoveEmojiPost(transactionContext, string(emojiInput))
-	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+func TestRotatedVotes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	// the profile of myOrg1Userid was rotated away from wallet0, which upvoted the post
+	rotated, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "previousWallets": []string{"wallet0"}})
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(shim.Success(rotated))
+	tmpPost, _ := json.Marshal(&chaincode.Post{Hash: "1", Creator: myOrg2Clientid})
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{
+		"\x00post~hash\x001\x00":                   tmpPost,
+		"\x00vote~hash~wallet\x001\x00wallet0\x00": []byte("up"),
+	})
+
+	// upvoting again withdraws the vote of the old wallet instead of casting a second one
+	err := post.UpvotePost(transactionContext, string(upvoteInput))
+	require.NoError(t, err)
+	require.Equal(t, 0, chaincodeStub.PutStateCallCount())
+	require.Equal(t, 2, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet0\x00", chaincodeStub.DelStateArgsForCall(0))
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", chaincodeStub.DelStateArgsForCall(1))
+
+	// downvoting moves the vote of the old wallet to the new one
+	err = post.DownvotePost(transactionContext, string(upvoteInput))
+	require.NoError(t, err)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet0\x00", chaincodeStub.DelStateArgsForCall(2))
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", key)
+	require.Equal(t, []byte("down"), value)
+
+	// the reaction of the old wallet is dropped when the new wallet reacts alike
+	err = post.AddEmojiPost(transactionContext, string(emojiInput))
+	require.NoError(t, err)
+	require.Equal(t, "\x00emoji~hash~code~wallet\x001\x001\x00wallet0\x00", chaincodeStub.DelStateArgsForCall(3))
+	key, _ = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00emoji~hash~code~wallet\x001\x001\x00myOrg1Userid\x00", key)
+}
+
+func TestReadPostVotes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		iterator := &mocks.StateQueryIterator{}
+		var votes []*queryresult.KV
+		switch objectType {
+		case "vote~hash~wallet":
+			votes = []*queryresult.KV{
+				{Key: "\x00vote~hash~wallet\x001\x00wallet1\x00", Value: []byte("up")},
+				{Key: "\x00vote~hash~wallet\x001\x00wallet2\x00", Value: []byte("down")},
+			}
+		case "emoji~hash~code~wallet":
+			votes = []*queryresult.KV{
+				{Key: "\x00emoji~hash~code~wallet\x001\x00smile\x00wallet1\x00"},
+			}
+		}
+		for i, vote := range votes {
+			iterator.HasNextReturnsOnCall(i, true)
+			iterator.NextReturnsOnCall(i, vote, nil)
+		}
+		return iterator, nil
+	}
+	chaincodeStub.SplitCompositeKeyStub = func(key string) (string, []string, error) {
+		parts := strings.Split(strings.Trim(key, "\x00"), "\x00")
+		return parts[0], parts[1:], nil
+	}
+
+	read, err := post.ReadPost(transactionContext, "1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"wallet1"}, read.Upvotes)
+	require.Equal(t, []string{"wallet2"}, read.Downvotes)
+	require.Equal(t, map[string][]string{"smile": {"wallet1"}}, read.Emojis)
+
+	chaincodeStub.GetStateByPartialCompositeKeyStub = nil
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving votes"))
+	_, err = post.ReadPost(transactionContext, "1")
+	require.EqualError(t, err, "failed retrieving votes")
+}
+
+func TestGetThread(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	posts := []*chaincode.Post{
+		{Hash: "a", BelongTo: "t", CreatedAt: 2},
+		{Hash: "b", BelongTo: "t", CreatedAt: 1},
+		{Hash: "c", BelongTo: "t", ReplyTo: "a", CreatedAt: 3},
+		{Hash: "d", BelongTo: "t", ReplyTo: "c", CreatedAt: 4},
+		{Hash: "e", BelongTo: "t", ReplyTo: "a", CreatedAt: 3, Deleted: true},
+		{Hash: "f", BelongTo: "t", ReplyTo: "e", CreatedAt: 5},
+		{Hash: "g", BelongTo: "t", ReplyTo: "z", CreatedAt: 6},
+		{Hash: "h", BelongTo: "t", CreatedAt: 7, Hidden: true},
+	}
+	states := map[string][]byte{}
+	// the purged post z keeps its thread entry while g replies to it
+	entries := []string{"\x00thread~belongTo~replyTo~createdAt~hash\x00t\x00\x0000000000000000000000\x00z\x00"}
+	for _, stored := range posts {
+		states["\x00post~hash\x00"+stored.Hash+"\x00"], _ = json.Marshal(stored)
+		entries = append(entries, fmt.Sprintf("\x00thread~belongTo~replyTo~createdAt~hash\x00t\x00%s\x00%020d\x00%s\x00", stored.ReplyTo, stored.CreatedAt, stored.Hash))
+	}
+	sort.Strings(entries)
+
+	chaincodeStub.GetStateStub = worldState(states)
+	var read []string
+	chaincodeStub.GetStateByPartialCompositeKeyStub = func(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+		if objectType == "thread~belongTo~replyTo~createdAt~hash" {
+			read = append(read, keys[1])
+		}
+		iterator, _ := indexPage(entries, objectType, keys, 0, "")
+		return iterator, nil
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationStub = func(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		iterator, metadata := indexPage(entries, objectType, keys, pageSize, bookmark)
+		return iterator, metadata, nil
+	}
+	chaincodeStub.SplitCompositeKeyStub = func(key string) (string, []string, error) {
+		parts := strings.Split(key[1:len(key)-1], "\x00")
+		return parts[0], parts[1:], nil
+	}
+
+	page, err := post.GetThread(transactionContext, "t", `{"pageSize":10}`)
+	require.NoError(t, err)
+	require.Equal(t, "z*(g) b a(c(d) e*(f))", threadOutline(page.Records))
+	require.Equal(t, int32(3), page.FetchedRecordsCount)
+	require.Equal(t, "", page.Bookmark)
+	require.Equal(t, "a", page.Records[2].Post.Hash)
+	require.Nil(t, page.Records[2].Replies[1].Post)
+
+	// only the replies to the posts of the page are read
+	read = nil
+	page, err = post.GetThread(transactionContext, "t", `{"pageSize":2}`)
+	require.NoError(t, err)
+	require.Equal(t, "z*(g) b", threadOutline(page.Records))
+	require.Equal(t, []string{"z", "g", "b"}, read)
+	_, attributes, _, _ := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(1)
+	require.Equal(t, []string{"t", ""}, attributes)
+
+	options, _ := json.Marshal(&chaincode.ThreadOptions{PageSize: 2, Bookmark: page.Bookmark, MaxDepth: 2})
+	page, err = post.GetThread(transactionContext, "t", string(options))
+	require.NoError(t, err)
+	require.Equal(t, "a(c e*)", threadOutline(page.Records))
+	require.Equal(t, 2, page.Records[0].ReplyCount)
+	require.Equal(t, 1, page.Records[0].Replies[0].ReplyCount)
+	require.Equal(t, 1, page.Records[0].Replies[1].ReplyCount)
+	require.Equal(t, "", page.Bookmark)
+
+	page, err = post.GetThread(transactionContext, "t", `{"pageSize":10,"maxDepth":1}`)
+	require.NoError(t, err)
+	require.Equal(t, "z* b a", threadOutline(page.Records))
+	require.Equal(t, 2, page.Records[2].ReplyCount)
+
+	_, err = post.GetThread(transactionContext, "t", `{"pageSize":2,"maxDepth":-1}`)
+	require.EqualError(t, err, "the max depth -1 is negative")
+
+	_, err = post.GetThread(transactionContext, "t", `{}`)
+	require.EqualError(t, err, "the page size 0 is not positive")
+}
+
+// indexPage returns an iterator over the entries under the partial key, sorted as the
+// world state sorts keys, along with the metadata of a page of at most pageSize entries
+// after the bookmark. A pageSize of zero returns every entry.
+func indexPage(entries []string, objectType string, keys []string, pageSize int32, bookmark string) (*mocks.StateQueryIterator, *peer.QueryResponseMetadata) {
+	prefix, _ := shim.CreateCompositeKey(objectType, keys)
+	var matched []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, prefix) && entry > bookmark {
+			matched = append(matched, entry)
+		}
+	}
+
+	metadata := &peer.QueryResponseMetadata{}
+	if pageSize > 0 && len(matched) > int(pageSize) {
+		matched = matched[:pageSize]
+		metadata.Bookmark = matched[pageSize-1]
+	}
+	metadata.FetchedRecordsCount = int32(len(matched))
+
+	iterator := &mocks.StateQueryIterator{}
+	for i, entry := range matched {
+		iterator.HasNextReturnsOnCall(i, true)
+		iterator.NextReturnsOnCall(i, &queryresult.KV{Key: entry}, nil)
+	}
+	return iterator, metadata
+}
+
+func TestPostFloors(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.CreatePost(transactionContext, `{"belongTo":"t","floor":7}`)
+	require.NoError(t, err)
+
+	counterKey, counter := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00floorCounter~belongTo\x00t\x00", counterKey)
+	require.Equal(t, "1", string(counter))
+	floorKey, hash := chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00belongTo~floor\x00t\x0000000000000000000001\x00", floorKey)
+	require.Equal(t, "1", string(hash))
+	_, postJSON := chaincodeStub.PutStateArgsForCall(2)
+	created := &chaincode.Post{}
+	json.Unmarshal(postJSON, created)
+	require.Equal(t, uint64(1), created.Floor)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetTxIDReturns("2")
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{"\x00floorCounter~belongTo\x00t\x00": []byte("41")})
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"t"}`)
+	require.NoError(t, err)
+	_, counter = chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "42", string(counter))
+	floorKey, _ = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00belongTo~floor\x00t\x0000000000000000000042\x00", floorKey)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{"\x00floorCounter~belongTo\x00t\x00": []byte("x")})
+	_, err = post.CreatePost(transactionContext, `{"belongTo":"t"}`)
+	require.ErrorContains(t, err, "the floor counter of the topic t is malformed")
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	_, err = post.CreatePost(transactionContext, `{"floor":7}`)
+	require.NoError(t, err)
+	key, postJSON := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00post~hash\x001\x00", key)
+	created = &chaincode.Post{}
+	json.Unmarshal(postJSON, created)
+	require.Zero(t, created.Floor)
+
+	states := map[string][]byte{}
+	for _, stored := range []*chaincode.Post{
+		{Hash: "a", BelongTo: "t", Floor: 1},
+		{Hash: "b", BelongTo: "t", Floor: 2, Deleted: true},
+		{Hash: "c", BelongTo: "t", Floor: 3},
+		{Hash: "d", BelongTo: "t", Floor: 5},
+	} {
+		states["\x00post~hash\x00"+stored.Hash+"\x00"], _ = json.Marshal(stored)
+		states[fmt.Sprintf("\x00belongTo~floor\x00t\x00%020d\x00", stored.Floor)] = []byte(stored.Hash)
+	}
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateStub = worldState(states)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(&mocks.StateQueryIterator{}, nil)
+
+	posts, err := post.GetPostsByFloorRange(transactionContext, "t", 1, 4)
+	require.NoError(t, err)
+	var hashes []string
+	for _, found := range posts {
+		hashes = append(hashes, found.Hash)
+	}
+	require.Equal(t, []string{"a", "c"}, hashes)
+
+	posts, err = post.GetPostsByFloorRange(transactionContext, "t", 6, 9)
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	_, err = post.GetPostsByFloorRange(transactionContext, "t", 0, 4)
+	require.EqualError(t, err, "the floor range 0 to 4 is invalid")
+	_, err = post.GetPostsByFloorRange(transactionContext, "t", 5, 4)
+	require.EqualError(t, err, "the floor range 5 to 4 is invalid")
+	_, err = post.GetPostsByFloorRange(transactionContext, "t", 1, 100)
+	require.NoError(t, err)
+	_, err = post.GetPostsByFloorRange(transactionContext, "t", 1, 101)
+	require.EqualError(t, err, "the floor range 1 to 101 spans more than 100 floors")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+	err = post.PurgePost(transactionContext, "c")
+	require.NoError(t, err)
+	var deleted []string
+	for i := 0; i < chaincodeStub.DelStateCallCount(); i++ {
+		deleted = append(deleted, chaincodeStub.DelStateArgsForCall(i))
+	}
+	require.Contains(t, deleted, "\x00belongTo~floor\x00t\x0000000000000000000003\x00")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+}
+
+// threadOutline renders the hashes of the nodes, marking collapsed nodes with a star
+// and listing the replies to a node in parentheses.
+func threadOutline(nodes []*chaincode.ThreadNode) string {
+	var outline []string
+	for _, node := range nodes {
+		part := node.Hash
+		if node.Collapsed {
+			part += "*"
+		}
+		if len(node.Replies) > 0 {
+			part += "(" + threadOutline(node.Replies) + ")"
+		}
+		outline = append(outline, part)
+	}
+	return strings.Join(outline, " ")
 }
 
 func TestGetAllPosts(t *testing.T) {
@@ -344,29 +1390,250 @@ func TestGetAllPosts(t *testing.T) {
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	userprofile := &chaincode.SmartContract{}
 	assets, err := userprofile.GetAllPosts(transactionContext)
 	require.NoError(t, err)
 	require.Equal(t, []*chaincode.Post{asset}, assets)
 
+	hidden, _ := json.Marshal(&chaincode.Post{Hash: "user2", Hidden: true})
+	iterator.HasNextReturnsOnCall(2, true)
+	iterator.HasNextReturnsOnCall(3, false)
+	iterator.NextReturns(&queryresult.KV{Value: hidden}, nil)
+	assets, err = userprofile.GetAllPosts(transactionContext)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
 	iterator.HasNextReturns(true)
 	iterator.NextReturns(nil, fmt.Errorf("failed retrieving next item"))
 	assets, err = userprofile.GetAllPosts(transactionContext)
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, assets)
 
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
 	assets, err = userprofile.GetAllPosts(transactionContext)
 	require.EqualError(t, err, "failed retrieving all assets")
 	require.Nil(t, assets)
 }
 
+func TestGetAllPostsWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := &chaincode.SmartContract{}
+
+	asset := &chaincode.Post{Hash: "user1"}
+	bytes, _ := json.Marshal(asset)
+	hidden, _ := json.Marshal(&chaincode.Post{Hash: "user2", Hidden: true})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Value: bytes}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Value: hidden}, nil)
+	// the hidden record is left out of the page and its count
+	metadata := &peer.QueryResponseMetadata{FetchedRecordsCount: 2, Bookmark: "next"}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(iterator, metadata, nil)
+
+	page, err := post.GetAllPostsWithPagination(transactionContext, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.PostPage{Records: []*chaincode.Post{asset}, FetchedRecordsCount: 1, Bookmark: "next"}, page)
+
+	objectType, _, pageSize, bookmark := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, "post~hash", objectType)
+	require.Equal(t, int32(1), pageSize)
+	require.Equal(t, "", bookmark)
+
+	_, err = post.GetAllPostsWithPagination(transactionContext, 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = post.GetAllPostsWithPagination(transactionContext, 1, "next")
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestQueryPostsWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := &chaincode.SmartContract{}
+
+	iterator := &mocks.StateQueryIterator{}
+	metadata := &peer.QueryResponseMetadata{Bookmark: "next"}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(iterator, metadata, nil)
+
+	page, err := post.QueryPostsByCreatorWithPagination(transactionContext, "1", 10, "")
+	require.NoError(t, err)
+	require.Equal(t, "next", page.Bookmark)
+
+	index, values, pageSize, _ := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, "creator~hash", index)
+	require.Equal(t, []string{"1"}, values)
+	require.Equal(t, int32(10), pageSize)
+
+	_, err = post.QueryPostsByBelongToWithPagination(transactionContext, "1", -1, "")
+	require.EqualError(t, err, "the page size -1 is not positive")
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("failure"))
+	_, err = post.QueryPostsByReplyToWithPagination(transactionContext, "1", 10, "")
+	require.EqualError(t, err, "failure")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to migrate keys")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	legacy, _ := json.Marshal(&chaincode.Post{Hash: "1"})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Key: "1", Value: legacy}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Key: "2", Value: []byte("sad")}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+
+	migrated, err := post.MigrateKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00post~hash\x001\x00", key)
+	require.Equal(t, legacy, value)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "1", chaincodeStub.DelStateArgsForCall(0))
+
+	iterator = &mocks.StateQueryIterator{}
+	iterator.HasNextReturns(true)
+	iterator.NextReturns(&queryresult.KV{Key: "1", Value: legacy}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
+	_, err = post.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+
+	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	_, err = post.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+
+	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = post.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestMigrateVotes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.MigrateVotes(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to migrate votes")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	voted, _ := json.Marshal(&chaincode.Post{Hash: "1", Upvotes: []string{"wallet1"}, Emojis: map[string][]string{"smile": {"wallet2"}}})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Value: voted}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Value: sampleInput}, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
+
+	migrated, err := post.MigrateVotes(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet1\x00", key)
+	require.Equal(t, []byte("up"), value)
+	key, _ = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00emoji~hash~code~wallet\x001\x00smile\x00wallet2\x00", key)
+	key, value = chaincodeStub.PutStateArgsForCall(2)
+	require.Equal(t, "\x00post~hash\x001\x00", key)
+	require.NotContains(t, string(value), "upvotes")
+	require.NotContains(t, string(value), "emojis")
+}
+
+func TestBackfillFloors(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	_, err := post.BackfillFloors(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to backfill floors")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	// a was numbered when created, f was created before floors and b, c and g even
+	// before timestamps, which their history dates, and topic u is fully numbered
+	iterator := &mocks.StateQueryIterator{}
+	for i, stored := range []*chaincode.Post{
+		{Hash: "a", BelongTo: "t", Floor: 1, CreatedAt: 3000000},
+		{Hash: "b", BelongTo: "t"},
+		{Hash: "c", BelongTo: "t"},
+		{Hash: "f", BelongTo: "t", CreatedAt: 1500000},
+		{Hash: "g", BelongTo: "t"},
+		{Hash: "d", BelongTo: "u", Floor: 1, CreatedAt: 500},
+		{Hash: "e", CreatedAt: 100},
+	} {
+		value, _ := json.Marshal(stored)
+		iterator.HasNextReturnsOnCall(i, true)
+		iterator.NextReturnsOnCall(i, &queryresult.KV{Key: "\x00post~hash\x00" + stored.Hash + "\x00", Value: value}, nil)
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{
+		"\x00floorCounter~belongTo\x00t\x00": []byte("1"),
+	})
+	// b and c were created under their bare hash and moved together by MigrateKeys, and
+	// g was created under its composite key before timestamps were introduced
+	histories := map[string][]*queryresult.KeyModification{
+		"b": {
+			{TxId: "migrate", Timestamp: &timestamppb.Timestamp{Seconds: 5000}, IsDelete: true},
+			{TxId: "create-b", Timestamp: &timestamppb.Timestamp{Seconds: 2000}},
+		},
+		"c": {
+			{TxId: "migrate", Timestamp: &timestamppb.Timestamp{Seconds: 5000}, IsDelete: true},
+			{TxId: "create-c", Timestamp: &timestamppb.Timestamp{Seconds: 1000}},
+		},
+		"\x00post~hash\x00b\x00": {{TxId: "migrate", Timestamp: &timestamppb.Timestamp{Seconds: 5000}}},
+		"\x00post~hash\x00c\x00": {{TxId: "migrate", Timestamp: &timestamppb.Timestamp{Seconds: 5000}}},
+		"\x00post~hash\x00g\x00": {{TxId: "create-g", Timestamp: &timestamppb.Timestamp{Seconds: 6000}}},
+	}
+	chaincodeStub.GetHistoryForKeyStub = func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		return historyIterator(histories[key]), nil
+	}
+
+	numbered, err := post.BackfillFloors(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 4, numbered)
+
+	written := map[string][]byte{}
+	for i := 0; i < chaincodeStub.PutStateCallCount(); i++ {
+		key, value := chaincodeStub.PutStateArgsForCall(i)
+		written[key] = value
+	}
+	require.Equal(t, []byte("c"), written["\x00belongTo~floor\x00t\x0000000000000000000002\x00"])
+	require.Equal(t, []byte("f"), written["\x00belongTo~floor\x00t\x0000000000000000000003\x00"])
+	require.Equal(t, []byte("b"), written["\x00belongTo~floor\x00t\x0000000000000000000004\x00"])
+	require.Equal(t, []byte("g"), written["\x00belongTo~floor\x00t\x0000000000000000000005\x00"])
+	require.Equal(t, []byte("5"), written["\x00floorCounter~belongTo\x00t\x00"])
+	require.NotContains(t, written, "\x00floorCounter~belongTo\x00u\x00")
+
+	// the floors already handed out are kept
+	require.NotContains(t, written, "\x00belongTo~floor\x00t\x0000000000000000000001\x00")
+	require.NotContains(t, written, "\x00post~hash\x00a\x00")
+	require.NotContains(t, written, "\x00post~hash\x00d\x00")
+	require.Zero(t, chaincodeStub.DelStateCallCount())
+
+	numberedPost := &chaincode.Post{}
+	json.Unmarshal(written["\x00post~hash\x00b\x00"], numberedPost)
+	require.Equal(t, uint64(4), numberedPost.Floor)
+	require.Zero(t, numberedPost.CreatedAt)
+}
+
 func TestQueryPostsByCreator(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	Post := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := Post.QueryPostsByCreator(transactionContext, "1")
 	require.EqualError(t, err, "failure")
 
@@ -376,17 +1643,18 @@ func TestQueryPostsByCreator(t *testing.T) {
 	iterator := &mocks.StateQueryIterator{}
 	iterator.HasNextReturnsOnCall(0, true)
 	iterator.HasNextReturnsOnCall(1, false)
-	iterator.NextReturns(&queryresult.KV{Value: bytes}, nil)
+	iterator.NextReturns(&queryresult.KV{Key: "\x00creator~hash\x001\x00user1\x00"}, nil)
+	chaincodeStub.SplitCompositeKeyReturns("creator~hash", []string{"1", "user1"}, nil)
+	chaincodeStub.GetStateReturns(bytes, nil)
 
-	chaincodeStub.GetQueryResultReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	Posts, err := Post.QueryPostsByCreator(transactionContext, "1")
 	require.NoError(t, err)
 	require.Equal(t, []*chaincode.Post{tmpPost}, Posts)
+	require.Equal(t, "\x00post~hash\x00user1\x00", chaincodeStub.GetStateArgsForCall(0))
 
 	iterator.NextReturns(nil, fmt.Errorf("failed retrieving next item"))
-	iterator.HasNextReturnsOnCall(2, true)
-	iterator.HasNextReturnsOnCall(3, true)
-	chaincodeStub.GetQueryResultReturns(iterator, nil)
+	iterator.HasNextReturns(true)
 	Posts, err = Post.QueryPostsByCreator(transactionContext, "1")
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, Posts)
@@ -396,20 +1664,243 @@ func TestQueryPostsByBelongTo(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	Post := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := Post.QueryPostsByBelongTo(transactionContext, "1")
 	require.EqualError(t, err, "failure")
+
+	index, values := chaincodeStub.GetStateByPartialCompositeKeyArgsForCall(0)
+	require.Equal(t, "belongTo~hash", index)
+	require.Equal(t, []string{"1"}, values)
 }
 
 func TestQueryPostsByReplyTo(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	Post := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := Post.QueryPostsByReplyTo(transactionContext, "1")
 	require.EqualError(t, err, "failure")
+
+	index, values := chaincodeStub.GetStateByPartialCompositeKeyArgsForCall(0)
+	require.Equal(t, "replyTo~hash", index)
+	require.Equal(t, []string{"1"}, values)
 }
 
+func TestQueryPosts(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	chaincodeStub.GetQueryResultReturns(&mocks.StateQueryIterator{}, nil)
+	filter, _ := json.Marshal(&chaincode.PostFilter{Creator: "1", BelongTo: "2", SortBy: "replyTo", Descending: true, Limit: 10})
+	_, err := post.QueryPosts(transactionContext, string(filter))
+	require.NoError(t, err)
+
+	queryString := chaincodeStub.GetQueryResultArgsForCall(0)
+	require.JSONEq(t, `{
+		"selector": {"hash": {"$exists": true}, "creator": "1", "belongTo": "2", "deleted": false, "hidden": false, "replyTo": {"$gt": null}},
+		"sort": [{"replyTo": "desc"}],
+		"limit": 10,
+		"use_index": ["_design/indexReplyToDoc", "indexReplyTo"]
+	}`, queryString)
+
+	_, err = post.QueryPosts(transactionContext, `{"sortBy":"createdAt"}`)
+	require.NoError(t, err)
+
+	queryString = chaincodeStub.GetQueryResultArgsForCall(1)
+	require.JSONEq(t, `{
+		"selector": {"hash": {"$exists": true}, "deleted": false, "hidden": false, "createdAt": {"$gt": null}},
+		"sort": [{"createdAt": "asc"}],
+		"use_index": ["_design/indexCreatedAtDoc", "indexCreatedAt"]
+	}`, queryString)
+
+	_, err = post.QueryPosts(transactionContext, `{"sortBy":"cid"}`)
+	require.EqualError(t, err, "the posts cannot be sorted by cid")
+
+	// including every post still leaves out the documents that are not posts
+	_, err = post.QueryPosts(transactionContext, `{"includeDeleted":true,"includeHidden":true}`)
+	require.NoError(t, err)
+	queryString = chaincodeStub.GetQueryResultArgsForCall(chaincodeStub.GetQueryResultCallCount() - 1)
+	require.Equal(t, `{"selector":{"hash":{"$exists":true}}}`, queryString)
+
+	_, err = post.QueryPosts(transactionContext, `{"limit":-1}`)
+	require.EqualError(t, err, "the limit -1 is negative")
+
+	_, err = post.QueryPosts(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+
+	chaincodeStub.GetQueryResultWithPaginationReturns(&mocks.StateQueryIterator{}, &peer.QueryResponseMetadata{}, nil)
+	_, err = post.QueryPostsWithPagination(transactionContext, `{"replyTo":"1","includeDeleted":true,"includeHidden":true,"limit":5}`, 10, "")
+	require.NoError(t, err)
+
+	queryString, _, _ = chaincodeStub.GetQueryResultWithPaginationArgsForCall(0)
+	require.Equal(t, `{"selector":{"hash":{"$exists":true},"replyTo":"1"}}`, queryString)
+}
+
+func TestGetPostHistory(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	modifications := []*queryresult.KeyModification{
+		{TxId: "tx3", IsDelete: true, Timestamp: &timestamppb.Timestamp{Seconds: 3}},
+		{TxId: "tx2", Value: sampleInput, Timestamp: &timestamppb.Timestamp{Seconds: 2}},
+		{TxId: "tx1", Value: sampleInput, Timestamp: &timestamppb.Timestamp{Seconds: 1}},
+	}
+	chaincodeStub.GetHistoryForKeyCalls(func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		return historyIterator(modifications), nil
+	})
+
+	page, err := post.GetPostHistory(transactionContext, "1", 2, "")
+	require.NoError(t, err)
+	require.Equal(t, "\x00post~hash\x001\x00", chaincodeStub.GetHistoryForKeyArgsForCall(0))
+	require.Equal(t, int32(2), page.FetchedRecordsCount)
+	require.Equal(t, "tx2", page.Bookmark)
+	require.True(t, page.Records[0].IsDelete)
+	require.Nil(t, page.Records[0].Record)
+	require.Equal(t, int64(3000), page.Records[0].Timestamp)
+	require.Equal(t, sampleCID, page.Records[1].Record.CID)
+
+	page, err = post.GetPostHistory(transactionContext, "1", 2, "tx2")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "tx1", page.Records[0].TxID)
+	require.Equal(t, "", page.Bookmark)
+
+	_, err = post.GetPostHistory(transactionContext, "1", 2, "tx4")
+	require.EqualError(t, err, "the bookmark tx4 is not a revision of the post 1")
+
+	_, err = post.GetPostHistory(transactionContext, "1", 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	chaincodeStub.GetHistoryForKeyReturns(nil, fmt.Errorf("history disabled"))
+	chaincodeStub.GetHistoryForKeyStub = nil
+	_, err = post.GetPostHistory(transactionContext, "1", 2, "")
+	require.EqualError(t, err, "failed to read history: history disabled")
+}
+
+// historyIterator returns an iterator over the modifications.
+func historyIterator(modifications []*queryresult.KeyModification) *mocks.HistoryQueryIterator {
+	iterator := &mocks.HistoryQueryIterator{}
+	next := 0
+	iterator.HasNextCalls(func() bool {
+		return next < len(modifications)
+	})
+	iterator.NextCalls(func() (*queryresult.KeyModification, error) {
+		next++
+		return modifications[next-1], nil
+	})
+	return iterator
+}
+
+// signerKey is the ed25519 key of the wallet signing envelopes in the tests.
+var signerKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+// signEnvelope returns an envelope of the payload signed by signerKey for the wallet.
+func signEnvelope(wallet, function, payload, nonce string) string {
+	message := strings.Join([]string{"", "post", function, wallet, "", nonce, payload}, "\x00")
+	envelope, _ := json.Marshal(&chaincode.Envelope{
+		Wallet:    wallet,
+		Function:  function,
+		Payload:   payload,
+		Nonce:     nonce,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signerKey, []byte(message))),
+	})
+	return string(envelope)
+}
+
+func TestSubmitSigned(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	signer, _ := json.Marshal(map[string]interface{}{
+		"wallet":    "wallet9",
+		"publicKey": "ed25519:" + base64.StdEncoding.EncodeToString(signerKey.Public().(ed25519.PublicKey)),
+	})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(signer))
+	hash, err := post.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreatePost", "{}", "n1"))
+	require.NoError(t, err)
+	require.Equal(t, "1", hash)
+
+	key, _ := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00nonce~wallet~nonce\x00wallet9\x00n1\x00", key)
+	_, postJSON := chaincodeStub.PutStateArgsForCall(1)
+	created := &chaincode.Post{}
+	json.Unmarshal(postJSON, created)
+	require.Equal(t, "wallet9", created.Creator)
+
+	// the topic of a signed post is renewed for the signer, not the submitting client
+	var renewed []string
+	chaincodeStub.InvokeChaincodeStub = func(name string, args [][]byte, channel string) peer.Response {
+		if name == "topic" && string(args[0]) == "ReadTopicState" {
+			topic, _ := json.Marshal(map[string]interface{}{"hash": string(args[1])})
+			return shim.Success(topic)
+		}
+		if name == "topic" && string(args[0]) == "RenewTopicUpdateTime" {
+			renewed = append(renewed, string(args[1]))
+			return shim.Success(nil)
+		}
+		return shim.Success(signer)
+	}
+	_, err = post.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreatePost", `{"belongTo":"2"}`, "n4"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, renewed)
+	chaincodeStub.InvokeChaincodeStub = nil
+
+	// a signature made for the topic chaincode is not valid for posts
+	message := strings.Join([]string{"", "topic", "CreatePost", "wallet9", "", "n2", "{}"}, "\x00")
+	topicEnvelope, _ := json.Marshal(&chaincode.Envelope{
+		Wallet:    "wallet9",
+		Function:  "CreatePost",
+		Payload:   "{}",
+		Nonce:     "n2",
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signerKey, []byte(message))),
+	})
+	_, err = post.SubmitSigned(transactionContext, string(topicEnvelope))
+	require.ErrorIs(t, err, chaincode.ErrInvalidSignature)
+
+	_, err = post.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreateTopic", "{}", "n2"))
+	require.EqualError(t, err, "the transaction CreateTopic cannot be submitted signed")
+
+	// the role attribute of the submitting client does not make the wallet a moderator
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(shim.Success(signer))
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetAttributeValueReturns("moderator", true, nil)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	tmpPost, _ := json.Marshal(&chaincode.Post{Hash: "1", Creator: myOrg1Clientid})
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{"\x00post~hash\x001\x00": tmpPost})
+	hideInput, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true, Reason: "spam"})
+	_, err = post.SubmitSigned(transactionContext, signEnvelope("wallet9", "HidePost", string(hideInput), "n3"))
+	require.EqualError(t, err, "the client wallet9 is not permitted to moderate posts")
+
+	chaincodeStub.GetStateStub = nil
+	chaincodeStub.GetStateReturns([]byte("0"), nil)
+	_, err = post.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreatePost", "{}", "n1"))
+	require.ErrorIs(t, err, chaincode.ErrNonceReused)
+}
+
+func TestSubmitRelabeled(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	post := chaincode.SmartContract{}
+
+	// the wallet shares its key with a delegate
+	signerPublicKey := "ed25519:" + base64.StdEncoding.EncodeToString(signerKey.Public().(ed25519.PublicKey))
+	signer, _ := json.Marshal(map[string]interface{}{
+		"wallet":    "wallet9",
+		"publicKey": signerPublicKey,
+		"delegates": []map[string]interface{}{{"id": "phone", "publicKey": signerPublicKey, "scopes": []string{"vote", "write"}, "expiresAt": 2000000}},
+	})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(signer))
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+
+	relabeled := &chaincode.Envelope{}
+	json.Unmarshal([]byte(signEnvelope("wallet9", "CreatePost", "{}", "n1")), relabeled)
+	relabeled.Delegate = "phone"
+	envelope, _ := json.Marshal(relabeled)
+	_, err := post.SubmitSigned(transactionContext, string(envelope))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet9")
+
+	_, err = post.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreatePost", "{}", "n1"))
+	require.NoError(t, err)
+}
 func prepMocksAsOrg1() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	return prepMocks(myOrg1Msp, myOrg1Clientid)
 }
@@ -427,9 +1918,41 @@ func prepMocks(orgMSP, clientId string) (*mocks.TransactionContext, *mocks.Chain
 	// set matching msp ID using peer shim env variable
 	os.Setenv("CORE_PEER_LOCALMSPID", orgMSP)
 	transactionContext.GetClientIdentityReturns(clientIdentity)
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.GetTxIDReturns("1")
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(&mocks.StateQueryIterator{}, nil)
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(clientId))
 	return transactionContext, chaincodeStub
 }
 
+// worldState returns a GetState stub reading the given states, with no other keys set.
+func worldState(states map[string][]byte) func(string) ([]byte, error) {
+	return func(key string) ([]byte, error) {
+		return states[key], nil
+	}
+}
+
+// userprofileStub answers the invocations of userprofile with the user, and whether the
+// user holds a permission with the permissions given.
+func userprofileStub(user peer.Response, permissions ...string) func(string, [][]byte, string) peer.Response {
+	return func(name string, args [][]byte, channel string) peer.Response {
+		if string(args[0]) != "HasPermission" {
+			return user
+		}
+		for _, permission := range permissions {
+			if permission == string(args[2]) {
+				return shim.Success([]byte("true"))
+			}
+		}
+		return shim.Success([]byte("false"))
+	}
+}
+
+func profileResponse(wallet string, roles ...string) peer.Response {
+	user, _ := json.Marshal(map[string]interface{}{"wallet": wallet, "rolesAssigned": roles})
+	return shim.Success(user)
+}
+
 func prepMocksIllegalId() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
@@ -0,0 +1,250 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ThreadOptions selects the part of a thread GetThread returns.
+type ThreadOptions struct {
+	// MaxDepth is how many levels of posts are returned, counting the top level.
+	// Zero returns every level.
+	MaxDepth int    `json:"maxDepth"`
+	PageSize int32  `json:"pageSize"`
+	Bookmark string `json:"bookmark"`
+}
+
+// ThreadNode is a post along with the replies to it, oldest first. Deleted, hidden and
+// purged posts with replies are collapsed to their hash so that the replies keep their
+// place. ReplyCount counts the replies even when they are beyond the depth returned.
+type ThreadNode struct {
+	Hash       string        `json:"hash"`
+	Post       *Post         `json:"post,omitempty"`
+	Collapsed  bool          `json:"collapsed"`
+	ReplyCount int           `json:"replyCount"`
+	Replies    []*ThreadNode `json:"replies,omitempty"`
+}
+
+// ThreadPage is a page of top-level posts of a thread along with the bookmark the
+// next page starts at.
+type ThreadPage struct {
+	Records             []*ThreadNode `json:"records"`
+	FetchedRecordsCount int32         `json:"fetchedRecordsCount"`
+	Bookmark            string        `json:"bookmark"`
+}
+
+// GetThread returns the posts of the topic nested by the posts they reply to. The top
+// level holds the posts replying to none, oldest first, and is paged by the options:
+// a page of at most pageSize posts starts at the bookmark returned with the previous
+// page. Only the replies to the posts of the page are read. Posts stored before the
+// thread index was introduced are listed once RebuildIndexes has run.
+func (s *SmartContract) GetThread(ctx contractapi.TransactionContextInterface, topicHash string, options string) (*ThreadPage, error) {
+	opts := ThreadOptions{}
+	err := decodePayload(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPageSize(opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxDepth < 0 {
+		return nil, fmt.Errorf("the max depth %d is negative", opts.MaxDepth)
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(threadIndex, []string{topicHash, ""}, opts.PageSize, opts.Bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	nodes, err := constructThreadNodes(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ThreadPage{Records: []*ThreadNode{}, Bookmark: metadata.Bookmark}
+	for _, node := range nodes {
+		err = loadReplies(ctx, topicHash, node, 1, opts.MaxDepth)
+		if err != nil {
+			return nil, err
+		}
+		if node.Collapsed && node.ReplyCount == 0 {
+			continue
+		}
+		page.Records = append(page.Records, node)
+	}
+	page.FetchedRecordsCount = int32(len(page.Records))
+
+	return page, nil
+}
+
+// loadReplies reads the replies to a node at the depth, along with their own replies
+// down to maxDepth levels unless maxDepth is zero. The replies to the nodes of the
+// last level are counted but not returned.
+func loadReplies(ctx contractapi.TransactionContextInterface, topicHash string, node *ThreadNode, depth int, maxDepth int) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(threadIndex, []string{topicHash, node.Hash})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	replies, err := constructThreadNodes(ctx, resultsIterator)
+	if err != nil {
+		return err
+	}
+
+	expand := maxDepth == 0 || depth < maxDepth
+	var shown []*ThreadNode
+	for _, reply := range replies {
+		if expand {
+			err = loadReplies(ctx, topicHash, reply, depth+1, maxDepth)
+			if err != nil {
+				return err
+			}
+			if reply.Collapsed && reply.ReplyCount == 0 {
+				continue
+			}
+		} else if reply.Collapsed {
+			replied, err := hasReplies(ctx, topicHash, reply.Hash)
+			if err != nil {
+				return err
+			}
+			if !replied {
+				continue
+			}
+		}
+		shown = append(shown, reply)
+	}
+
+	node.ReplyCount = len(shown)
+	if expand {
+		node.Replies = shown
+	}
+
+	return nil
+}
+
+// constructThreadNodes reads the posts the thread index entries of the resultsIterator
+// point at, collapsing the posts that are deleted, hidden or purged.
+func constructThreadNodes(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]*ThreadNode, error) {
+	var nodes []*ThreadNode
+	var posts []*Post
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(attributes) != 4 {
+			return nil, fmt.Errorf("the index key %s is malformed", queryResponse.Key)
+		}
+
+		node := &ThreadNode{Hash: attributes[3], Collapsed: true}
+		nodes = append(nodes, node)
+
+		key, err := postKey(ctx, node.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		postJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if postJSON == nil {
+			continue
+		}
+
+		var post Post
+		json.Unmarshal(postJSON, &post)
+		if post.isListed() {
+			node.Post, node.Collapsed = &post, false
+			posts = append(posts, &post)
+		}
+	}
+
+	err := fillVotes(ctx, posts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// hasReplies returns whether any post of the topic replies to the post.
+func hasReplies(ctx contractapi.TransactionContextInterface, topicHash string, postId string) (bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(threadIndex, []string{topicHash, postId})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+
+	return resultsIterator.HasNext(), nil
+}
+
+// threadKey returns the thread index key of the post, or an empty key for posts that
+// belong to no topic.
+func threadKey(ctx contractapi.TransactionContextInterface, post *Post) (string, error) {
+	if post.BelongTo == "" {
+		return "", nil
+	}
+
+	return ctx.GetStub().CreateCompositeKey(threadIndex, []string{post.BelongTo, post.ReplyTo, fmt.Sprintf("%020d", post.CreatedAt), post.Hash})
+}
+
+// indexThread updates the thread index entry of a post changing from prev to next.
+// Unlike the other indexes it keeps deleted and hidden posts, which GetThread collapses,
+// and purged posts while replies to them remain, so that the replies keep their place.
+func indexThread(ctx contractapi.TransactionContextInterface, prev *Post, next *Post) error {
+	var stale, fresh string
+	var err error
+	if prev != nil {
+		stale, err = threadKey(ctx, prev)
+		if err != nil {
+			return err
+		}
+	}
+	if next != nil {
+		fresh, err = threadKey(ctx, next)
+		if err != nil {
+			return err
+		}
+	}
+	if stale == fresh {
+		return nil
+	}
+
+	if stale != "" {
+		replied := false
+		if next == nil {
+			replied, err = hasReplies(ctx, prev.BelongTo, prev.Hash)
+			if err != nil {
+				return err
+			}
+		}
+		if !replied {
+			err = ctx.GetStub().DelState(stale)
+			if err != nil {
+				return fmt.Errorf("failed to delete from world state: %v", err)
+			}
+		}
+	}
+
+	if fresh != "" {
+		err = ctx.GetStub().PutState(fresh, []byte{0x00})
+		if err != nil {
+			return fmt.Errorf("failed to put to world state: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// topicChaincode is the chaincode name the topics posts belong to are stored in.
+const topicChaincode = "topic"
+
+// getTxTime returns the time the transaction was proposed in milliseconds since the
+// epoch. Every endorser reads the same time, unlike the clock of the peer.
+func getTxTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime().UnixMilli(), nil
+}
+
+// touchPost marks the post as changed by the transaction, moving it to its next version
+// and setting the time it was updated, along with the time it was created at when it is new.
+func touchPost(ctx contractapi.TransactionContextInterface, post *Post) error {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if post.CreatedAt == 0 {
+		post.CreatedAt = now
+	}
+	post.UpdatedAt = now
+	post.Version++
+	return nil
+}
+
+// renewTopic marks the topic as last active at the transaction time in the topic chaincode.
+func renewTopic(ctx contractapi.TransactionContextInterface, topicId string) error {
+	args := [][]byte{[]byte("RenewTopicUpdateTime"), []byte(topicId)}
+	response := ctx.GetStub().InvokeChaincode(topicChaincode, args, "")
+	if response.Status != shim.OK {
+		return fmt.Errorf("failed to renew topic %s: %s", topicId, response.Message)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidPayload is returned when a payload breaks the rules for the fields of a post.
+var ErrInvalidPayload = errors.New("ERR_INVALID_PAYLOAD")
+
+// Limits on the fields of a post.
+const (
+	maxIDLength     = 128
+	maxAssets       = 20
+	maxReasonLength = 500
+	maxCodeLength   = 32
+)
+
+// idPattern matches hashes and the other identifiers a post refers to.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// decodePayload decodes the JSON payload into v, rejecting fields v does not have and
+// anything following the payload.
+func decodePayload(payload string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after the payload")
+	}
+	return nil
+}
+
+// validate returns ErrInvalidPayload when a field of the post breaks its rules.
+func (p *Post) validate() error {
+	return firstError(
+		checkID("hash", p.Hash, true),
+		checkCID("cid", p.CID, false),
+		checkID("replyTo", p.ReplyTo, false),
+		checkID("belongTo", p.BelongTo, false),
+		checkList("assets", p.Assets, maxAssets, func(field string, value string) error {
+			return checkCID(field, value, true)
+		}),
+	)
+}
+
+// checkText checks the value is at most max characters long and free of control characters.
+func checkText(field string, value string, max int, required bool) error {
+	if value == "" && required {
+		return fmt.Errorf("%w: the %s is required", ErrInvalidPayload, field)
+	}
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%w: the %s is longer than %d characters", ErrInvalidPayload, field, max)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: the %s contains control characters", ErrInvalidPayload, field)
+	}
+	return nil
+}
+
+// checkID checks the value is an identifier of at most maxIDLength letters, digits, '-' and '_'.
+func checkID(field string, value string, required bool) error {
+	if value == "" && required {
+		return fmt.Errorf("%w: the %s is required", ErrInvalidPayload, field)
+	}
+	if len(value) > maxIDLength {
+		return fmt.Errorf("%w: the %s is longer than %d characters", ErrInvalidPayload, field, maxIDLength)
+	}
+	if !idPattern.MatchString(value) {
+		return fmt.Errorf("%w: the %s may only contain letters, digits, '-' and '_'", ErrInvalidPayload, field)
+	}
+	return nil
+}
+
+// checkList checks the list holds at most max values, each passing check.
+func checkList(field string, values []string, max int, check func(field string, value string) error) error {
+	if len(values) > max {
+		return fmt.Errorf("%w: the %s hold more than %d values", ErrInvalidPayload, field, max)
+	}
+	for _, value := range values {
+		err := check(field, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Votes and reactions are stored under keys of their own rather than in the post, so
+// concurrent voters never write the same key and commit in the same block. The tallies
+// of a post are collected from these keys whenever it is returned to a client.
+const (
+	// voteIndex keys hold the direction of the vote a wallet cast on a post.
+	voteIndex = "vote~hash~wallet"
+	// emojiIndex keys mark a wallet reacting to a post with an emoji.
+	emojiIndex = "emoji~hash~code~wallet"
+
+	voteUp   = "up"
+	voteDown = "down"
+)
+
+// toggleVote casts the vote of the profile on the post in the direction, withdrawing it
+// instead when the profile already voted that way. A vote cast by a wallet the profile
+// was rotated away from counts as the vote of the profile and moves to its current
+// wallet, so that rotating a wallet never lets its owner vote twice.
+func toggleVote(ctx contractapi.TransactionContextInterface, hash string, user *profile, direction string) error {
+	current, err := readVote(ctx, hash, user.Wallet)
+	if err != nil {
+		return err
+	}
+
+	for _, previous := range user.PreviousWallets {
+		vote, err := readVote(ctx, hash, previous)
+		if err != nil {
+			return err
+		}
+		if vote == "" {
+			continue
+		}
+		if current == "" {
+			current = vote
+		}
+		err = putVote(ctx, hash, previous, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if current == direction {
+		return putVote(ctx, hash, user.Wallet, "")
+	}
+	return putVote(ctx, hash, user.Wallet, direction)
+}
+
+// readVote returns the direction of the vote of the wallet on the post, or "" when it
+// did not vote.
+func readVote(ctx contractapi.TransactionContextInterface, hash string, wallet string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(voteIndex, []string{hash, wallet})
+	if err != nil {
+		return "", err
+	}
+
+	vote, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return string(vote), nil
+}
+
+// putVote records the vote of the wallet on the post, or removes it when direction is empty.
+func putVote(ctx contractapi.TransactionContextInterface, hash string, wallet string, direction string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(voteIndex, []string{hash, wallet})
+	if err != nil {
+		return err
+	}
+
+	if direction == "" {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		return nil
+	}
+
+	err = ctx.GetStub().PutState(key, []byte(direction))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// putEmoji records the reaction of the wallet to the post, or removes it when react is false.
+func putEmoji(ctx contractapi.TransactionContextInterface, hash string, code string, wallet string, react bool) error {
+	// the code is part of the key, so it is checked like the fields of a payload
+	err := checkText("code", code, maxCodeLength, true)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(emojiIndex, []string{hash, code, wallet})
+	if err != nil {
+		return err
+	}
+
+	if !react {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		return nil
+	}
+
+	err = ctx.GetStub().PutState(key, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// reactEmoji records the reaction of the profile to the post, or removes it when react
+// is false. Reactions of the wallets the profile was rotated away from are removed, so
+// that the reaction is held by its current wallet alone.
+func reactEmoji(ctx contractapi.TransactionContextInterface, hash string, code string, user *profile, react bool) error {
+	for _, previous := range user.PreviousWallets {
+		err := putEmoji(ctx, hash, code, previous, false)
+		if err != nil {
+			return err
+		}
+	}
+	return putEmoji(ctx, hash, code, user.Wallet, react)
+}
+
+// fillVotes sets the upvotes, downvotes and emojis of the posts from their vote keys.
+func fillVotes(ctx contractapi.TransactionContextInterface, posts ...*Post) error {
+	for _, post := range posts {
+		err := fillPostVotes(ctx, post)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fillPostVotes(ctx contractapi.TransactionContextInterface, post *Post) error {
+	post.Upvotes, post.Downvotes, post.Emojis = nil, nil, nil
+
+	votesIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(voteIndex, []string{post.Hash})
+	if err != nil {
+		return err
+	}
+	defer votesIterator.Close()
+
+	for votesIterator.HasNext() {
+		queryResponse, err := votesIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(attributes) != 2 {
+			return fmt.Errorf("the vote key %s is malformed", queryResponse.Key)
+		}
+
+		switch string(queryResponse.Value) {
+		case voteUp:
+			post.Upvotes = append(post.Upvotes, attributes[1])
+		case voteDown:
+			post.Downvotes = append(post.Downvotes, attributes[1])
+		}
+	}
+
+	emojisIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(emojiIndex, []string{post.Hash})
+	if err != nil {
+		return err
+	}
+	defer emojisIterator.Close()
+
+	for emojisIterator.HasNext() {
+		queryResponse, err := emojisIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(attributes) != 3 {
+			return fmt.Errorf("the emoji key %s is malformed", queryResponse.Key)
+		}
+
+		if post.Emojis == nil {
+			post.Emojis = make(map[string][]string)
+		}
+		post.Emojis[attributes[1]] = append(post.Emojis[attributes[1]], attributes[2])
+	}
+
+	return nil
+}
+
+// explodeVotes moves the votes and emojis stored inside a post, as written before vote
+// keys were introduced, to vote keys. The post must be written afterwards to drop them.
+func explodeVotes(ctx contractapi.TransactionContextInterface, post *Post) error {
+	for _, wallet := range post.Upvotes {
+		err := putVote(ctx, post.Hash, wallet, voteUp)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, wallet := range post.Downvotes {
+		if contains(post.Upvotes, wallet) {
+			continue
+		}
+		err := putVote(ctx, post.Hash, wallet, voteDown)
+		if err != nil {
+			return err
+		}
+	}
+
+	for code, wallets := range post.Emojis {
+		for _, wallet := range wallets {
+			err := putEmoji(ctx, post.Hash, code, wallet, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteVotes removes every vote and emoji cast on the post.
+func deleteVotes(ctx contractapi.TransactionContextInterface, hash string) error {
+	err := deleteKeys(ctx, voteIndex, hash)
+	if err != nil {
+		return err
+	}
+	return deleteKeys(ctx, emojiIndex, hash)
+}
+
+// deleteKeys removes every key in the index starting with the hash.
+func deleteKeys(ctx contractapi.TransactionContextInterface, index string, hash string) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{hash})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from world state: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// allowedCodecs and allowedHashes are the policy CIDs must satisfy. The hash functions
+// map to the length of their digests.
+var (
+	allowedCodecs = map[uint64]bool{cid.Raw: true, cid.DagProtobuf: true, cid.DagCBOR: true}
+	allowedHashes = map[uint64]int{
+		multihash.SHA2_256:             32,
+		multihash.SHA2_512:             64,
+		multihash.Names["blake2b-256"]: 32,
+	}
+)
+
+const maxCIDLength = 128
+
+// parseCID decodes a CIDv0 or a multibase CIDv1 and returns it as a CIDv1, so that every
+// encoding of the same CID compares equal.
+func parseCID(value string) (cid.Cid, error) {
+	decoded, err := cid.Decode(value)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !allowedCodecs[decoded.Type()] {
+		return cid.Undef, fmt.Errorf("codec 0x%x is not allowed", decoded.Type())
+	}
+
+	hash, err := multihash.Decode(decoded.Hash())
+	if err != nil {
+		return cid.Undef, err
+	}
+	digestLength, found := allowedHashes[hash.Code]
+	if !found {
+		return cid.Undef, fmt.Errorf("hash function 0x%x is not allowed", hash.Code)
+	}
+	if hash.Length != digestLength {
+		return cid.Undef, fmt.Errorf("the digest of hash function 0x%x must be %d bytes long", hash.Code, digestLength)
+	}
+
+	return cid.NewCidV1(decoded.Type(), decoded.Hash()), nil
+}
+
+// checkCID checks the value is a CID satisfying the codec and hash function policy.
+func checkCID(field string, value string, required bool) error {
+	if value == "" {
+		if required {
+			return fmt.Errorf("%w: the %s is required", ErrInvalidPayload, field)
+		}
+		return nil
+	}
+	if len(value) > maxCIDLength {
+		return fmt.Errorf("%w: the %s is longer than %d characters", ErrInvalidPayload, field, maxCIDLength)
+	}
+	_, err := parseCID(value)
+	if err != nil {
+		return fmt.Errorf("%w: the %s is not a valid CID: %v", ErrInvalidPayload, field, err)
+	}
+	return nil
+}
+
+// VerifyContent reports whether the content with the CID is anchored by the current
+// version of the topic, either as its content or as one of its images. CIDs are compared
+// by what they address, so the CIDv0 and the CIDv1 of the same content both match.
+func (s *SmartContract) VerifyContent(ctx contractapi.TransactionContextInterface, topicId string, cid string) (bool, error) {
+	content, err := parseCID(cid)
+	if err != nil {
+		return false, fmt.Errorf("%w: the cid is not a valid CID: %v", ErrInvalidPayload, err)
+	}
+
+	topic, err := readTopic(ctx, topicId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, anchored := range append([]string{topic.CID}, topic.Images...) {
+		anchoredContent, err := parseCID(anchored)
+		if err == nil && anchoredContent.Equals(content) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
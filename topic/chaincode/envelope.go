@@ -0,0 +1,182 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// signingDomain separates the signatures of topic transactions from the signatures of
+// transactions of the other chaincodes.
+const signingDomain = "topic"
+
+// nonceObjectType keys mark the nonces a wallet has signed envelopes with.
+const nonceObjectType = "nonce~wallet~nonce"
+
+// maxWalletLength is the length limit userprofile puts on wallets.
+const maxWalletLength = 256
+
+var (
+	// ErrInvalidSignature is returned when an envelope is not signed by the key registered
+	// to its wallet.
+	ErrInvalidSignature = errors.New("ERR_INVALID_SIGNATURE")
+	// ErrNonceReused is returned when an envelope repeats a nonce of its wallet.
+	ErrNonceReused = errors.New("ERR_NONCE_REUSED")
+)
+
+// Envelope carries the payload of a transaction signed by the wallet acting in it, so
+// that a gateway may submit the transaction on the wallet's behalf. The signature is
+// the base64 encoded signature of the message signedMessage returns.
+type Envelope struct {
+	Wallet    string `json:"wallet"`
+	Function  string `json:"function"`
+	Payload   string `json:"payload"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+	// Delegate names the delegate of the wallet that signed the envelope, if the wallet
+	// key did not sign it.
+	Delegate string `json:"delegate,omitempty"`
+}
+
+// signedScopes maps the transactions that may be submitted signed to the scope a
+// delegate needs to sign them.
+var signedScopes = map[string]string{
+	"CreateTopic":      scopeWrite,
+	"UpdateTopic":      scopeWrite,
+	"DeleteTopic":      scopeWrite,
+	"RestoreTopic":     scopeWrite,
+	"HideTopic":        scopeModerate,
+	"LockTopic":        scopeModerate,
+	"UpvoteTopic":      scopeVote,
+	"DownvoteTopic":    scopeVote,
+	"AddEmojiTopic":    scopeReact,
+	"RemoveEmojiTopic": scopeReact,
+}
+
+// signedContext is the context of a transaction submitted in an envelope. The wallet
+// that signed the envelope acts in the transaction in place of the submitting client.
+type signedContext struct {
+	contractapi.TransactionContextInterface
+	wallet string
+}
+
+// signedWallet returns the wallet that signed the envelope of the transaction, if any.
+func signedWallet(ctx contractapi.TransactionContextInterface) (string, bool) {
+	signed, ok := ctx.(*signedContext)
+	if !ok {
+		return "", false
+	}
+	return signed.wallet, true
+}
+
+// SubmitSigned runs the transaction the envelope names with its payload, acting as the
+// wallet that signed the envelope. It returns the hash of the topic created, if any.
+func (s *SmartContract) SubmitSigned(ctx contractapi.TransactionContextInterface, envelope string) (string, error) {
+	env := Envelope{}
+	err := decodePayload(envelope, &env)
+	if err != nil {
+		return "", err
+	}
+
+	err = openEnvelope(ctx, &env)
+	if err != nil {
+		return "", err
+	}
+
+	signed := &signedContext{TransactionContextInterface: ctx, wallet: env.Wallet}
+	switch env.Function {
+	case "CreateTopic":
+		return s.CreateTopic(signed, env.Payload)
+	case "UpdateTopic":
+		return "", s.UpdateTopic(signed, env.Payload)
+	case "DeleteTopic":
+		return "", s.DeleteTopic(signed, env.Payload)
+	case "RestoreTopic":
+		return "", s.RestoreTopic(signed, env.Payload)
+	case "HideTopic":
+		return "", s.HideTopic(signed, env.Payload)
+	case "LockTopic":
+		return "", s.LockTopic(signed, env.Payload)
+	case "UpvoteTopic":
+		return "", s.UpvoteTopic(signed, env.Payload)
+	case "DownvoteTopic":
+		return "", s.DownvoteTopic(signed, env.Payload)
+	case "AddEmojiTopic":
+		return "", s.AddEmojiTopic(signed, env.Payload)
+	case "RemoveEmojiTopic":
+		return "", s.RemoveEmojiTopic(signed, env.Payload)
+	}
+	return "", fmt.Errorf("the transaction %s cannot be submitted signed", env.Function)
+}
+
+// openEnvelope verifies the envelope is signed by the key registered to its wallet, or
+// by one of its delegates, and records its nonce, rejecting nonces the wallet used before.
+func openEnvelope(ctx contractapi.TransactionContextInterface, env *Envelope) error {
+	scope, found := signedScopes[env.Function]
+	if !found {
+		return fmt.Errorf("the transaction %s cannot be submitted signed", env.Function)
+	}
+
+	err := firstError(
+		checkText("wallet", env.Wallet, maxWalletLength, true),
+		checkID("nonce", env.Nonce, true),
+		checkID("delegate", env.Delegate, false),
+	)
+	if err != nil {
+		return err
+	}
+
+	user, err := readProfile(ctx, env.Wallet)
+	if err != nil {
+		return err
+	}
+
+	encodedKey, err := signingKey(ctx, env, user, scope)
+	if err != nil {
+		return err
+	}
+
+	key, err := parsePublicKey(encodedKey)
+	if err != nil {
+		return fmt.Errorf("%w: the public key of the wallet %s is invalid: %v", ErrInvalidSignature, env.Wallet, err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil || !key.verify(signedMessage(ctx, env), signature) {
+		return fmt.Errorf("%w: the envelope is not signed by the wallet %s", ErrInvalidSignature, env.Wallet)
+	}
+
+	return useNonce(ctx, env.Wallet, env.Nonce)
+}
+
+// signedMessage returns the message the wallet signs: the channel, the signing domain,
+// the function, the wallet, the delegate, the nonce and the payload separated by NUL
+// characters. The delegate is empty when the wallet key signs.
+func signedMessage(ctx contractapi.TransactionContextInterface, env *Envelope) []byte {
+	return []byte(strings.Join([]string{ctx.GetStub().GetChannelID(), signingDomain, env.Function, env.Wallet, env.Delegate, env.Nonce, env.Payload}, "\x00"))
+}
+
+// useNonce records the nonce of the wallet along with the transaction using it.
+func useNonce(ctx contractapi.TransactionContextInterface, wallet string, nonce string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(nonceObjectType, []string{wallet, nonce})
+	if err != nil {
+		return err
+	}
+
+	used, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if used != nil {
+		return fmt.Errorf("%w: the nonce %s of the wallet %s was already used", ErrNonceReused, nonce, wallet)
+	}
+
+	err = ctx.GetStub().PutState(key, []byte(ctx.GetStub().GetTxID()))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TopicRevision is a version of a topic as written by a transaction. The record is
+// nil when the transaction deleted the topic.
+type TopicRevision struct {
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+	IsDelete  bool   `json:"isDelete"`
+	Record    *Topic `json:"record"`
+}
+
+// TopicHistoryPage is a page of revisions along with the bookmark the next page starts at.
+type TopicHistoryPage struct {
+	Records             []*TopicRevision `json:"records"`
+	FetchedRecordsCount int32            `json:"fetchedRecordsCount"`
+	Bookmark            string           `json:"bookmark"`
+}
+
+// GetTopicHistory returns a page of at most pageSize revisions of the topic, newest first,
+// starting after the revision whose transaction ID is the bookmark. The history database
+// must be enabled on the peer. Votes are stored under keys of their own and are not included.
+func (s *SmartContract) GetTopicHistory(ctx contractapi.TransactionContextInterface, topicId string, pageSize int32, bookmark string) (*TopicHistoryPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := topicKey(ctx, topicId)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	// the history cannot be read from an offset, so skip the revisions up to the bookmark
+	for bookmark != "" && resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.TxId == bookmark {
+			bookmark = ""
+		}
+	}
+	if bookmark != "" {
+		return nil, fmt.Errorf("the bookmark %s is not a revision of the topic %s", bookmark, topicId)
+	}
+
+	page := &TopicHistoryPage{}
+	for resultsIterator.HasNext() {
+		if page.FetchedRecordsCount == pageSize {
+			page.Bookmark = page.Records[len(page.Records)-1].TxID
+			break
+		}
+
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		revision := &TopicRevision{
+			TxID:      modification.TxId,
+			Timestamp: modification.Timestamp.AsTime().UnixMilli(),
+			IsDelete:  modification.IsDelete,
+		}
+		if !modification.IsDelete {
+			revision.Record = &Topic{}
+			json.Unmarshal(modification.Value, revision.Record)
+		}
+
+		page.Records = append(page.Records, revision)
+		page.FetchedRecordsCount++
+	}
+
+	return page, nil
+}
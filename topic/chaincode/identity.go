@@ -0,0 +1,262 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// walletAttribute is the certificate attribute carrying the wallet of the client.
+	walletAttribute = "wallet"
+	// roleAttribute is the certificate attribute carrying the role of the client.
+	roleAttribute = "role"
+
+	roleAdmin = "admin"
+	// permissionModerate is the permission to hide, lock and delete the content of others.
+	permissionModerate = "moderate"
+
+	// userprofileChaincode is the chaincode name the user profiles are stored in.
+	userprofileChaincode = "userprofile"
+	// postChaincode is the chaincode name the posts of topics are stored in.
+	postChaincode = "post"
+)
+
+var (
+	// ErrUserMuted is returned when a muted user tries to contribute.
+	ErrUserMuted = errors.New("ERR_USER_MUTED")
+	// ErrUserBanned is returned when a banned user tries to contribute.
+	ErrUserBanned = errors.New("ERR_USER_BANNED")
+	// ErrWalletRotated is returned when a wallet acts after its profile was rotated to
+	// another wallet.
+	ErrWalletRotated = errors.New("ERR_WALLET_ROTATED")
+)
+
+// profile is the subset of the userprofile Profile read by this chaincode.
+type profile struct {
+	Wallet        string     `json:"wallet"`
+	Muted         bool       `json:"muted"`
+	Banned        bool       `json:"banned"`
+	RolesAssigned []string   `json:"rolesAssigned"`
+	PublicKey     string     `json:"publicKey"`
+	Delegates     []delegate `json:"delegates"`
+	// PreviousWallets are the wallets the profile was rotated away from.
+	PreviousWallets []string `json:"previousWallets"`
+}
+
+// getSubmittingWallet returns the wallet of the client submitting the transaction.
+// The wallet that signed the envelope of the transaction takes precedence over the
+// wallet certificate attribute, which takes precedence over the client ID.
+func getSubmittingWallet(ctx contractapi.TransactionContextInterface) (string, error) {
+	if wallet, signed := signedWallet(ctx); signed {
+		return wallet, nil
+	}
+
+	wallet, found, err := ctx.GetClientIdentity().GetAttributeValue(walletAttribute)
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+	if found && wallet != "" {
+		return wallet, nil
+	}
+
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to base64 decode client identity: %v", err)
+	}
+
+	return string(decodeID), nil
+}
+
+// resolveCreator returns the submitting wallet, rejecting payloads claiming another creator.
+func resolveCreator(ctx contractapi.TransactionContextInterface, creator string) (string, error) {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if creator != "" && creator != wallet {
+		return "", fmt.Errorf("the creator %s does not match the submitting client %s", creator, wallet)
+	}
+
+	return wallet, nil
+}
+
+// proposedChaincode returns the name of the chaincode the client proposed the
+// transaction to. It names the invoking chaincode when another chaincode invokes
+// this one, as the proposal is the one of the whole transaction.
+func proposedChaincode(ctx contractapi.TransactionContextInterface) (string, error) {
+	signed, err := ctx.GetStub().GetSignedProposal()
+	if err != nil {
+		return "", fmt.Errorf("failed to read signed proposal: %v", err)
+	}
+
+	proposal := &peer.Proposal{}
+	err = proto.Unmarshal(signed.GetProposalBytes(), proposal)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode proposal: %v", err)
+	}
+
+	payload := &peer.ChaincodeProposalPayload{}
+	err = proto.Unmarshal(proposal.GetPayload(), payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode proposal payload: %v", err)
+	}
+
+	spec := &peer.ChaincodeInvocationSpec{}
+	err = proto.Unmarshal(payload.GetInput(), spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode chaincode invocation: %v", err)
+	}
+
+	return spec.GetChaincodeSpec().GetChaincodeId().GetName(), nil
+}
+
+// readProfile queries the userprofile chaincode for the profile of the given wallet.
+// Userprofile answers for a wallet the profile was rotated away from with the profile
+// under its new wallet, which the old wallet may no longer act for.
+func readProfile(ctx contractapi.TransactionContextInterface, wallet string) (*profile, error) {
+	args := [][]byte{[]byte("ReadUser"), []byte(wallet)}
+	response := ctx.GetStub().InvokeChaincode(userprofileChaincode, args, "")
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("failed to query userprofile: %s", response.Message)
+	}
+
+	var user profile
+	err := json.Unmarshal(response.Payload, &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user profile: %v", err)
+	}
+	if user.Wallet != wallet {
+		return nil, fmt.Errorf("%w: the wallet %s was rotated to %s", ErrWalletRotated, wallet, user.Wallet)
+	}
+
+	return &user, nil
+}
+
+// isCreator reports whether the wallet is the creator, or holds the profile of the
+// creator since rotating it away from the creator wallet.
+func isCreator(ctx contractapi.TransactionContextInterface, creator string, wallet string) (bool, error) {
+	if creator == wallet {
+		return true, nil
+	}
+
+	user, err := readProfile(ctx, wallet)
+	if err != nil {
+		return false, err
+	}
+	return contains(user.PreviousWallets, creator), nil
+}
+
+// checkActive returns an error when the wallet is muted or banned in userprofile.
+func checkActive(ctx contractapi.TransactionContextInterface, wallet string) error {
+	_, err := readActiveProfile(ctx, wallet)
+	return err
+}
+
+// readActiveProfile returns the profile of the wallet, or an error when the wallet is
+// muted or banned in userprofile.
+func readActiveProfile(ctx contractapi.TransactionContextInterface, wallet string) (*profile, error) {
+	user, err := readProfile(ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Banned {
+		return nil, fmt.Errorf("%w: the user %s is banned", ErrUserBanned, wallet)
+	}
+	if user.Muted {
+		return nil, fmt.Errorf("%w: the user %s is muted", ErrUserMuted, wallet)
+	}
+	return user, nil
+}
+
+// hasRole returns true when the submitting wallet holds one of the roles, either
+// through the role certificate attribute or the roles assigned to its profile. The
+// certificate of a client submitting a signed envelope confers no roles on the wallet.
+func hasRole(ctx contractapi.TransactionContextInterface, wallet string, roles ...string) (bool, error) {
+	if _, signed := signedWallet(ctx); !signed {
+		role, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client identity: %v", err)
+		}
+		if found && contains(roles, role) {
+			return true, nil
+		}
+	}
+
+	user, err := readProfile(ctx, wallet)
+	if err != nil {
+		return false, err
+	}
+
+	for _, assigned := range user.RolesAssigned {
+		if contains(roles, assigned) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// canModerate returns true when the wallet holds the moderate permission through the
+// roles userprofile defines.
+func canModerate(ctx contractapi.TransactionContextInterface, wallet string) (bool, error) {
+	return hasPermission(ctx, wallet, permissionModerate)
+}
+
+// hasPermission queries the userprofile chaincode whether the wallet holds the
+// permission, through the roles assigned to its profile or the role certificate
+// attribute of the submitting client.
+func hasPermission(ctx contractapi.TransactionContextInterface, wallet string, permission string) (bool, error) {
+	args := [][]byte{[]byte("HasPermission"), []byte(wallet), []byte(permission)}
+	response := ctx.GetStub().InvokeChaincode(userprofileChaincode, args, "")
+	if response.Status != shim.OK {
+		return false, fmt.Errorf("failed to query userprofile: %s", response.Message)
+	}
+
+	var permitted bool
+	err := json.Unmarshal(response.Payload, &permitted)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode permission: %v", err)
+	}
+	return permitted, nil
+}
+
+// checkAdmin returns an error unless the submitting wallet is an admin. The action
+// describes the operation in the error message.
+func checkAdmin(ctx contractapi.TransactionContextInterface, action string) error {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return err
+	}
+
+	admin, err := hasRole(ctx, wallet, roleAdmin)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("the client %s is not permitted to %s", wallet, action)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
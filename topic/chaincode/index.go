@@ -0,0 +1,179 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Secondary indexes are composite keys of an attribute value and a topic hash with an
+// empty value. Unlike rich queries they work on LevelDB and are checked for phantom reads.
+const (
+	creatorIndex  = "creator~hash"
+	titleIndex    = "title~hash"
+	categoryIndex = "category~hash"
+	tagIndex      = "tag~hash"
+)
+
+var topicIndexes = []string{creatorIndex, titleIndex, categoryIndex, tagIndex}
+
+// indexValues returns the attribute values the topic is listed under in the index.
+// Deleted and hidden topics are left out of every index.
+func (t *Topic) indexValues(index string) []string {
+	if !t.isListed() {
+		return nil
+	}
+
+	switch index {
+	case creatorIndex:
+		return []string{t.Creator}
+	case titleIndex:
+		return []string{t.Title}
+	case categoryIndex:
+		return []string{t.Category}
+	case tagIndex:
+		return t.Tags
+	}
+	return nil
+}
+
+// indexTopic updates the index entries of a topic changing from prev to next, either of
+// which may be nil for a topic being created or removed.
+func indexTopic(ctx contractapi.TransactionContextInterface, prev *Topic, next *Topic) error {
+	for _, index := range topicIndexes {
+		var stale, fresh []string
+		hash := ""
+		if prev != nil {
+			stale, hash = prev.indexValues(index), prev.Hash
+		}
+		if next != nil {
+			fresh, hash = next.indexValues(index), next.Hash
+		}
+
+		for _, value := range stale {
+			if value == "" || contains(fresh, value) {
+				continue
+			}
+
+			key, err := ctx.GetStub().CreateCompositeKey(index, []string{value, hash})
+			if err != nil {
+				return err
+			}
+
+			err = ctx.GetStub().DelState(key)
+			if err != nil {
+				return fmt.Errorf("failed to delete from world state: %v", err)
+			}
+		}
+
+		for _, value := range fresh {
+			if value == "" || contains(stale, value) {
+				continue
+			}
+
+			key, err := ctx.GetStub().CreateCompositeKey(index, []string{value, hash})
+			if err != nil {
+				return err
+			}
+
+			err = ctx.GetStub().PutState(key, []byte{0x00})
+			if err != nil {
+				return fmt.Errorf("failed to put to world state: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getTopicsByIndex returns the topics listed under the value in the index.
+func getTopicsByIndex(ctx contractapi.TransactionContextInterface, index string, value string) ([]*Topic, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{value})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	topics, err := constructTopicsFromIndexIterator(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
+
+	return topics, nil
+}
+
+// getTopicsByIndexWithPagination returns a page of at most pageSize topics listed under
+// the value in the index, starting at the bookmark.
+func getTopicsByIndexWithPagination(ctx contractapi.TransactionContextInterface, index string, value string, pageSize int32, bookmark string) (*TopicPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, []string{value}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	topics, err := constructTopicsFromIndexIterator(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopicPage{
+		Records:             topics,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// constructTopicsFromIndexIterator reads the topics the index entries of the resultsIterator point at
+func constructTopicsFromIndexIterator(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]*Topic, error) {
+	var topics []*Topic
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(attributes) != 2 {
+			return nil, fmt.Errorf("the index key %s is malformed", queryResponse.Key)
+		}
+
+		key, err := topicKey(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+
+		topicJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if topicJSON == nil {
+			continue
+		}
+
+		var topic Topic
+		json.Unmarshal(topicJSON, &topic)
+		topics = append(topics, &topic)
+	}
+
+	return topics, nil
+}
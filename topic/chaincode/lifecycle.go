@@ -0,0 +1,130 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// restoreWindow is how long after its deletion a topic can be restored, in milliseconds.
+const restoreWindow = 30 * 24 * 60 * 60 * 1000
+
+type Restore struct {
+	Hash    string `json:"hash"`
+	Creator string `json:"creator"`
+}
+
+// isListed reports whether the topic shows up in lists and queries by default.
+func (t *Topic) isListed() bool {
+	return !t.Deleted && !t.Hidden
+}
+
+// RestoreTopic undoes the deletion of a topic. The creator and moderators may restore
+// a topic within the restore window after it was deleted, but only moderators undo the
+// deletions of others, such as a moderator removing the topic.
+func (s *SmartContract) RestoreTopic(ctx contractapi.TransactionContextInterface, payload string) error {
+	restore := Restore{}
+
+	err := decodePayload(payload, &restore)
+	if err != nil {
+		return err
+	}
+
+	restore.Creator, err = resolveCreator(ctx, restore.Creator)
+	if err != nil {
+		return err
+	}
+
+	topic, err := readTopic(ctx, restore.Hash)
+	if err != nil {
+		return err
+	}
+	if !topic.Deleted {
+		return fmt.Errorf("the topic %s is not deleted", restore.Hash)
+	}
+
+	err = checkModifiable(ctx, topic, restore.Creator)
+	if err != nil {
+		return err
+	}
+
+	// deletions recorded before DeletedBy was kept are left to checkModifiable
+	if topic.DeletedBy != "" && topic.DeletedBy != restore.Creator {
+		err = checkModerator(ctx, restore.Creator)
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	// deletions recorded before DeletedAt was kept have no known time and are not
+	// held to the restore window
+	if topic.DeletedAt != 0 && now-topic.DeletedAt > restoreWindow {
+		return fmt.Errorf("the topic %s was deleted too long ago to be restored", restore.Hash)
+	}
+
+	topic.Deleted, topic.DeletedAt, topic.DeletedBy = false, 0, ""
+	err = touchTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	err = putTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	restoreJSON, _ := json.Marshal(restore)
+	return ctx.GetStub().SetEvent("RestoreTopic", restoreJSON)
+}
+
+// PurgeTopic removes a topic along with its index entries and votes from the world state.
+// Only admins may purge topics, and the topic then remains only in the history.
+func (s *SmartContract) PurgeTopic(ctx contractapi.TransactionContextInterface, topicId string) error {
+	err := checkAdmin(ctx, "purge topics")
+	if err != nil {
+		return err
+	}
+
+	topic, err := readTopic(ctx, topicId)
+	if err != nil {
+		return err
+	}
+
+	err = indexTopic(ctx, topic, nil)
+	if err != nil {
+		return err
+	}
+
+	err = deleteVotes(ctx, topicId)
+	if err != nil {
+		return err
+	}
+
+	key, err := topicKey(ctx, topicId)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+
+	return ctx.GetStub().SetEvent("PurgeTopic", []byte(topicId))
+}
+
+// listedTopics returns the topics that are neither deleted nor hidden.
+func listedTopics(topics []*Topic) []*Topic {
+	var listed []*Topic
+	for _, topic := range topics {
+		if topic.isListed() {
+			listed = append(listed, topic)
+		}
+	}
+	return listed
+}
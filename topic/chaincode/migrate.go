@@ -0,0 +1,133 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrateKeys moves topics stored under their bare hash, as written before composite
+// keys were introduced, to their composite key, indexes them and moves their votes to
+// vote keys. It returns the number of topics moved.
+func (s *SmartContract) MigrateKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "migrate keys")
+	if err != nil {
+		return 0, err
+	}
+
+	// range queries only cover simple keys, so this visits legacy entries only
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var topic Topic
+		if json.Unmarshal(queryResponse.Value, &topic) != nil || topic.Hash == "" {
+			continue
+		}
+
+		err = explodeVotes(ctx, &topic)
+		if err != nil {
+			return 0, err
+		}
+
+		err = putTopic(ctx, &topic)
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		migrated++
+	}
+
+	return migrated, ctx.GetStub().SetEvent("MigrateKeys", []byte(strconv.Itoa(migrated)))
+}
+
+// RebuildIndexes writes the index entries of every topic, as needed for topics stored
+// before the indexes were introduced. It returns the number of topics indexed.
+func (s *SmartContract) RebuildIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "rebuild indexes")
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(topicObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var topic Topic
+		json.Unmarshal(queryResponse.Value, &topic)
+
+		err = indexTopic(ctx, nil, &topic)
+		if err != nil {
+			return 0, err
+		}
+		indexed++
+	}
+
+	return indexed, ctx.GetStub().SetEvent("RebuildIndexes", []byte(strconv.Itoa(indexed)))
+}
+
+// MigrateVotes moves the votes and emojis stored inside topics, as written before vote
+// keys were introduced, to vote keys. It returns the number of topics changed.
+func (s *SmartContract) MigrateVotes(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "migrate votes")
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(topicObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var topic Topic
+		json.Unmarshal(queryResponse.Value, &topic)
+		if len(topic.Upvotes) == 0 && len(topic.Downvotes) == 0 && len(topic.Emojis) == 0 {
+			continue
+		}
+
+		err = explodeVotes(ctx, &topic)
+		if err != nil {
+			return 0, err
+		}
+
+		err = putTopic(ctx, &topic)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, ctx.GetStub().SetEvent("MigrateVotes", []byte(strconv.Itoa(migrated)))
+}
@@ -0,0 +1,112 @@
+package chaincode
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// TopicPage is a page of topics along with the bookmark the next page starts at.
+type TopicPage struct {
+	Records             []*Topic `json:"records"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Bookmark            string   `json:"bookmark"`
+}
+
+// GetAllTopicsWithPagination returns a page of at most pageSize topics starting at the bookmark.
+// Deleted and hidden topics are left out, so a page may hold fewer than pageSize topics.
+func (s *SmartContract) GetAllTopicsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*TopicPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(topicObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page, err := constructPageFromIterator(ctx, resultsIterator, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Records = listedTopics(page.Records)
+	page.FetchedRecordsCount = int32(len(page.Records))
+	return page, nil
+}
+
+func (s *SmartContract) QueryTopicsByTitleWithPagination(ctx contractapi.TransactionContextInterface, title string, pageSize int32, bookmark string) (*TopicPage, error) {
+	return getTopicsByIndexWithPagination(ctx, titleIndex, title, pageSize, bookmark)
+}
+
+func (s *SmartContract) QueryTopicsByCreatorWithPagination(ctx contractapi.TransactionContextInterface, creator string, pageSize int32, bookmark string) (*TopicPage, error) {
+	return getTopicsByIndexWithPagination(ctx, creatorIndex, creator, pageSize, bookmark)
+}
+
+func (s *SmartContract) QueryTopicsByCategoryWithPagination(ctx contractapi.TransactionContextInterface, category uint, pageSize int32, bookmark string) (*TopicPage, error) {
+	return getTopicsByIndexWithPagination(ctx, categoryIndex, strconv.FormatUint(uint64(category), 10), pageSize, bookmark)
+}
+
+func (s *SmartContract) QueryTopicsByTagWithPagination(ctx contractapi.TransactionContextInterface, tag string, pageSize int32, bookmark string) (*TopicPage, error) {
+	return getTopicsByIndexWithPagination(ctx, tagIndex, tag, pageSize, bookmark)
+}
+
+// QueryTopicsWithPagination returns a page of at most pageSize topics matching the filter
+// in the payload, starting at the bookmark. The limit of the filter is ignored.
+func (s *SmartContract) QueryTopicsWithPagination(ctx contractapi.TransactionContextInterface, payload string, pageSize int32, bookmark string) (*TopicPage, error) {
+	q, err := parseTopicFilter(payload)
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = 0
+
+	return getQueryResultForQueryStringWithPagination(ctx, q.String(), pageSize, bookmark)
+}
+
+// getQueryResultForQueryStringWithPagination executes the passed in query string and
+// returns a page of at most pageSize topics starting at the bookmark.
+func getQueryResultForQueryStringWithPagination(ctx contractapi.TransactionContextInterface, queryString string, pageSize int32, bookmark string) (*TopicPage, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructPageFromIterator(ctx, resultsIterator, metadata)
+}
+
+// constructPageFromIterator constructs a page of topics from the resultsIterator and its metadata
+func constructPageFromIterator(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface, metadata *peer.QueryResponseMetadata) (*TopicPage, error) {
+	topics, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopicPage{
+		Records:             topics,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+func checkPageSize(pageSize int32) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("the page size %d is not positive", pageSize)
+	}
+	return nil
+}
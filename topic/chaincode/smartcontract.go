@@ -3,7 +3,7 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"strconv"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -23,12 +23,31 @@ type Topic struct {
 	Images   []string `json:"images"`
 
 	Deleted bool `json:"deleted"`
+	Hidden  bool `json:"hidden"`
+	Locked  bool `json:"locked"`
+
+	DeletedAt    int64  `json:"deletedAt"`
+	DeletedBy    string `json:"deletedBy"`
+	HiddenReason string `json:"hiddenReason"`
+	HiddenBy     string `json:"hiddenBy"`
+
+	Version        uint64 `json:"version"`
+	CreatedAt      int64  `json:"createdAt"`
+	UpdatedAt      int64  `json:"updatedAt"`
+	LastActivityAt int64  `json:"lastActivityAt"`
 
 	Upvotes   []string            `json:"upvotes"`
 	Downvotes []string            `json:"downvotes"`
 	Emojis    map[string][]string `json:"emojis"`
 }
 
+// topicFields are the fields of a topic clients may change through UpdateTopic.
+var topicFields = []string{"title", "cid", "category", "tags", "images"}
+
+// topicKeys are the fields of an UpdateTopic payload naming the topic, its creator and
+// the version the patch was made against.
+var topicKeys = []string{"hash", "creator", "expectedVersion"}
+
 type Upvote struct {
 	Hash    string `json:"hash"`
 	Creator string `json:"creator"`
@@ -48,39 +67,100 @@ type Emoji struct {
 type Delete struct {
 	Hash    string `json:"hash"`
 	Creator string `json:"creator"`
+	Expectation
 }
 
-// CreateTopic creates a topic.
-func (s *SmartContract) CreateTopic(ctx contractapi.TransactionContextInterface, payload string) error {
+type Hide struct {
+	Hash    string `json:"hash"`
+	Creator string `json:"creator"`
+	Hidden  bool   `json:"hidden"`
+	Reason  string `json:"reason"`
+}
+
+type Lock struct {
+	Hash    string `json:"hash"`
+	Creator string `json:"creator"`
+	Locked  bool   `json:"locked"`
+}
+
+// CreateTopic creates a topic and returns the hash it is stored under. The hash is
+// assigned by the chaincode when the payload does not carry one, see assignTopicID.
+func (s *SmartContract) CreateTopic(ctx contractapi.TransactionContextInterface, payload string) (string, error) {
 
 	topic := Topic{}
-	err := json.Unmarshal([]byte(payload), &topic)
+	err := decodePayload(payload, &topic)
 
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	topic.Creator, err = resolveCreator(ctx, topic.Creator)
+	if err != nil {
+		return "", err
+	}
+
+	err = assignTopicID(ctx, &topic)
+	if err != nil {
+		return "", err
+	}
+
+	err = topic.validate()
+	if err != nil {
+		return "", err
 	}
 
 	exists, err := s.TopicExists(ctx, topic.Hash)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if exists {
-		return fmt.Errorf("the topic %s already exists", topic.Hash)
+		return "", fmt.Errorf("the topic %s already exists", topic.Hash)
 	}
 
-	err = ctx.GetStub().PutState(topic.Hash, []byte(payload))
+	err = checkActive(ctx, topic.Creator)
+	if err != nil {
+		return "", err
+	}
+
+	// votes are cast through their own transactions
+	topic.Upvotes, topic.Downvotes, topic.Emojis = nil, nil, nil
 
+	// deletion, hiding and locking are moderation actions of their own transactions
+	topic.Deleted, topic.DeletedAt, topic.DeletedBy = false, 0, ""
+	topic.Hidden, topic.HiddenReason, topic.HiddenBy = false, "", ""
+	topic.Locked = false
+
+	// versions and timestamps are kept by the chaincode, never taken from the client
+	topic.Version = 0
+	topic.CreatedAt, topic.UpdatedAt, topic.LastActivityAt = 0, 0, 0
+	err = touchTopic(ctx, &topic)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return "", err
 	}
 
-	return ctx.GetStub().SetEvent("CreateTopic", []byte(payload))
+	err = putTopic(ctx, &topic)
+	if err != nil {
+		return "", err
+	}
+
+	topicJSON, _ := json.Marshal(topic)
+	err = ctx.GetStub().SetEvent("CreateTopic", topicJSON)
+	if err != nil {
+		return "", err
+	}
+
+	return topic.Hash, nil
 }
 
 func (s *SmartContract) DeleteTopic(ctx contractapi.TransactionContextInterface, payload string) error {
 	delete := Delete{}
 
-	err := json.Unmarshal([]byte(payload), &delete)
+	err := decodePayload(payload, &delete)
+	if err != nil {
+		return err
+	}
+
+	delete.Creator, err = resolveCreator(ctx, delete.Creator)
 	if err != nil {
 		return err
 	}
@@ -94,24 +174,160 @@ func (s *SmartContract) DeleteTopic(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the topic %s does not exist", delete.Hash)
 	}
 
-	topic, _ := s.ReadTopic(ctx, delete.Hash)
-	if topic.Creator != delete.Creator {
-		return fmt.Errorf("the topic %s is not created by %s", delete.Hash, delete.Creator)
+	topic, _ := readTopic(ctx, delete.Hash)
+	err = checkModifiable(ctx, topic, delete.Creator)
+	if err != nil {
+		return err
 	}
 
-	topic.Deleted = true
-	topicJSON, _ := json.Marshal(topic)
-	err = ctx.GetStub().PutState(delete.Hash, topicJSON)
+	err = delete.check(topic.Hash, topic.Version)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
+	}
+
+	topic.Deleted, topic.DeletedBy = true, delete.Creator
+	err = touchTopic(ctx, topic)
+	if err != nil {
+		return err
 	}
+	topic.DeletedAt = topic.UpdatedAt
 
-	return ctx.GetStub().SetEvent("DeleteTopic", []byte(payload))
+	err = putTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	deleteJSON, _ := json.Marshal(delete)
+	return ctx.GetStub().SetEvent("DeleteTopic", deleteJSON)
+}
+
+// HideTopic hides or reveals a topic, recording the reason and the moderator hiding it.
+// Only moderators and admins may hide topics, and hiding one requires a reason.
+func (s *SmartContract) HideTopic(ctx contractapi.TransactionContextInterface, payload string) error {
+	hide := Hide{}
+
+	err := decodePayload(payload, &hide)
+	if err != nil {
+		return err
+	}
+
+	err = checkText("reason", hide.Reason, maxReasonLength, hide.Hidden)
+	if err != nil {
+		return err
+	}
+
+	hide.Creator, err = resolveCreator(ctx, hide.Creator)
+	if err != nil {
+		return err
+	}
+
+	topic, err := readTopic(ctx, hide.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = checkModerator(ctx, hide.Creator)
+	if err != nil {
+		return err
+	}
+
+	topic.Hidden, topic.HiddenReason, topic.HiddenBy = hide.Hidden, "", ""
+	if hide.Hidden {
+		topic.HiddenReason, topic.HiddenBy = hide.Reason, hide.Creator
+	}
+	err = touchTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	err = putTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	hideJSON, _ := json.Marshal(hide)
+	return ctx.GetStub().SetEvent("HideTopic", hideJSON)
+}
+
+// LockTopic locks or unlocks a topic. Locked topics can only be changed by moderators and admins.
+func (s *SmartContract) LockTopic(ctx contractapi.TransactionContextInterface, payload string) error {
+	lock := Lock{}
+
+	err := decodePayload(payload, &lock)
+	if err != nil {
+		return err
+	}
+
+	lock.Creator, err = resolveCreator(ctx, lock.Creator)
+	if err != nil {
+		return err
+	}
+
+	topic, err := readTopic(ctx, lock.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = checkModerator(ctx, lock.Creator)
+	if err != nil {
+		return err
+	}
+
+	topic.Locked = lock.Locked
+	err = touchTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	err = putTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	lockJSON, _ := json.Marshal(lock)
+	return ctx.GetStub().SetEvent("LockTopic", lockJSON)
+}
+
+// RenewTopicUpdateTime marks the topic as last active at the transaction time. It is
+// invoked by the post chaincode whenever a post is made in the topic, and rejected in
+// transactions proposed to any other chaincode. The post chaincode has already checked
+// the author of the post, who may have signed it rather than submitted it.
+func (s *SmartContract) RenewTopicUpdateTime(ctx contractapi.TransactionContextInterface, topicId string) error {
+	caller, err := proposedChaincode(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != postChaincode {
+		return fmt.Errorf("the topic %s may only be renewed by the %s chaincode", topicId, postChaincode)
+	}
+
+	topic, err := readTopic(ctx, topicId)
+	if err != nil {
+		return err
+	}
+
+	topic.LastActivityAt, err = getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = putTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	topicJSON, _ := json.Marshal(topic)
+	return ctx.GetStub().SetEvent("RenewTopicUpdateTime", topicJSON)
 }
 
 // TopicExists returns true when topic with given ID exists in world state
 func (s *SmartContract) TopicExists(ctx contractapi.TransactionContextInterface, topicId string) (bool, error) {
-	topicJSON, err := ctx.GetStub().GetState(topicId)
+	key, err := topicKey(ctx, topicId)
+	if err != nil {
+		return false, err
+	}
+
+	topicJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -121,29 +337,45 @@ func (s *SmartContract) TopicExists(ctx contractapi.TransactionContextInterface,
 
 // ReadTopic returns the topic stored in the world state with given id.
 func (s *SmartContract) ReadTopic(ctx contractapi.TransactionContextInterface, topicId string) (*Topic, error) {
-	topicJSON, err := ctx.GetStub().GetState(topicId)
+	topic, err := readTopic(ctx, topicId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %v", err)
+		return nil, err
 	}
-	if topicJSON == nil {
-		return nil, fmt.Errorf("the topic %s does not exist", topicId)
+
+	err = fillVotes(ctx, topic)
+	if err != nil {
+		return nil, err
 	}
 
-	var topic Topic
-	json.Unmarshal(topicJSON, &topic)
+	return topic, nil
+}
 
-	return &topic, nil
+// ReadTopicState returns the topic as stored in the world state, without the votes
+// ReadTopic gathers. Other chaincodes check topics through it, so that the vote keys of
+// the topic stay out of the read set of their transactions.
+func (s *SmartContract) ReadTopicState(ctx contractapi.TransactionContextInterface, topicId string) (*Topic, error) {
+	return readTopic(ctx, topicId)
 }
 
-// UpdateTopic updates an existing topic in the world state with provided parameters.
+// UpdateTopic applies the JSON Merge Patch in the payload to an existing topic. The
+// hash and creator identify the topic, and only the fields in topicFields are changed.
+// The payload may carry the expectedVersion the patch was made against.
 func (s *SmartContract) UpdateTopic(ctx contractapi.TransactionContextInterface, payload string) error {
-	next := Topic{}
-	err := json.Unmarshal([]byte(payload), &next)
+	next, expectation := Topic{}, Expectation{}
+	err := decodePayload(payload, &struct {
+		*Topic
+		*Expectation
+	}{&next, &expectation})
 
 	if err != nil {
 		return err
 	}
 
+	wallet, err := resolveCreator(ctx, next.Creator)
+	if err != nil {
+		return err
+	}
+
 	exists, err := s.TopicExists(ctx, next.Hash)
 	if err != nil {
 		return err
@@ -152,27 +384,41 @@ func (s *SmartContract) UpdateTopic(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the topic %s does not exist", next.Hash)
 	}
 
-	prev, _ := s.ReadTopic(ctx, next.Hash)
+	err = checkActive(ctx, wallet)
+	if err != nil {
+		return err
+	}
 
-	x := reflect.ValueOf(&next).Elem()
-	y := reflect.ValueOf(prev).Elem()
+	prev, _ := readTopic(ctx, next.Hash)
+	err = checkModifiable(ctx, prev, wallet)
+	if err != nil {
+		return err
+	}
 
-	// use reflection package to dynamically update non-zero value
-	for i := 0; i < x.NumField(); i++ {
-		name := x.Type().Field(i).Name
-		yf := y.FieldByName(name)
-		xf := x.FieldByName(name)
-		if name != "Hash" && yf.CanSet() && !xf.IsZero() {
-			yf.Set(xf)
-		}
+	err = expectation.check(prev.Hash, prev.Version)
+	if err != nil {
+		return err
 	}
 
-	// overwriting original topic with new topic
-	yJSON, _ := json.Marshal(prev)
-	err = ctx.GetStub().PutState(prev.Hash, yJSON)
+	err = mergePatch(prev, payload, topicFields, topicKeys)
+	if err != nil {
+		return err
+	}
 
+	err = prev.validate()
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
+	}
+
+	err = touchTopic(ctx, prev)
+	if err != nil {
+		return err
+	}
+
+	// overwriting original topic with new topic
+	err = putTopic(ctx, prev)
+	if err != nil {
+		return err
 	}
 
 	return ctx.GetStub().SetEvent("UpdateTopic", []byte(payload))
@@ -180,7 +426,12 @@ func (s *SmartContract) UpdateTopic(ctx contractapi.TransactionContextInterface,
 
 func (s *SmartContract) UpvoteTopic(ctx contractapi.TransactionContextInterface, payload string) error {
 	upvote := Upvote{}
-	err := json.Unmarshal([]byte(payload), &upvote)
+	err := decodePayload(payload, &upvote)
+	if err != nil {
+		return err
+	}
+
+	upvote.Creator, err = resolveCreator(ctx, upvote.Creator)
 	if err != nil {
 		return err
 	}
@@ -193,43 +444,28 @@ func (s *SmartContract) UpvoteTopic(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the topic %s does not exist", upvote.Hash)
 	}
 
-	topic, _ := s.ReadTopic(ctx, upvote.Hash)
-	if topic.Upvotes == nil {
-		topic.Upvotes = make([]string, 0)
-	}
-	flag := true
-	for i, v := range topic.Upvotes {
-		if v == upvote.Creator {
-			topic.Upvotes = append(topic.Upvotes[:i], topic.Upvotes[i+1:]...)
-			flag = false
-			break
-		}
-	}
-	if flag {
-		topic.Upvotes = append(topic.Upvotes, upvote.Creator)
-		if topic.Downvotes != nil {
-			for i, v := range topic.Downvotes {
-				if v == upvote.Creator {
-					topic.Downvotes = append(topic.Downvotes[:i], topic.Downvotes[i+1:]...)
-					break
-				}
-			}
-		}
+	user, err := readActiveProfile(ctx, upvote.Creator)
+	if err != nil {
+		return err
 	}
 
-	topicJSON, _ := json.Marshal(topic)
-	err = ctx.GetStub().PutState(upvote.Hash, topicJSON)
-
+	err = toggleVote(ctx, upvote.Hash, user, voteUp)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("UpvoteTopic", []byte(payload))
+	upvoteJSON, _ := json.Marshal(upvote)
+	return ctx.GetStub().SetEvent("UpvoteTopic", upvoteJSON)
 }
 
 func (s *SmartContract) DownvoteTopic(ctx contractapi.TransactionContextInterface, payload string) error {
 	downvote := Downvote{}
-	err := json.Unmarshal([]byte(payload), &downvote)
+	err := decodePayload(payload, &downvote)
+	if err != nil {
+		return err
+	}
+
+	downvote.Creator, err = resolveCreator(ctx, downvote.Creator)
 	if err != nil {
 		return err
 	}
@@ -242,43 +478,28 @@ func (s *SmartContract) DownvoteTopic(ctx contractapi.TransactionContextInterfac
 		return fmt.Errorf("the topic %s does not exist", downvote.Hash)
 	}
 
-	topic, _ := s.ReadTopic(ctx, downvote.Hash)
-	if topic.Downvotes == nil {
-		topic.Downvotes = make([]string, 0)
-	}
-	flag := true
-	for i, v := range topic.Downvotes {
-		if v == downvote.Creator {
-			topic.Downvotes = append(topic.Downvotes[:i], topic.Downvotes[i+1:]...)
-			flag = false
-			break
-		}
-	}
-	if flag {
-		topic.Downvotes = append(topic.Downvotes, downvote.Creator)
-		if topic.Upvotes != nil {
-			for i, v := range topic.Upvotes {
-				if v == downvote.Creator {
-					topic.Upvotes = append(topic.Upvotes[:i], topic.Upvotes[i+1:]...)
-					break
-				}
-			}
-		}
+	user, err := readActiveProfile(ctx, downvote.Creator)
+	if err != nil {
+		return err
 	}
 
-	topicJSON, _ := json.Marshal(topic)
-
-	err = ctx.GetStub().PutState(downvote.Hash, topicJSON)
+	err = toggleVote(ctx, downvote.Hash, user, voteDown)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("DownvoteTopic", []byte(payload))
+	downvoteJSON, _ := json.Marshal(downvote)
+	return ctx.GetStub().SetEvent("DownvoteTopic", downvoteJSON)
 }
 
 func (s *SmartContract) AddEmojiTopic(ctx contractapi.TransactionContextInterface, payload string) error {
 	emoji := Emoji{}
-	err := json.Unmarshal([]byte(payload), &emoji)
+	err := decodePayload(payload, &emoji)
+	if err != nil {
+		return err
+	}
+
+	emoji.Creator, err = resolveCreator(ctx, emoji.Creator)
 	if err != nil {
 		return err
 	}
@@ -291,24 +512,28 @@ func (s *SmartContract) AddEmojiTopic(ctx contractapi.TransactionContextInterfac
 		return fmt.Errorf("the topic %s does not exist", emoji.Hash)
 	}
 
-	topic, _ := s.ReadTopic(ctx, emoji.Hash)
-	if topic.Emojis == nil {
-		topic.Emojis = make(map[string][]string)
+	user, err := readActiveProfile(ctx, emoji.Creator)
+	if err != nil {
+		return err
 	}
-	topic.Emojis[emoji.Code] = append(topic.Emojis[emoji.Code], emoji.Creator)
-	topicJSON, _ := json.Marshal(topic)
 
-	err = ctx.GetStub().PutState(emoji.Hash, topicJSON)
+	err = reactEmoji(ctx, emoji.Hash, emoji.Code, user, true)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("AddEmojiTopic", []byte(payload))
+	emojiJSON, _ := json.Marshal(emoji)
+	return ctx.GetStub().SetEvent("AddEmojiTopic", emojiJSON)
 }
 
 func (s *SmartContract) RemoveEmojiTopic(ctx contractapi.TransactionContextInterface, payload string) error {
 	emoji := Emoji{}
-	err := json.Unmarshal([]byte(payload), &emoji)
+	err := decodePayload(payload, &emoji)
+	if err != nil {
+		return err
+	}
+
+	emoji.Creator, err = resolveCreator(ctx, emoji.Creator)
 	if err != nil {
 		return err
 	}
@@ -321,32 +546,23 @@ func (s *SmartContract) RemoveEmojiTopic(ctx contractapi.TransactionContextInter
 		return fmt.Errorf("the topic %s does not exist", emoji.Hash)
 	}
 
-	topic, _ := s.ReadTopic(ctx, emoji.Hash)
-	if topic.Emojis[emoji.Code] != nil {
-		for i, v := range topic.Emojis[emoji.Code] {
-			if v == emoji.Creator {
-				topic.Emojis[emoji.Code] = append(topic.Emojis[emoji.Code][:i], topic.Emojis[emoji.Code][i+1:]...)
-				break
-			}
-		}
-		if len(topic.Emojis[emoji.Code]) == 0 {
-			delete(topic.Emojis, emoji.Code)
-		}
+	user, err := readProfile(ctx, emoji.Creator)
+	if err != nil {
+		return err
 	}
 
-	topicJSON, _ := json.Marshal(topic)
-
-	err = ctx.GetStub().PutState(emoji.Hash, topicJSON)
+	err = reactEmoji(ctx, emoji.Hash, emoji.Code, user, false)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
-	return ctx.GetStub().SetEvent("RemoveEmojiTopic", []byte(payload))
+	emojiJSON, _ := json.Marshal(emoji)
+	return ctx.GetStub().SetEvent("RemoveEmojiTopic", emojiJSON)
 }
 
-// GetAllTopics returns all topics found in world state
+// GetAllTopics returns all topics found in world state that are neither deleted nor hidden
 func (s *SmartContract) GetAllTopics(ctx contractapi.TransactionContextInterface) ([]*Topic, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(topicObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -363,28 +579,129 @@ func (s *SmartContract) GetAllTopics(ctx contractapi.TransactionContextInterface
 		json.Unmarshal(queryResponse.Value, &topic)
 		topics = append(topics, &topic)
 	}
+	topics = listedTopics(topics)
+
+	err = fillVotes(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
 
 	return topics, nil
 }
 
 func (s *SmartContract) QueryTopicsByTitle(ctx contractapi.TransactionContextInterface, title string) ([]*Topic, error) {
-	queryString := fmt.Sprintf(`{"selector":{"title":"%s"}}`, title)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getTopicsByIndex(ctx, titleIndex, title)
 }
 
 func (s *SmartContract) QueryTopicsByCreator(ctx contractapi.TransactionContextInterface, creator string) ([]*Topic, error) {
-	queryString := fmt.Sprintf(`{"selector":{"creator":"%s"}}`, creator)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getTopicsByIndex(ctx, creatorIndex, creator)
 }
 
 func (s *SmartContract) QueryTopicsByCategory(ctx contractapi.TransactionContextInterface, category uint) ([]*Topic, error) {
-	queryString := fmt.Sprintf(`{"selector":{"category":"%d"}}`, category)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getTopicsByIndex(ctx, categoryIndex, strconv.FormatUint(uint64(category), 10))
 }
 
 func (s *SmartContract) QueryTopicsByTag(ctx contractapi.TransactionContextInterface, tag string) ([]*Topic, error) {
-	queryString := fmt.Sprintf(`{"selector":{"tags":{"$elemMatch":{"$eq":"%s"}}}}`, tag)
-	return getQueryResultForQueryString(ctx, queryString)
+	return getTopicsByIndex(ctx, tagIndex, tag)
+}
+
+// topicObjectType namespaces the composite keys topics are stored under.
+const topicObjectType = "topic~hash"
+
+// topicKey returns the world state key of the topic with given hash.
+func topicKey(ctx contractapi.TransactionContextInterface, hash string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(topicObjectType, []string{hash})
+}
+
+// putTopic writes the topic to the world state under its composite key and
+// brings its index entries in line with the topic it replaces.
+func putTopic(ctx contractapi.TransactionContextInterface, topic *Topic) error {
+	key, err := topicKey(ctx, topic.Hash)
+	if err != nil {
+		return err
+	}
+
+	prevJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	var prev *Topic
+	if prevJSON != nil {
+		prev = &Topic{}
+		json.Unmarshal(prevJSON, prev)
+	}
+
+	// votes live under vote keys and are only filled in for clients
+	stored := *topic
+	stored.Upvotes, stored.Downvotes, stored.Emojis = nil, nil, nil
+
+	topicJSON, _ := json.Marshal(stored)
+	err = ctx.GetStub().PutState(key, topicJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	return indexTopic(ctx, prev, topic)
+}
+
+// readTopic returns the stored topic without reading its votes, so that transactions
+// changing the topic do not conflict with concurrent votes.
+func readTopic(ctx contractapi.TransactionContextInterface, topicId string) (*Topic, error) {
+	key, err := topicKey(ctx, topicId)
+	if err != nil {
+		return nil, err
+	}
+
+	topicJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if topicJSON == nil {
+		return nil, fmt.Errorf("the topic %s does not exist", topicId)
+	}
+
+	var topic Topic
+	json.Unmarshal(topicJSON, &topic)
+
+	return &topic, nil
+}
+
+// checkModifiable returns an error unless the wallet may change the topic, which
+// requires being its creator while it is unlocked, or being a moderator.
+func checkModifiable(ctx contractapi.TransactionContextInterface, topic *Topic, wallet string) error {
+	creator, err := isCreator(ctx, topic.Creator, wallet)
+	if err != nil {
+		return err
+	}
+	if creator && !topic.Locked {
+		return nil
+	}
+
+	moderator, err := canModerate(ctx, wallet)
+	if err != nil {
+		return err
+	}
+	if moderator {
+		return nil
+	}
+
+	if !creator {
+		return fmt.Errorf("the topic %s is not created by %s", topic.Hash, wallet)
+	}
+	return fmt.Errorf("the topic %s is locked", topic.Hash)
+}
+
+// checkModerator returns an error unless the wallet holds the moderate permission.
+func checkModerator(ctx contractapi.TransactionContextInterface, wallet string) error {
+	moderator, err := canModerate(ctx, wallet)
+	if err != nil {
+		return err
+	}
+	if !moderator {
+		return fmt.Errorf("the client %s is not permitted to moderate topics", wallet)
+	}
+	return nil
 }
 
 // getQueryResultForQueryString executes the passed in query string.
@@ -396,7 +713,17 @@ func getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, q
 	}
 	defer resultsIterator.Close()
 
-	return constructQueryResponseFromIterator(resultsIterator)
+	topics, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillVotes(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
+
+	return topics, nil
 }
 
 // constructQueryResponseFromIterator constructs a slice of topics from the resultsIterator
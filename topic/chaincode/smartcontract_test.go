@@ -1,22 +1,29 @@
 package chaincode_test
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"topic/chaincode"
 	"topic/chaincode/mocks"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 
 	// _ "github.com/maxbrunsfeld/counterfeiter/v6"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -34,6 +41,11 @@ type stateQueryIterator interface {
 	shim.StateQueryIteratorInterface
 }
 
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/historyqueryiterator.go -fake-name HistoryQueryIterator . historyQueryIterator
+type historyQueryIterator interface {
+	shim.HistoryQueryIteratorInterface
+}
+
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/clientIdentity.go -fake-name ClientIdentity . clientIdentity
 type clientIdentity interface {
 	cid.ClientIdentity
@@ -46,29 +58,46 @@ const myOrg2Msp = "Org2Testmsp"
 const myOrg2Clientid = "myOrg2Userid"
 const myOrg2PrivCollection = "Org2TestmspPrivateCollection"
 
+// sampleCID and sampleImage are the CIDv0 of the contents 1 and 2.
+const (
+	sampleCID   = "QmVaPTddRyjLjMoZnYufWc5M5CjyGNPmFEpp5HtPKEqZFG"
+	sampleImage = "QmcdyB29uHtqMRZy47MrhaqFqHpHuPr7eUxWWPJbGpSRxg"
+)
+
 var sampleTopic = &chaincode.Topic{
 	Hash:      "1",
 	Title:     "1",
-	Creator:   "1",
-	CID:       "1",
+	Creator:   myOrg1Clientid,
+	CID:       sampleCID,
 	Category:  "1",
 	Tags:      []string{"1"},
-	Images:    []string{"1"},
+	Images:    []string{sampleImage},
 	Upvotes:   []string{"1"},
 	Downvotes: []string{"1"},
 }
 
 var sampleInput, _ = json.Marshal(sampleTopic)
 
+// samplePatch changes the fields of sampleTopic clients may update to their own values.
+var samplePatch = `{"hash":"1","title":"1","cid":"` + sampleCID + `","category":"1","tags":["1"],"images":["` + sampleImage + `"]}`
+
+// createInput leaves the hash to the chaincode, which the mocks give the transaction ID 1.
+var createInput = func() []byte {
+	topic := *sampleTopic
+	topic.Hash = ""
+	input, _ := json.Marshal(topic)
+	return input
+}()
+
 func TestCreateTopic(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
 
-	err := topic.CreateTopic(transactionContext, string(sampleInput))
+	_, err := topic.CreateTopic(transactionContext, string(createInput))
 	require.NoError(t, err)
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = topic.CreateTopic(transactionContext, string(sampleInput))
+	_, err = topic.CreateTopic(transactionContext, string(createInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
 	expectedTopic := &chaincode.Topic{Hash: "1"}
@@ -76,17 +105,179 @@ func TestCreateTopic(t *testing.T) {
 	require.NoError(t, err)
 
 	chaincodeStub.GetStateReturns(bytes, nil)
-	err = topic.CreateTopic(transactionContext, string(sampleInput))
+	_, err = topic.CreateTopic(transactionContext, string(createInput))
 	require.EqualError(t, err, "the topic 1 already exists")
 
 	chaincodeStub.GetStateReturns(nil, nil)
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = topic.CreateTopic(transactionContext, string(sampleInput))
+	_, err = topic.CreateTopic(transactionContext, string(createInput))
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
+func TestCreateTopicIdentity(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.CreateTopic(transactionContext, string(createInput))
+	require.EqualError(t, err, "the creator myOrg1Userid does not match the submitting client myOrg2Userid")
+
+	anonymousTopic := &chaincode.Topic{Title: "1"}
+	bytes, _ := json.Marshal(anonymousTopic)
+	_, err = topic.CreateTopic(transactionContext, string(bytes))
+	require.NoError(t, err)
+
+	key, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00topic~hash\x001\x00", key)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.Equal(t, myOrg2Clientid, stored.Creator)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetAttributeValueReturns(myOrg1Clientid, true, nil)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid))
+	_, err = topic.CreateTopic(transactionContext, string(createInput))
+	require.NoError(t, err)
+
+	transactionContext, _ = prepMocksIllegalId()
+	_, err = topic.CreateTopic(transactionContext, string(createInput))
+	require.EqualError(t, err, "failed to read client identity: failure")
+}
+
+func TestCreateTopicModerationFields(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.CreateTopic(transactionContext, `{"title":"1","cid":"`+sampleCID+`","deleted":true,"deletedAt":1,"deletedBy":"moderator1","hidden":true,"hiddenReason":"spam","hiddenBy":"moderator1","locked":true}`)
+	require.NoError(t, err)
+
+	key, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00topic~hash\x001\x00", key)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.False(t, stored.Deleted)
+	require.Zero(t, stored.DeletedAt)
+	require.Empty(t, stored.DeletedBy)
+	require.False(t, stored.Hidden)
+	require.Empty(t, stored.HiddenReason)
+	require.Empty(t, stored.HiddenBy)
+	require.False(t, stored.Locked)
+}
+
+func TestTopicIDs(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("tx1")
+	hash, err := topic.CreateTopic(transactionContext, `{"title":"1"}`)
+	require.NoError(t, err)
+	require.Equal(t, "tx1", hash)
+
+	key, _ := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00topic~hash\x00tx1\x00", key)
+	_, eventJSON := chaincodeStub.SetEventArgsForCall(0)
+	created := &chaincode.Topic{}
+	json.Unmarshal(eventJSON, created)
+	require.Equal(t, "tx1", created.Hash)
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	digest := sha256.Sum256([]byte(myOrg1Clientid + "\x00" + sampleCID + "\x001000000"))
+	expected := hex.EncodeToString(digest[:])
+	hash, err = topic.CreateTopic(transactionContext, `{"hash":"`+expected+`","title":"1","cid":"`+sampleCID+`"}`)
+	require.NoError(t, err)
+	require.Equal(t, expected, hash)
+
+	_, err = topic.CreateTopic(transactionContext, `{"hash":"`+expected+`","title":"1","cid":"`+sampleImage+`"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the hash "+expected+" is not the digest of the creator, cid and timestamp")
+
+	_, err = topic.CreateTopic(transactionContext, `{"hash":"1","title":"1"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+}
+
+func TestValidateTopic(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.CreateTopic(transactionContext, `{"title":"1","color":"red"}`)
+	require.EqualError(t, err, `json: unknown field "color"`)
+
+	_, err = topic.CreateTopic(transactionContext, `{"title":"1"} {}`)
+	require.EqualError(t, err, "invalid data after the payload")
+
+	for payload, message := range map[string]string{
+		`{}`: "the title is required",
+		`{"title":"` + strings.Repeat("a", 201) + `"}`:                         "the title is longer than 200 characters",
+		`{"title":"1\u0000"}`:                                                  "the title contains control characters",
+		`{"title":"1","tags":["1","2","3","4","5","6","7","8","9","10","11"]}`: "the tags hold more than 10 values",
+		`{"title":"1","tags":[""]}`:                                            "the tags is required",
+		`{"title":"1","images":["1"]}`:                                         "the images is not a valid CID: invalid cid: cid too short",
+	} {
+		_, err = topic.CreateTopic(transactionContext, payload)
+		require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+		require.EqualError(t, err, "ERR_INVALID_PAYLOAD: "+message)
+	}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":null}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the title is required")
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","titel":"2"}`)
+	require.EqualError(t, err, `json: unknown field "titel"`)
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+}
+
+func TestTopicCIDs(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	for _, cid := range []string{
+		sampleCID,
+		"bafybeidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm",
+		"bafkreidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm",
+		"BAFKREIDLQ2ZHH7ZU7TQZ224AJ37VUP2XI6W2J2VCF4OUTQA6KLO3PB23JM",
+		"f01551220" + strings.Repeat("ab", 32),
+	} {
+		_, err := topic.CreateTopic(transactionContext, `{"title":"1","cid":"`+cid+`"}`)
+		require.NoError(t, err)
+	}
+
+	for cid, message := range map[string]string{
+		"QmVaPTddRyjLjMoZnYufWc5M5CjyGNPmFEpp5HtPKEqZF0":                "invalid cid: input isn't valid multihash",
+		"baguqeeranodle477gt6odhllqbhp6wr7k5d23jhkuixr2soadzjn3n4hlnfq": "codec 0x129 is not allowed",
+		"bafkrcfbvnimsw6itwbgfiv2nddbi2rxghfkcrky":                      "hash function 0x11 is not allowed",
+		"f01551210" + strings.Repeat("ab", 16):                          "the digest of hash function 0x12 must be 32 bytes long",
+		"f00551220" + strings.Repeat("ab", 32):                          "invalid cid: invalid cid: expected 1 as the cid version number, got: 0",
+		"ipfs://" + sampleCID:                                           "invalid cid: selected encoding not supported",
+	} {
+		_, err := topic.CreateTopic(transactionContext, `{"title":"1","cid":"`+cid+`"}`)
+		require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+		require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the cid is not a valid CID: "+message)
+	}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	verified, err := topic.VerifyContent(transactionContext, "1", "bafybeidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm")
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	verified, err = topic.VerifyContent(transactionContext, "1", sampleImage)
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	verified, err = topic.VerifyContent(transactionContext, "1", "bafkreidlq2zhh7zu7tqz224aj37vup2xi6w2j2vcf4outqa6klo3pb23jm")
+	require.NoError(t, err)
+	require.False(t, verified)
+
+	_, err = topic.VerifyContent(transactionContext, "1", "1")
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	_, err = topic.VerifyContent(transactionContext, "1", sampleCID)
+	require.EqualError(t, err, "the topic 1 does not exist")
+}
+
 func TestDeleteTopic(t *testing.T) {
-	deleteRequest := &chaincode.Delete{Hash: "1", Creator: "1"}
+	deleteRequest := &chaincode.Delete{Hash: "1", Creator: myOrg1Clientid}
 	deleteInput, _ := json.Marshal(deleteRequest)
 
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
@@ -96,7 +287,7 @@ func TestDeleteTopic(t *testing.T) {
 	err := topic.DeleteTopic(transactionContext, string(deleteInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	expectedTopic := &chaincode.Topic{Hash: "1", Creator: "1"}
+	expectedTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, err := json.Marshal(expectedTopic)
 	require.NoError(t, err)
 	chaincodeStub.GetStateReturns(bytes, nil)
@@ -112,9 +303,9 @@ func TestDeleteTopic(t *testing.T) {
 	require.NoError(t, err)
 	chaincodeStub.GetStateReturns(bytes, nil)
 	err = topic.DeleteTopic(transactionContext, string(deleteInput))
-	require.EqualError(t, err, "the topic 1 is not created by 1")
+	require.EqualError(t, err, "the topic 1 is not created by myOrg1Userid")
 
-	expectedTopic = &chaincode.Topic{Hash: "1", Creator: "1"}
+	expectedTopic = &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, err = json.Marshal(expectedTopic)
 	require.NoError(t, err)
 	chaincodeStub.GetStateReturns(bytes, nil)
@@ -122,10 +313,271 @@ func TestDeleteTopic(t *testing.T) {
 	err = topic.DeleteTopic(transactionContext, string(deleteInput))
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 
+	spoofedInput, _ := json.Marshal(&chaincode.Delete{Hash: "1", Creator: myOrg2Clientid})
+	err = topic.DeleteTopic(transactionContext, string(spoofedInput))
+	require.EqualError(t, err, "the creator myOrg2Userid does not match the submitting client myOrg1Userid")
+
 	err = topic.DeleteTopic(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 }
 
+func TestModerateTopic(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	topic := chaincode.SmartContract{}
+
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
+	bytes, _ := json.Marshal(tmpTopic)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	hideInput, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true, Reason: "spam"})
+	err := topic.HideTopic(transactionContext, string(hideInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to moderate topics")
+
+	lockInput, _ := json.Marshal(&chaincode.Lock{Hash: "1", Locked: true})
+	err = topic.LockTopic(transactionContext, string(lockInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to moderate topics")
+
+	chaincodeStub.InvokeChaincodeReturns(peer.Response{Status: shim.ERROR, Message: "the user myOrg2Userid does not exist"})
+	err = topic.HideTopic(transactionContext, string(hideInput))
+	require.EqualError(t, err, "failed to query userprofile: the user myOrg2Userid does not exist")
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "moderator"), "moderate")
+	noReason, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true})
+	err = topic.HideTopic(transactionContext, string(noReason))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the reason is required")
+
+	longReason, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true, Reason: strings.Repeat("a", 501)})
+	err = topic.HideTopic(transactionContext, string(longReason))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the reason is longer than 500 characters")
+
+	err = topic.HideTopic(transactionContext, string(hideInput))
+	require.NoError(t, err)
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.True(t, stored.Hidden)
+	require.Equal(t, "spam", stored.HiddenReason)
+	require.Equal(t, myOrg2Clientid, stored.HiddenBy)
+
+	err = topic.LockTopic(transactionContext, string(lockInput))
+	require.NoError(t, err)
+
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1"})
+	err = topic.DeleteTopic(transactionContext, string(deleteInput))
+	require.NoError(t, err)
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","creator":"`+myOrg1Clientid+`","title":"2"}`)
+	require.EqualError(t, err, "the creator myOrg1Userid does not match the submitting client myOrg2Userid")
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"2"}`)
+	require.NoError(t, err)
+
+	_, topicJSON = chaincodeStub.PutStateArgsForCall(3)
+	stored = &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.Equal(t, myOrg1Clientid, stored.Creator)
+	require.Equal(t, "2", stored.Title)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+		if name == "role" {
+			return "admin", true, nil
+		}
+		return "", false, nil
+	}
+	clientIdentity.GetIDReturns(base64.StdEncoding.EncodeToString([]byte(myOrg2Clientid)), nil)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	// userprofile grants the permissions of the role attribute of the submitting client
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid), "moderate")
+	err = topic.HideTopic(transactionContext, string(hideInput))
+	require.NoError(t, err)
+
+	err = topic.HideTopic(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	err = topic.LockTopic(transactionContext, string(lockInput))
+	require.EqualError(t, err, "the topic 1 does not exist")
+}
+
+func TestModerateTopicByPermission(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg2()
+	topic := chaincode.SmartContract{}
+
+	tmpTopic, _ := json.Marshal(&chaincode.Topic{Hash: "1", Creator: myOrg1Clientid})
+	chaincodeStub.GetStateReturns(tmpTopic, nil)
+	hideInput, _ := json.Marshal(&chaincode.Hide{Hash: "1", Hidden: true, Reason: "spam"})
+
+	// moderation follows the permissions userprofile defines for roles, whatever their names
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "warden"), "moderate")
+	err := topic.HideTopic(transactionContext, string(hideInput))
+	require.NoError(t, err)
+
+	name, args, _ := chaincodeStub.InvokeChaincodeArgsForCall(chaincodeStub.InvokeChaincodeCallCount() - 1)
+	require.Equal(t, "userprofile", name)
+	require.Equal(t, [][]byte{[]byte("HasPermission"), []byte(myOrg2Clientid), []byte("moderate")}, args)
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg2Clientid, "moderator"))
+	err = topic.HideTopic(transactionContext, string(hideInput))
+	require.EqualError(t, err, "the client myOrg2Userid is not permitted to moderate topics")
+}
+
+func TestRestoreTopic(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	restoreInput, _ := json.Marshal(&chaincode.Restore{Hash: "1"})
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	err := topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the topic 1 is not deleted")
+
+	deleted := *sampleTopic
+	deleted.Deleted, deleted.DeletedAt = true, 1000000
+	bytes, _ := json.Marshal(&deleted)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000 + 31*24*60*60}, nil)
+	err = topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the topic 1 was deleted too long ago to be restored")
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	err = topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.NoError(t, err)
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.False(t, stored.Deleted)
+	require.Zero(t, stored.DeletedAt)
+
+	// the restored topic is indexed again
+	key, _ := chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00creator~hash\x00myOrg1Userid\x001\x00", key)
+
+	// deletions recorded before DeletedAt was kept can be restored at any time
+	legacy := *sampleTopic
+	legacy.Deleted = true
+	bytes, _ = json.Marshal(&legacy)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000 + 31*24*60*60}, nil)
+	err = topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.NoError(t, err)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+
+	// a deletion by a moderator is only undone by moderators
+	deleted.DeletedBy = myOrg2Clientid
+	bytes, _ = json.Marshal(&deleted)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to moderate topics")
+
+	deleted.Creator = myOrg2Clientid
+	bytes, _ = json.Marshal(&deleted)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.EqualError(t, err, "the topic 1 is not created by myOrg1Userid")
+
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(myOrg1Clientid, "moderator"), "moderate")
+	err = topic.RestoreTopic(transactionContext, string(restoreInput))
+	require.NoError(t, err)
+
+	err = topic.RestoreTopic(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+}
+
+func TestPurgeTopic(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	err := topic.PurgeTopic(transactionContext, "1")
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to purge topics")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+	err = topic.PurgeTopic(transactionContext, "1")
+	require.EqualError(t, err, "the topic 1 does not exist")
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.NextReturns(&queryresult.KV{Key: "\x00vote~hash~wallet\x001\x00myOrg2Userid\x00"}, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturnsOnCall(0, iterator, nil)
+	err = topic.PurgeTopic(transactionContext, "1")
+	require.NoError(t, err)
+
+	var deleted []string
+	for i := 0; i < chaincodeStub.DelStateCallCount(); i++ {
+		deleted = append(deleted, chaincodeStub.DelStateArgsForCall(i))
+	}
+	require.Equal(t, []string{
+		"\x00creator~hash\x00myOrg1Userid\x001\x00",
+		"\x00title~hash\x001\x001\x00",
+		"\x00category~hash\x001\x001\x00",
+		"\x00tag~hash\x001\x001\x00",
+		"\x00vote~hash~wallet\x001\x00myOrg2Userid\x00",
+		"\x00topic~hash\x001\x00",
+	}, deleted)
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
+	err = topic.PurgeTopic(transactionContext, "1")
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+}
+
+func TestLockedTopic(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid, Locked: true}
+	bytes, _ := json.Marshal(tmpTopic)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	err := topic.UpdateTopic(transactionContext, samplePatch)
+	require.EqualError(t, err, "the topic 1 is locked")
+
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1"})
+	err = topic.DeleteTopic(transactionContext, string(deleteInput))
+	require.EqualError(t, err, "the topic 1 is locked")
+}
+
+func TestInactiveTopic(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	mutedUser, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "muted": true})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(mutedUser))
+	_, err := topic.CreateTopic(transactionContext, string(createInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+	require.EqualError(t, err, "ERR_USER_MUTED: the user myOrg1Userid is muted")
+
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
+	bytes, _ := json.Marshal(tmpTopic)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	err = topic.UpvoteTopic(transactionContext, string(upvoteInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+
+	err = topic.DownvoteTopic(transactionContext, string(upvoteInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+
+	err = topic.AddEmojiTopic(transactionContext, string(emojiInput))
+	require.ErrorIs(t, err, chaincode.ErrUserMuted)
+
+	bannedUser, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "muted": true, "banned": true})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(bannedUser))
+	err = topic.UpdateTopic(transactionContext, samplePatch)
+	require.ErrorIs(t, err, chaincode.ErrUserBanned)
+	require.EqualError(t, err, "ERR_USER_BANNED: the user myOrg1Userid is banned")
+
+	chaincodeStub.InvokeChaincodeReturns(shim.Success([]byte("sad")))
+	err = topic.UpvoteTopic(transactionContext, string(upvoteInput))
+	require.EqualError(t, err, "failed to decode user profile: invalid character 's' looking for beginning of value")
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	chaincodeStub.InvokeChaincodeReturns(peer.Response{Status: shim.ERROR, Message: "the user myOrg1Userid does not exist"})
+	_, err = topic.CreateTopic(transactionContext, string(createInput))
+	require.EqualError(t, err, "failed to query userprofile: the user myOrg1Userid does not exist")
+}
+
 func TestReadTopic(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
@@ -136,7 +588,7 @@ func TestReadTopic(t *testing.T) {
 	_, err := topic.ReadTopic(transactionContext, "1")
 	require.NoError(t, err)
 
-	err = topic.CreateTopic(transactionContext, "sad")
+	_, err = topic.CreateTopic(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
@@ -152,18 +604,18 @@ func TestUpdateTopic(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
 
-	err := topic.UpdateTopic(transactionContext, string(sampleInput))
+	err := topic.UpdateTopic(transactionContext, samplePatch)
 	require.EqualError(t, err, "the topic 1 does not exist")
 
 	chaincodeStub.GetStateReturns([]byte{}, fmt.Errorf("failure"))
-	err = topic.UpdateTopic(transactionContext, string(sampleInput))
+	err = topic.UpdateTopic(transactionContext, samplePatch)
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpTopic := &chaincode.Topic{Hash: "1", Creator: "1"}
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
-	err = topic.UpdateTopic(transactionContext, string(sampleInput))
+	err = topic.UpdateTopic(transactionContext, samplePatch)
 	require.NoError(t, err)
 
 	err = topic.UpdateTopic(transactionContext, "sad")
@@ -172,13 +624,262 @@ func TestUpdateTopic(t *testing.T) {
 	tmpTopic = &chaincode.Topic{Hash: "1", Creator: myOrg2Clientid}
 	bytes, _ = json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
+	err = topic.UpdateTopic(transactionContext, samplePatch)
+	require.EqualError(t, err, "the topic 1 is not created by myOrg1Userid")
+
+	tmpTopic = &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
+	bytes, _ = json.Marshal(tmpTopic)
+	chaincodeStub.GetStateReturns(bytes, nil)
 
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = topic.UpdateTopic(transactionContext, string(sampleInput))
+	err = topic.UpdateTopic(transactionContext, samplePatch)
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
-var upvoteInput, _ = json.Marshal(&chaincode.Upvote{Hash: "1", Creator: "1"})
+func TestRotatedWallet(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	// userprofile answers for a rotated wallet with the profile under its new wallet
+	chaincodeStub.InvokeChaincodeReturns(profileResponse("wallet2"))
+	_, err := topic.CreateTopic(transactionContext, string(createInput))
+	require.ErrorIs(t, err, chaincode.ErrWalletRotated)
+	require.EqualError(t, err, "ERR_WALLET_ROTATED: the wallet myOrg1Userid was rotated to wallet2")
+
+	// the new wallet keeps the topics of the wallets it was rotated from
+	rotated, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "previousWallets": []string{"wallet0"}})
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(shim.Success(rotated))
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: "wallet0"}
+	bytes, _ := json.Marshal(tmpTopic)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = topic.UpdateTopic(transactionContext, samplePatch)
+	require.NoError(t, err)
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.Equal(t, "wallet0", stored.Creator)
+
+	tmpTopic = &chaincode.Topic{Hash: "1", Creator: "wallet3"}
+	bytes, _ = json.Marshal(tmpTopic)
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = topic.UpdateTopic(transactionContext, samplePatch)
+	require.EqualError(t, err, "the topic 1 is not created by myOrg1Userid")
+}
+
+func TestUpdateTopicMergePatch(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	patch := `{"hash":"1","title":"2","tags":null,"images":[],"deleted":true,"locked":true,"upvotes":["2"],"createdAt":1}`
+	err := topic.UpdateTopic(transactionContext, patch)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field createdAt may not be changed")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"2","tags":null,"images":[]}`)
+	require.NoError(t, err)
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.Equal(t, "2", stored.Title)
+	require.Equal(t, sampleCID, stored.CID)
+	require.Equal(t, "1", stored.Category)
+	require.Nil(t, stored.Tags)
+	require.Equal(t, []string{}, stored.Images)
+	require.False(t, stored.Deleted)
+	require.False(t, stored.Locked)
+	require.Nil(t, stored.Upvotes)
+	require.Equal(t, myOrg1Clientid, stored.Creator)
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":2}`)
+	require.EqualError(t, err, "json: cannot unmarshal number into Go struct field .title of type string")
+}
+
+func TestTopicVersions(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	input, _ := json.Marshal(&chaincode.Topic{Title: "1", Version: 7})
+	_, err := topic.CreateTopic(transactionContext, string(input))
+	require.NoError(t, err)
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.Equal(t, uint64(1), stored.Version)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(topicJSON, nil)
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"2","expectedVersion":1,"version":5}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field version may not be changed")
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"2","expectedVersion":1}`)
+	require.NoError(t, err)
+
+	_, topicJSON = chaincodeStub.PutStateArgsForCall(0)
+	stored = &chaincode.Topic{}
+	json.Unmarshal(topicJSON, stored)
+	require.Equal(t, uint64(2), stored.Version)
+
+	chaincodeStub.GetStateReturns(topicJSON, nil)
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"3","expectedVersion":1}`)
+	require.ErrorIs(t, err, chaincode.ErrVersionConflict)
+	require.EqualError(t, err, "ERR_VERSION_CONFLICT: the topic 1 is at version 2, not 1")
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"3"}`)
+	require.NoError(t, err)
+
+	expected := uint64(1)
+	deleteInput, _ := json.Marshal(&chaincode.Delete{Hash: "1", Expectation: chaincode.Expectation{ExpectedVersion: &expected}})
+	err = topic.DeleteTopic(transactionContext, string(deleteInput))
+	require.ErrorIs(t, err, chaincode.ErrVersionConflict)
+
+	expected = 2
+	deleteInput, _ = json.Marshal(&chaincode.Delete{Hash: "1", Expectation: chaincode.Expectation{ExpectedVersion: &expected}})
+	err = topic.DeleteTopic(transactionContext, string(deleteInput))
+	require.NoError(t, err)
+}
+
+func TestTopicTimestamps(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	input, _ := json.Marshal(&chaincode.Topic{Title: "1", CreatedAt: 1, UpdatedAt: 1, LastActivityAt: 1})
+	_, err := topic.CreateTopic(transactionContext, string(input))
+	require.NoError(t, err)
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	var created chaincode.Topic
+	json.Unmarshal(topicJSON, &created)
+	require.Equal(t, int64(1000000), created.CreatedAt)
+	require.Equal(t, int64(1000000), created.UpdatedAt)
+	require.Equal(t, int64(1000000), created.LastActivityAt)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(topicJSON, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	input, _ = json.Marshal(&chaincode.Topic{Hash: "1", Title: "1", CreatedAt: 1, UpdatedAt: 1, LastActivityAt: 1})
+	err = topic.UpdateTopic(transactionContext, string(input))
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"1"}`)
+	require.NoError(t, err)
+
+	_, topicJSON = chaincodeStub.PutStateArgsForCall(0)
+	var updated chaincode.Topic
+	json.Unmarshal(topicJSON, &updated)
+	require.Equal(t, int64(1000000), updated.CreatedAt)
+	require.Equal(t, int64(2000000), updated.UpdatedAt)
+	require.Equal(t, int64(1000000), updated.LastActivityAt)
+
+	chaincodeStub.GetTxTimestampReturns(nil, fmt.Errorf("failure"))
+	err = topic.UpdateTopic(transactionContext, `{"hash":"1","title":"1"}`)
+	require.EqualError(t, err, "failed to read transaction timestamp: failure")
+}
+
+func TestRenewTopicUpdateTime(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	err := topic.RenewTopicUpdateTime(transactionContext, "1")
+	require.EqualError(t, err, "the topic 1 may only be renewed by the post chaincode")
+
+	chaincodeStub.GetSignedProposalReturns(proposalTo("topic"), nil)
+	err = topic.RenewTopicUpdateTime(transactionContext, "1")
+	require.EqualError(t, err, "the topic 1 may only be renewed by the post chaincode")
+
+	chaincodeStub.GetSignedProposalReturns(proposalTo("post"), nil)
+	err = topic.RenewTopicUpdateTime(transactionContext, "1")
+	require.EqualError(t, err, "the topic 1 does not exist")
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 3000}, nil)
+	err = topic.RenewTopicUpdateTime(transactionContext, "1")
+	require.NoError(t, err)
+	// the post chaincode checked the author, who need not be the submitting client
+	require.Zero(t, chaincodeStub.InvokeChaincodeCallCount())
+
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(0)
+	var renewed chaincode.Topic
+	json.Unmarshal(topicJSON, &renewed)
+	require.Equal(t, int64(3000000), renewed.LastActivityAt)
+	require.Zero(t, renewed.UpdatedAt)
+}
+
+func TestTopicIndexes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.CreateTopic(transactionContext, string(createInput))
+	require.NoError(t, err)
+
+	var written []string
+	for i := 0; i < chaincodeStub.PutStateCallCount(); i++ {
+		key, _ := chaincodeStub.PutStateArgsForCall(i)
+		written = append(written, key)
+	}
+	require.Equal(t, []string{
+		"\x00topic~hash\x001\x00",
+		"\x00creator~hash\x00myOrg1Userid\x001\x00",
+		"\x00title~hash\x001\x001\x00",
+		"\x00category~hash\x001\x001\x00",
+		"\x00tag~hash\x001\x001\x00",
+	}, written)
+
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	update := `{"hash":"1","title":"2","tags":["1","2"]}`
+	err = topic.UpdateTopic(transactionContext, update)
+	require.NoError(t, err)
+
+	written = nil
+	for i := 0; i < chaincodeStub.PutStateCallCount(); i++ {
+		key, _ := chaincodeStub.PutStateArgsForCall(i)
+		written = append(written, key)
+	}
+	require.Equal(t, []string{
+		"\x00topic~hash\x001\x00",
+		"\x00title~hash\x002\x001\x00",
+		"\x00tag~hash\x002\x001\x00",
+	}, written)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "\x00title~hash\x001\x001\x00", chaincodeStub.DelStateArgsForCall(0))
+
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
+	err = topic.UpdateTopic(transactionContext, update)
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.RebuildIndexes(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to rebuild indexes")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, false)
+	iterator.NextReturns(&queryresult.KV{Value: sampleInput}, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
+
+	indexed, err := topic.RebuildIndexes(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, indexed)
+	require.Equal(t, 4, chaincodeStub.PutStateCallCount())
+
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = topic.RebuildIndexes(transactionContext)
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+var upvoteInput, _ = json.Marshal(&chaincode.Upvote{Hash: "1", Creator: myOrg1Clientid})
 
 func TestUpvoteTopic(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
@@ -191,24 +892,33 @@ func TestUpvoteTopic(t *testing.T) {
 	err = topic.UpvoteTopic(transactionContext, string(upvoteInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpTopic := &chaincode.Topic{Hash: "1", Creator: "1"}
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	err = topic.UpvoteTopic(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
-	tmpTopic = &chaincode.Topic{Hash: "1", Creator: "1", Upvotes: []string{"1"}}
-	bytes, _ = json.Marshal(tmpTopic)
-	chaincodeStub.GetStateReturns(bytes, nil)
+	require.Equal(t, 1, chaincodeStub.PutStateCallCount())
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", key)
+	require.Equal(t, []byte("up"), value)
+
+	chaincodeStub.GetStateReturns([]byte("up"), nil)
 	err = topic.UpvoteTopic(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", chaincodeStub.DelStateArgsForCall(0))
 
-	tmpTopic = &chaincode.Topic{Hash: "1", Creator: "1", Downvotes: []string{"1"}}
-	bytes, _ = json.Marshal(tmpTopic)
-	chaincodeStub.GetStateReturns(bytes, nil)
+	chaincodeStub.GetStateReturns([]byte("down"), nil)
 	err = topic.UpvoteTopic(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
+	_, value = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, []byte("up"), value)
+
+	spoofedInput, _ := json.Marshal(&chaincode.Upvote{Hash: "1", Creator: myOrg2Clientid})
+	err = topic.UpvoteTopic(transactionContext, string(spoofedInput))
+	require.EqualError(t, err, "the creator myOrg2Userid does not match the submitting client myOrg1Userid")
 
 	err = topic.UpvoteTopic(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
@@ -233,20 +943,20 @@ func TestDownvoteTopic(t *testing.T) {
 	err = topic.DownvoteTopic(transactionContext, string(upvoteInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpTopic := &chaincode.Topic{Hash: "1", Creator: "1"}
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
 	err = topic.DownvoteTopic(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
-	tmpTopic = &chaincode.Topic{Hash: "1", Creator: "1", Downvotes: []string{"1"}}
+	tmpTopic = &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid, Downvotes: []string{"1"}}
 	bytes, _ = json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 	err = topic.DownvoteTopic(transactionContext, string(upvoteInput))
 	require.NoError(t, err)
 
-	tmpTopic = &chaincode.Topic{Hash: "1", Creator: "1", Upvotes: []string{"1"}}
+	tmpTopic = &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid, Upvotes: []string{"1"}}
 	bytes, _ = json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 	err = topic.DownvoteTopic(transactionContext, string(upvoteInput))
@@ -264,7 +974,7 @@ func TestDownvoteTopic(t *testing.T) {
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 }
 
-var emojiInput, _ = json.Marshal(&chaincode.Emoji{Hash: "1", Creator: "1", Code: "1"})
+var emojiInput, _ = json.Marshal(&chaincode.Emoji{Hash: "1", Creator: myOrg1Clientid, Code: "1"})
 
 func TestAddEmojiTopic(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
@@ -277,7 +987,7 @@ func TestAddEmojiTopic(t *testing.T) {
 	err = topic.AddEmojiTopic(transactionContext, string(emojiInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpTopic := &chaincode.Topic{Hash: "1", Creator: "1"}
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
@@ -287,6 +997,16 @@ func TestAddEmojiTopic(t *testing.T) {
 	err = topic.AddEmojiTopic(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
+	err = topic.AddEmojiTopic(transactionContext, `{"hash":"1"}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the code is required")
+
+	err = topic.AddEmojiTopic(transactionContext, `{"hash":"1","code":"`+strings.Repeat("a", 33)+`"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the code is longer than 32 characters")
+
+	err = topic.AddEmojiTopic(transactionContext, `{"hash":"1","code":"a\u0000b"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the code contains control characters")
+
 	tmpTopic = &chaincode.Topic{Hash: "1", Creator: myOrg2Clientid}
 	bytes, _ = json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
@@ -307,7 +1027,7 @@ func TestRemoveEmojiTopic(t *testing.T) {
 	err = topic.RemoveEmojiTopic(transactionContext, string(emojiInput))
 	require.EqualError(t, err, "failed to read from world state: failure")
 
-	tmpTopic := &chaincode.Topic{Hash: "1", Creator: "1"}
+	tmpTopic := &chaincode.Topic{Hash: "1", Creator: myOrg1Clientid}
 	bytes, _ := json.Marshal(tmpTopic)
 	chaincodeStub.GetStateReturns(bytes, nil)
 
@@ -317,20 +1037,95 @@ func TestRemoveEmojiTopic(t *testing.T) {
 	err = topic.RemoveEmojiTopic(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
-	tmpTopic = &chaincode.Topic{Hash: "1", Creator: myOrg2Clientid}
-	bytes, _ = json.Marshal(tmpTopic)
-	chaincodeStub.GetStateReturns(bytes, nil)
-
-	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
 	err = topic.RemoveEmojiTopic(transactionContext, string(emojiInput))
-	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+}
 
-	testRemoveEmojiTopic := &chaincode.Topic{Hash: "1", Creator: "1", Emojis: make(map[string][]string)}
-	testRemoveEmojiTopic.Emojis["1"] = []string{"1"}
-	bytes, _ = json.Marshal(testRemoveEmojiTopic)
-	chaincodeStub.GetStateReturns(bytes, nil)
-	err = topic.RemoveEmojiTopic(transactionContext, string(emojiInput))
-	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+func TestRotatedVotes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	// the profile of myOrg1Userid was rotated away from wallet0, which upvoted the topic
+	rotated, _ := json.Marshal(map[string]interface{}{"wallet": myOrg1Clientid, "previousWallets": []string{"wallet0"}})
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(shim.Success(rotated))
+	tmpTopic, _ := json.Marshal(&chaincode.Topic{Hash: "1", Creator: myOrg2Clientid})
+	chaincodeStub.GetStateStub = worldState(map[string][]byte{
+		"\x00topic~hash\x001\x00":                  tmpTopic,
+		"\x00vote~hash~wallet\x001\x00wallet0\x00": []byte("up"),
+	})
+
+	// upvoting again withdraws the vote of the old wallet instead of casting a second one
+	err := topic.UpvoteTopic(transactionContext, string(upvoteInput))
+	require.NoError(t, err)
+	require.Equal(t, 0, chaincodeStub.PutStateCallCount())
+	require.Equal(t, 2, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet0\x00", chaincodeStub.DelStateArgsForCall(0))
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", chaincodeStub.DelStateArgsForCall(1))
+
+	// downvoting moves the vote of the old wallet to the new one
+	err = topic.DownvoteTopic(transactionContext, string(upvoteInput))
+	require.NoError(t, err)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet0\x00", chaincodeStub.DelStateArgsForCall(2))
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00myOrg1Userid\x00", key)
+	require.Equal(t, []byte("down"), value)
+
+	// the reaction of the old wallet is dropped when the new wallet reacts alike
+	err = topic.AddEmojiTopic(transactionContext, string(emojiInput))
+	require.NoError(t, err)
+	require.Equal(t, "\x00emoji~hash~code~wallet\x001\x001\x00wallet0\x00", chaincodeStub.DelStateArgsForCall(3))
+	key, _ = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00emoji~hash~code~wallet\x001\x001\x00myOrg1Userid\x00", key)
+}
+
+func TestReadTopicVotes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		iterator := &mocks.StateQueryIterator{}
+		var votes []*queryresult.KV
+		switch objectType {
+		case "vote~hash~wallet":
+			votes = []*queryresult.KV{
+				{Key: "\x00vote~hash~wallet\x001\x00wallet1\x00", Value: []byte("up")},
+				{Key: "\x00vote~hash~wallet\x001\x00wallet2\x00", Value: []byte("down")},
+			}
+		case "emoji~hash~code~wallet":
+			votes = []*queryresult.KV{
+				{Key: "\x00emoji~hash~code~wallet\x001\x00smile\x00wallet1\x00"},
+			}
+		}
+		for i, vote := range votes {
+			iterator.HasNextReturnsOnCall(i, true)
+			iterator.NextReturnsOnCall(i, vote, nil)
+		}
+		return iterator, nil
+	}
+	chaincodeStub.SplitCompositeKeyStub = func(key string) (string, []string, error) {
+		parts := strings.Split(strings.Trim(key, "\x00"), "\x00")
+		return parts[0], parts[1:], nil
+	}
+
+	read, err := topic.ReadTopic(transactionContext, "1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"wallet1"}, read.Upvotes)
+	require.Equal(t, []string{"wallet2"}, read.Downvotes)
+	require.Equal(t, map[string][]string{"smile": {"wallet1"}}, read.Emojis)
+
+	chaincodeStub.GetStateByPartialCompositeKeyStub = nil
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving votes"))
+	_, err = topic.ReadTopic(transactionContext, "1")
+	require.EqualError(t, err, "failed retrieving votes")
+
+	// the bare state is read without touching the vote keys
+	calls := chaincodeStub.GetStateByPartialCompositeKeyCallCount()
+	read, err = topic.ReadTopicState(transactionContext, "1")
+	require.NoError(t, err)
+	require.Equal(t, "1", read.Hash)
+	require.Equal(t, calls, chaincodeStub.GetStateByPartialCompositeKeyCallCount())
 }
 
 func TestGetAllTopics(t *testing.T) {
@@ -347,37 +1142,192 @@ func TestGetAllTopics(t *testing.T) {
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	userprofile := &chaincode.SmartContract{}
 	assets, err := userprofile.GetAllTopics(transactionContext)
 	require.NoError(t, err)
 	require.Equal(t, []*chaincode.Topic{asset}, assets)
 
+	hidden, _ := json.Marshal(&chaincode.Topic{Hash: "user2", Hidden: true})
+	iterator.HasNextReturnsOnCall(2, true)
+	iterator.HasNextReturnsOnCall(3, false)
+	iterator.NextReturns(&queryresult.KV{Value: hidden}, nil)
+	assets, err = userprofile.GetAllTopics(transactionContext)
+	require.NoError(t, err)
+	require.Empty(t, assets)
+
 	iterator.HasNextReturns(true)
 	iterator.NextReturns(nil, fmt.Errorf("failed retrieving next item"))
 	assets, err = userprofile.GetAllTopics(transactionContext)
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, assets)
 
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
 	assets, err = userprofile.GetAllTopics(transactionContext)
 	require.EqualError(t, err, "failed retrieving all assets")
 	require.Nil(t, assets)
 }
 
+func TestGetAllTopicsWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := &chaincode.SmartContract{}
+
+	asset := &chaincode.Topic{Hash: "user1"}
+	bytes, _ := json.Marshal(asset)
+	hidden, _ := json.Marshal(&chaincode.Topic{Hash: "user2", Hidden: true})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Value: bytes}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Value: hidden}, nil)
+	// the hidden record is left out of the page and its count
+	metadata := &peer.QueryResponseMetadata{FetchedRecordsCount: 2, Bookmark: "next"}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(iterator, metadata, nil)
+
+	page, err := topic.GetAllTopicsWithPagination(transactionContext, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.TopicPage{Records: []*chaincode.Topic{asset}, FetchedRecordsCount: 1, Bookmark: "next"}, page)
+
+	objectType, _, pageSize, bookmark := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, "topic~hash", objectType)
+	require.Equal(t, int32(1), pageSize)
+	require.Equal(t, "", bookmark)
+
+	_, err = topic.GetAllTopicsWithPagination(transactionContext, 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = topic.GetAllTopicsWithPagination(transactionContext, 1, "next")
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestQueryTopicsWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := &chaincode.SmartContract{}
+
+	iterator := &mocks.StateQueryIterator{}
+	metadata := &peer.QueryResponseMetadata{Bookmark: "next"}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(iterator, metadata, nil)
+
+	page, err := topic.QueryTopicsByCreatorWithPagination(transactionContext, "1", 10, "")
+	require.NoError(t, err)
+	require.Equal(t, "next", page.Bookmark)
+
+	index, values, pageSize, _ := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, "creator~hash", index)
+	require.Equal(t, []string{"1"}, values)
+	require.Equal(t, int32(10), pageSize)
+
+	_, err = topic.QueryTopicsByTagWithPagination(transactionContext, "1", -1, "")
+	require.EqualError(t, err, "the page size -1 is not positive")
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("failure"))
+	_, err = topic.QueryTopicsByTitleWithPagination(transactionContext, "1", 10, "")
+	require.EqualError(t, err, "failure")
+	_, err = topic.QueryTopicsByCategoryWithPagination(transactionContext, 1, 10, "next")
+	require.EqualError(t, err, "failure")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to migrate keys")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	legacy, _ := json.Marshal(&chaincode.Topic{Hash: "1"})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Key: "1", Value: legacy}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Key: "2", Value: []byte("sad")}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+
+	migrated, err := topic.MigrateKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00topic~hash\x001\x00", key)
+	require.Equal(t, legacy, value)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "1", chaincodeStub.DelStateArgsForCall(0))
+
+	iterator = &mocks.StateQueryIterator{}
+	iterator.HasNextReturns(true)
+	iterator.NextReturns(&queryresult.KV{Key: "1", Value: legacy}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.DelStateReturns(fmt.Errorf("failed deleting key"))
+	_, err = topic.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed to delete from world state: failed deleting key")
+
+	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	_, err = topic.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+
+	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = topic.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestMigrateVotes(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	_, err := topic.MigrateVotes(transactionContext)
+	require.EqualError(t, err, "the client myOrg1Userid is not permitted to migrate votes")
+
+	chaincodeStub.InvokeChaincodeReturns(profileResponse(myOrg1Clientid, "admin"))
+
+	voted, _ := json.Marshal(&chaincode.Topic{Hash: "1", Upvotes: []string{"wallet1"}, Downvotes: []string{"wallet2"}})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Value: voted}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Value: sampleInput}, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
+
+	migrated, err := topic.MigrateVotes(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet1\x00", key)
+	require.Equal(t, []byte("up"), value)
+	key, value = chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet2\x00", key)
+	require.Equal(t, []byte("down"), value)
+	key, value = chaincodeStub.PutStateArgsForCall(2)
+	require.Equal(t, "\x00topic~hash\x001\x00", key)
+	stored := &chaincode.Topic{}
+	json.Unmarshal(value, stored)
+	require.Nil(t, stored.Upvotes)
+	require.Nil(t, stored.Downvotes)
+}
+
 func TestQueryTopicsByTitle(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := topic.QueryTopicsByTitle(transactionContext, "1")
 	require.EqualError(t, err, "failure")
+
+	index, values := chaincodeStub.GetStateByPartialCompositeKeyArgsForCall(0)
+	require.Equal(t, "title~hash", index)
+	require.Equal(t, []string{"1"}, values)
 }
+
 func TestQueryTopicsByCreator(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := topic.QueryTopicsByCreator(transactionContext, "1")
 	require.EqualError(t, err, "failure")
 
@@ -387,17 +1337,18 @@ func TestQueryTopicsByCreator(t *testing.T) {
 	iterator := &mocks.StateQueryIterator{}
 	iterator.HasNextReturnsOnCall(0, true)
 	iterator.HasNextReturnsOnCall(1, false)
-	iterator.NextReturns(&queryresult.KV{Value: bytes}, nil)
+	iterator.NextReturns(&queryresult.KV{Key: "\x00creator~hash\x001\x00user1\x00"}, nil)
+	chaincodeStub.SplitCompositeKeyReturns("creator~hash", []string{"1", "user1"}, nil)
+	chaincodeStub.GetStateReturns(bytes, nil)
 
-	chaincodeStub.GetQueryResultReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	topics, err := topic.QueryTopicsByCreator(transactionContext, "1")
 	require.NoError(t, err)
 	require.Equal(t, []*chaincode.Topic{tmpTopic}, topics)
+	require.Equal(t, "\x00topic~hash\x00user1\x00", chaincodeStub.GetStateArgsForCall(0))
 
 	iterator.NextReturns(nil, fmt.Errorf("failed retrieving next item"))
-	iterator.HasNextReturnsOnCall(2, true)
-	iterator.HasNextReturnsOnCall(3, true)
-	chaincodeStub.GetQueryResultReturns(iterator, nil)
+	iterator.HasNextReturns(true)
 	topics, err = topic.QueryTopicsByCreator(transactionContext, "1")
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, topics)
@@ -407,7 +1358,7 @@ func TestQueryTopicsByCategory(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := topic.QueryTopicsByCategory(transactionContext, 1)
 	require.EqualError(t, err, "failure")
 }
@@ -416,9 +1367,287 @@ func TestQueryTopicsByTag(t *testing.T) {
 	transactionContext, chaincodeStub := prepMocksAsOrg1()
 	topic := chaincode.SmartContract{}
 
-	chaincodeStub.GetQueryResultReturns(nil, fmt.Errorf("failure"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failure"))
 	_, err := topic.QueryTopicsByTag(transactionContext, "1")
 	require.EqualError(t, err, "failure")
+
+	index, values := chaincodeStub.GetStateByPartialCompositeKeyArgsForCall(0)
+	require.Equal(t, "tag~hash", index)
+	require.Equal(t, []string{"1"}, values)
+}
+
+func TestQueryTopics(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	chaincodeStub.GetQueryResultReturns(&mocks.StateQueryIterator{}, nil)
+	filter, _ := json.Marshal(&chaincode.TopicFilter{Creator: "1", Category: "2", Tags: []string{"3"}, SortBy: "title", Descending: true, Limit: 10})
+	_, err := topic.QueryTopics(transactionContext, string(filter))
+	require.NoError(t, err)
+
+	queryString := chaincodeStub.GetQueryResultArgsForCall(0)
+	require.JSONEq(t, `{
+		"selector": {"hash": {"$exists": true}, "creator": "1", "category": "2", "tags": {"$all": ["3"]}, "deleted": false, "hidden": false, "title": {"$gt": null}},
+		"sort": [{"title": "desc"}],
+		"limit": 10,
+		"use_index": ["_design/indexTitleDoc", "indexTitle"]
+	}`, queryString)
+
+	filter, _ = json.Marshal(&chaincode.TopicFilter{Title: "1", IncludeDeleted: true, IncludeHidden: true, SortBy: "title"})
+	_, err = topic.QueryTopics(transactionContext, string(filter))
+	require.NoError(t, err)
+
+	queryString = chaincodeStub.GetQueryResultArgsForCall(1)
+	require.JSONEq(t, `{
+		"selector": {"hash": {"$exists": true}, "title": "1"},
+		"sort": [{"title": "asc"}],
+		"use_index": ["_design/indexTitleDoc", "indexTitle"]
+	}`, queryString)
+
+	_, err = topic.QueryTopics(transactionContext, `{"sortBy":"lastActivityAt","descending":true}`)
+	require.NoError(t, err)
+
+	queryString = chaincodeStub.GetQueryResultArgsForCall(2)
+	require.JSONEq(t, `{
+		"selector": {"hash": {"$exists": true}, "deleted": false, "hidden": false, "lastActivityAt": {"$gt": null}},
+		"sort": [{"lastActivityAt": "desc"}],
+		"use_index": ["_design/indexLastActivityAtDoc", "indexLastActivityAt"]
+	}`, queryString)
+
+	_, err = topic.QueryTopics(transactionContext, `{"sortBy":"cid"}`)
+	require.EqualError(t, err, "the topics cannot be sorted by cid")
+
+	// including every topic still leaves out the documents that are not topics
+	_, err = topic.QueryTopics(transactionContext, `{"includeDeleted":true,"includeHidden":true}`)
+	require.NoError(t, err)
+	queryString = chaincodeStub.GetQueryResultArgsForCall(chaincodeStub.GetQueryResultCallCount() - 1)
+	require.Equal(t, `{"selector":{"hash":{"$exists":true}}}`, queryString)
+
+	_, err = topic.QueryTopics(transactionContext, `{"limit":-1}`)
+	require.EqualError(t, err, "the limit -1 is negative")
+
+	_, err = topic.QueryTopics(transactionContext, "sad")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+
+	chaincodeStub.GetQueryResultWithPaginationReturns(&mocks.StateQueryIterator{}, &peer.QueryResponseMetadata{}, nil)
+	_, err = topic.QueryTopicsWithPagination(transactionContext, `{"creator":"1","limit":5}`, 10, "")
+	require.NoError(t, err)
+
+	queryString, _, _ = chaincodeStub.GetQueryResultWithPaginationArgsForCall(0)
+	require.Equal(t, `{"selector":{"creator":"1","deleted":false,"hash":{"$exists":true},"hidden":false}}`, queryString)
+
+	_, err = topic.QueryTopicsWithPagination(transactionContext, "sad", 10, "")
+	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
+}
+
+func TestGetTopicHistory(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	modifications := []*queryresult.KeyModification{
+		{TxId: "tx3", IsDelete: true, Timestamp: &timestamppb.Timestamp{Seconds: 3}},
+		{TxId: "tx2", Value: sampleInput, Timestamp: &timestamppb.Timestamp{Seconds: 2}},
+		{TxId: "tx1", Value: sampleInput, Timestamp: &timestamppb.Timestamp{Seconds: 1}},
+	}
+	chaincodeStub.GetHistoryForKeyCalls(func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		return historyIterator(modifications), nil
+	})
+
+	page, err := topic.GetTopicHistory(transactionContext, "1", 2, "")
+	require.NoError(t, err)
+	require.Equal(t, "\x00topic~hash\x001\x00", chaincodeStub.GetHistoryForKeyArgsForCall(0))
+	require.Equal(t, int32(2), page.FetchedRecordsCount)
+	require.Equal(t, "tx2", page.Bookmark)
+	require.True(t, page.Records[0].IsDelete)
+	require.Nil(t, page.Records[0].Record)
+	require.Equal(t, int64(3000), page.Records[0].Timestamp)
+	require.Equal(t, "1", page.Records[1].Record.Title)
+
+	page, err = topic.GetTopicHistory(transactionContext, "1", 2, "tx2")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "tx1", page.Records[0].TxID)
+	require.Equal(t, "", page.Bookmark)
+
+	_, err = topic.GetTopicHistory(transactionContext, "1", 2, "tx4")
+	require.EqualError(t, err, "the bookmark tx4 is not a revision of the topic 1")
+
+	_, err = topic.GetTopicHistory(transactionContext, "1", 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	chaincodeStub.GetHistoryForKeyReturns(nil, fmt.Errorf("history disabled"))
+	chaincodeStub.GetHistoryForKeyStub = nil
+	_, err = topic.GetTopicHistory(transactionContext, "1", 2, "")
+	require.EqualError(t, err, "failed to read history: history disabled")
+}
+
+// historyIterator returns an iterator over the modifications.
+func historyIterator(modifications []*queryresult.KeyModification) *mocks.HistoryQueryIterator {
+	iterator := &mocks.HistoryQueryIterator{}
+	next := 0
+	iterator.HasNextCalls(func() bool {
+		return next < len(modifications)
+	})
+	iterator.NextCalls(func() (*queryresult.KeyModification, error) {
+		next++
+		return modifications[next-1], nil
+	})
+	return iterator
+}
+
+// signerKey is the ed25519 key of the wallet signing envelopes in the tests.
+var signerKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+// signEnvelope returns an envelope of the payload signed by signerKey for the wallet.
+func signEnvelope(wallet, function, payload, nonce string) string {
+	message := strings.Join([]string{"", "topic", function, wallet, "", nonce, payload}, "\x00")
+	envelope, _ := json.Marshal(&chaincode.Envelope{
+		Wallet:    wallet,
+		Function:  function,
+		Payload:   payload,
+		Nonce:     nonce,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signerKey, []byte(message))),
+	})
+	return string(envelope)
+}
+
+func signerResponse(wallet, publicKey string) peer.Response {
+	user, _ := json.Marshal(map[string]interface{}{"wallet": wallet, "publicKey": publicKey})
+	return shim.Success(user)
+}
+
+func TestSubmitSigned(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	signerPublicKey := "ed25519:" + base64.StdEncoding.EncodeToString(signerKey.Public().(ed25519.PublicKey))
+	chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet9", signerPublicKey))
+	hash, err := topic.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreateTopic", `{"title":"signed"}`, "n1"))
+	require.NoError(t, err)
+	require.Equal(t, "1", hash)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00nonce~wallet~nonce\x00wallet9\x00n1\x00", key)
+	require.Equal(t, "1", string(value))
+	_, topicJSON := chaincodeStub.PutStateArgsForCall(1)
+	created := &chaincode.Topic{}
+	json.Unmarshal(topicJSON, created)
+	require.Equal(t, "wallet9", created.Creator)
+
+	// the envelope acts for its wallet, not the submitting client
+	_, err = topic.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreateTopic", `{"title":"signed","creator":"myOrg1Userid"}`, "n1"))
+	require.EqualError(t, err, "the creator myOrg1Userid does not match the submitting client wallet9")
+
+	_, err = topic.SubmitSigned(transactionContext, signEnvelope("wallet9", "PurgeTopic", "1", "n1"))
+	require.EqualError(t, err, "the transaction PurgeTopic cannot be submitted signed")
+
+	tampered := strings.Replace(signEnvelope("wallet9", "CreateTopic", `{"title":"signed"}`, "n1"), "signed", "forged", 1)
+	_, err = topic.SubmitSigned(transactionContext, tampered)
+	require.ErrorIs(t, err, chaincode.ErrInvalidSignature)
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet9")
+
+	chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet8", signerPublicKey))
+	misattributed := strings.Replace(signEnvelope("wallet9", "CreateTopic", `{"title":"signed"}`, "n1"), "wallet9", "wallet8", 1)
+	_, err = topic.SubmitSigned(transactionContext, misattributed)
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet8")
+
+	chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet9", ""))
+	_, err = topic.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreateTopic", `{"title":"signed"}`, "n1"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the wallet wallet9 has no public key registered")
+
+	chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet9", signerPublicKey))
+	chaincodeStub.GetStateReturns([]byte("0"), nil)
+	_, err = topic.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreateTopic", `{"title":"signed"}`, "n1"))
+	require.ErrorIs(t, err, chaincode.ErrNonceReused)
+	require.EqualError(t, err, "ERR_NONCE_REUSED: the nonce n1 of the wallet wallet9 was already used")
+
+	_, err = topic.SubmitSigned(transactionContext, signEnvelope("wallet9", "CreateTopic", `{"title":"signed"}`, ""))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the nonce is required")
+
+	// the secp256k1 signature was made with a separate implementation of ECDSA
+	transactionContext, chaincodeStub = prepMocksAsOrg1()
+	envelope, _ := json.Marshal(&chaincode.Envelope{
+		Wallet:    "wallet9",
+		Function:  "CreateTopic",
+		Payload:   `{"title":"signed"}`,
+		Nonce:     "n2",
+		Signature: "unagOQQSTORSIn0WSCcQpgpk2ZrV//83vVxHWLWyFyUogrBodHDf8NJF69qnx78VqferFJ5uYqcgAbRLeNwDYw==",
+	})
+	for _, publicKey := range []string{
+		"secp256k1:AuFix0oaqG+LZSg3J+o1RRN15eibMtiIIWEugJz2R50T",
+		"secp256k1:BOFix0oaqG+LZSg3J+o1RRN15eibMtiIIWEugJz2R50THa+chqJmP3edKv+7Ktg6JtyWHH10MNk7Qo0X/eNezaQ=",
+	} {
+		chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet9", publicKey))
+		_, err = topic.SubmitSigned(transactionContext, string(envelope))
+		require.NoError(t, err)
+	}
+
+	chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet9", "secp256k1:A+Fix0oaqG+LZSg3J+o1RRN15eibMtiIIWEugJz2R50T"))
+	_, err = topic.SubmitSigned(transactionContext, string(envelope))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet9")
+
+	chaincodeStub.InvokeChaincodeReturns(signerResponse("wallet9", "rsa:AAAA"))
+	_, err = topic.SubmitSigned(transactionContext, string(envelope))
+	require.EqualError(t, err, `ERR_INVALID_SIGNATURE: the public key of the wallet wallet9 is invalid: unsupported signature scheme "rsa"`)
+}
+
+func TestSubmitDelegated(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksAsOrg1()
+	topic := chaincode.SmartContract{}
+
+	sessionKey := ed25519.NewKeyFromSeed([]byte(strings.Repeat("s", ed25519.SeedSize)))
+	sign := func(function, payload, nonce string) string {
+		message := strings.Join([]string{"", "topic", function, "wallet9", "phone", nonce, payload}, "\x00")
+		envelope, _ := json.Marshal(&chaincode.Envelope{
+			Wallet:    "wallet9",
+			Function:  function,
+			Payload:   payload,
+			Nonce:     nonce,
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(sessionKey, []byte(message))),
+			Delegate:  "phone",
+		})
+		return string(envelope)
+	}
+	signer, _ := json.Marshal(map[string]interface{}{
+		"wallet": "wallet9",
+		"delegates": []map[string]interface{}{{
+			"id":        "phone",
+			"publicKey": "ed25519:" + base64.StdEncoding.EncodeToString(sessionKey.Public().(ed25519.PublicKey)),
+			"scopes":    []string{"vote"},
+			"expiresAt": 2000000,
+		}, {
+			"id":        "tablet",
+			"publicKey": "ed25519:" + base64.StdEncoding.EncodeToString(sessionKey.Public().(ed25519.PublicKey)),
+			"scopes":    []string{"write"},
+			"expiresAt": 2000000,
+		}},
+	})
+	chaincodeStub.InvokeChaincodeReturns(shim.Success(signer))
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if key == "\x00topic~hash\x001\x00" {
+			return sampleInput, nil
+		}
+		return nil, nil
+	}
+
+	_, err := topic.SubmitSigned(transactionContext, sign("UpvoteTopic", `{"hash":"1"}`, "n1"))
+	require.NoError(t, err)
+	key, _ := chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00vote~hash~wallet\x001\x00wallet9\x00", key)
+
+	_, err = topic.SubmitSigned(transactionContext, sign("CreateTopic", `{"title":"signed"}`, "n2"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the delegate phone of the wallet wallet9 may not sign CreateTopic")
+
+	// the delegate is signed, so relaying the envelope under another delegate of the
+	// same key does not lend it the scopes of that delegate
+	relabeled := strings.Replace(sign("CreateTopic", `{"title":"signed"}`, "n2"), `"delegate":"phone"`, `"delegate":"tablet"`, 1)
+	_, err = topic.SubmitSigned(transactionContext, relabeled)
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet9")
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	_, err = topic.SubmitSigned(transactionContext, sign("UpvoteTopic", `{"hash":"1"}`, "n2"))
+	require.ErrorIs(t, err, chaincode.ErrDelegateExpired)
 }
 
 func prepMocksAsOrg1() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
@@ -438,9 +1667,48 @@ func prepMocks(orgMSP, clientId string) (*mocks.TransactionContext, *mocks.Chain
 	// set matching msp ID using peer shim env variable
 	os.Setenv("CORE_PEER_LOCALMSPID", orgMSP)
 	transactionContext.GetClientIdentityReturns(clientIdentity)
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.GetTxIDReturns("1")
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(&mocks.StateQueryIterator{}, nil)
+	chaincodeStub.InvokeChaincodeStub = userprofileStub(profileResponse(clientId))
 	return transactionContext, chaincodeStub
 }
 
+// proposalTo returns a signed proposal of a transaction submitted to the chaincode.
+func proposalTo(chaincodeName string) *peer.SignedProposal {
+	input, _ := proto.Marshal(&peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{ChaincodeId: &peer.ChaincodeID{Name: chaincodeName}}})
+	payload, _ := proto.Marshal(&peer.ChaincodeProposalPayload{Input: input})
+	proposal, _ := proto.Marshal(&peer.Proposal{Payload: payload})
+	return &peer.SignedProposal{ProposalBytes: proposal}
+}
+
+func worldState(states map[string][]byte) func(string) ([]byte, error) {
+	return func(key string) ([]byte, error) {
+		return states[key], nil
+	}
+}
+
+// userprofileStub answers the invocations of userprofile with the user, and whether the
+// user holds a permission with the permissions given.
+func userprofileStub(user peer.Response, permissions ...string) func(string, [][]byte, string) peer.Response {
+	return func(name string, args [][]byte, channel string) peer.Response {
+		if string(args[0]) != "HasPermission" {
+			return user
+		}
+		for _, permission := range permissions {
+			if permission == string(args[2]) {
+				return shim.Success([]byte("true"))
+			}
+		}
+		return shim.Success([]byte("false"))
+	}
+}
+
+func profileResponse(wallet string, roles ...string) peer.Response {
+	user, _ := json.Marshal(map[string]interface{}{"wallet": wallet, "rolesAssigned": roles})
+	return shim.Success(user)
+}
+
 func prepMocksIllegalId() (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
@@ -0,0 +1,35 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// getTxTime returns the time the transaction was proposed in milliseconds since the
+// epoch. Every endorser reads the same time, unlike the clock of the peer.
+func getTxTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime().UnixMilli(), nil
+}
+
+// touchTopic marks the topic as changed by the transaction, moving it to its next version
+// and setting the time it was updated, along with the time it was created and last
+// active at when it is new.
+func touchTopic(ctx contractapi.TransactionContextInterface, topic *Topic) error {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if topic.CreatedAt == 0 {
+		topic.CreatedAt = now
+		topic.LastActivityAt = now
+	}
+	topic.UpdatedAt = now
+	topic.Version++
+	return nil
+}
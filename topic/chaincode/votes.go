@@ -0,0 +1,265 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Votes and reactions are stored under keys of their own rather than in the topic, so
+// concurrent voters never write the same key and commit in the same block. The tallies
+// of a topic are collected from these keys whenever it is returned to a client.
+const (
+	// voteIndex keys hold the direction of the vote a wallet cast on a topic.
+	voteIndex = "vote~hash~wallet"
+	// emojiIndex keys mark a wallet reacting to a topic with an emoji.
+	emojiIndex = "emoji~hash~code~wallet"
+
+	voteUp   = "up"
+	voteDown = "down"
+)
+
+// toggleVote casts the vote of the profile on the topic in the direction, withdrawing it
+// instead when the profile already voted that way. A vote cast by a wallet the profile
+// was rotated away from counts as the vote of the profile and moves to its current
+// wallet, so that rotating a wallet never lets its owner vote twice.
+func toggleVote(ctx contractapi.TransactionContextInterface, hash string, user *profile, direction string) error {
+	current, err := readVote(ctx, hash, user.Wallet)
+	if err != nil {
+		return err
+	}
+
+	for _, previous := range user.PreviousWallets {
+		vote, err := readVote(ctx, hash, previous)
+		if err != nil {
+			return err
+		}
+		if vote == "" {
+			continue
+		}
+		if current == "" {
+			current = vote
+		}
+		err = putVote(ctx, hash, previous, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if current == direction {
+		return putVote(ctx, hash, user.Wallet, "")
+	}
+	return putVote(ctx, hash, user.Wallet, direction)
+}
+
+// readVote returns the direction of the vote of the wallet on the topic, or "" when it
+// did not vote.
+func readVote(ctx contractapi.TransactionContextInterface, hash string, wallet string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(voteIndex, []string{hash, wallet})
+	if err != nil {
+		return "", err
+	}
+
+	vote, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return string(vote), nil
+}
+
+// putVote records the vote of the wallet on the topic, or removes it when direction is empty.
+func putVote(ctx contractapi.TransactionContextInterface, hash string, wallet string, direction string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(voteIndex, []string{hash, wallet})
+	if err != nil {
+		return err
+	}
+
+	if direction == "" {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		return nil
+	}
+
+	err = ctx.GetStub().PutState(key, []byte(direction))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// putEmoji records the reaction of the wallet to the topic, or removes it when react is false.
+func putEmoji(ctx contractapi.TransactionContextInterface, hash string, code string, wallet string, react bool) error {
+	// the code is part of the key, so it is checked like the fields of a payload
+	err := checkText("code", code, maxCodeLength, true)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(emojiIndex, []string{hash, code, wallet})
+	if err != nil {
+		return err
+	}
+
+	if !react {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		return nil
+	}
+
+	err = ctx.GetStub().PutState(key, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// reactEmoji records the reaction of the profile to the topic, or removes it when react
+// is false. Reactions of the wallets the profile was rotated away from are removed, so
+// that the reaction is held by its current wallet alone.
+func reactEmoji(ctx contractapi.TransactionContextInterface, hash string, code string, user *profile, react bool) error {
+	for _, previous := range user.PreviousWallets {
+		err := putEmoji(ctx, hash, code, previous, false)
+		if err != nil {
+			return err
+		}
+	}
+	return putEmoji(ctx, hash, code, user.Wallet, react)
+}
+
+// fillVotes sets the upvotes, downvotes and emojis of the topics from their vote keys.
+func fillVotes(ctx contractapi.TransactionContextInterface, topics ...*Topic) error {
+	for _, topic := range topics {
+		err := fillTopicVotes(ctx, topic)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fillTopicVotes(ctx contractapi.TransactionContextInterface, topic *Topic) error {
+	topic.Upvotes, topic.Downvotes, topic.Emojis = nil, nil, nil
+
+	votesIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(voteIndex, []string{topic.Hash})
+	if err != nil {
+		return err
+	}
+	defer votesIterator.Close()
+
+	for votesIterator.HasNext() {
+		queryResponse, err := votesIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(attributes) != 2 {
+			return fmt.Errorf("the vote key %s is malformed", queryResponse.Key)
+		}
+
+		switch string(queryResponse.Value) {
+		case voteUp:
+			topic.Upvotes = append(topic.Upvotes, attributes[1])
+		case voteDown:
+			topic.Downvotes = append(topic.Downvotes, attributes[1])
+		}
+	}
+
+	emojisIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(emojiIndex, []string{topic.Hash})
+	if err != nil {
+		return err
+	}
+	defer emojisIterator.Close()
+
+	for emojisIterator.HasNext() {
+		queryResponse, err := emojisIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(attributes) != 3 {
+			return fmt.Errorf("the emoji key %s is malformed", queryResponse.Key)
+		}
+
+		if topic.Emojis == nil {
+			topic.Emojis = make(map[string][]string)
+		}
+		topic.Emojis[attributes[1]] = append(topic.Emojis[attributes[1]], attributes[2])
+	}
+
+	return nil
+}
+
+// explodeVotes moves the votes and emojis stored inside a topic, as written before vote
+// keys were introduced, to vote keys. The topic must be written afterwards to drop them.
+func explodeVotes(ctx contractapi.TransactionContextInterface, topic *Topic) error {
+	for _, wallet := range topic.Upvotes {
+		err := putVote(ctx, topic.Hash, wallet, voteUp)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, wallet := range topic.Downvotes {
+		if contains(topic.Upvotes, wallet) {
+			continue
+		}
+		err := putVote(ctx, topic.Hash, wallet, voteDown)
+		if err != nil {
+			return err
+		}
+	}
+
+	for code, wallets := range topic.Emojis {
+		for _, wallet := range wallets {
+			err := putEmoji(ctx, topic.Hash, code, wallet, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteVotes removes every vote and emoji cast on the topic.
+func deleteVotes(ctx contractapi.TransactionContextInterface, hash string) error {
+	err := deleteKeys(ctx, voteIndex, hash)
+	if err != nil {
+		return err
+	}
+	return deleteKeys(ctx, emojiIndex, hash)
+}
+
+// deleteKeys removes every key in the index starting with the hash.
+func deleteKeys(ctx contractapi.TransactionContextInterface, index string, hash string) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{hash})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from world state: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Scopes a delegate may be granted, each covering a group of transactions of the
+// topic, post and userprofile chaincodes.
+const (
+	// scopeWrite covers creating, updating, deleting and restoring topics and posts.
+	scopeWrite = "write"
+	// scopeVote covers upvotes and downvotes.
+	scopeVote = "vote"
+	// scopeReact covers adding and removing emojis.
+	scopeReact = "react"
+	// scopeModerate covers hiding and locking topics and posts.
+	scopeModerate = "moderate"
+	// scopeProfile covers updating the profile, except for its public key and guardians.
+	scopeProfile = "profile"
+)
+
+var delegateScopes = []string{scopeWrite, scopeVote, scopeReact, scopeModerate, scopeProfile}
+
+const (
+	maxDelegates = 10
+	// maxDelegateLifetime is how long after its grant a delegate may last, in milliseconds.
+	maxDelegateLifetime = 30 * 24 * 60 * 60 * 1000
+)
+
+// ErrDelegateExpired is returned when an envelope is signed by a delegate past its expiry.
+var ErrDelegateExpired = errors.New("ERR_DELEGATE_EXPIRED")
+
+// Delegate is a session key a wallet granted to sign envelopes on its behalf, limited
+// to the transactions of its scopes until it expires.
+type Delegate struct {
+	ID        string   `json:"id"`
+	PublicKey string   `json:"publicKey"`
+	Scopes    []string `json:"scopes"`
+	// ExpiresAt is the time the delegate expires at, in milliseconds since the epoch.
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// Delegation grants the delegate to the wallet.
+type Delegation struct {
+	Wallet string `json:"wallet"`
+	Delegate
+}
+
+// Revocation names the delegate of the wallet to revoke.
+type Revocation struct {
+	Wallet string `json:"wallet"`
+	ID     string `json:"id"`
+}
+
+// validate returns ErrInvalidPayload when the delegate breaks its rules at the time now.
+func (d *Delegate) validate(now int64) error {
+	err := firstError(
+		checkID("id", d.ID, true),
+		checkText("publicKey", d.PublicKey, maxPublicKeyLength, true),
+		checkPublicKey("publicKey", d.PublicKey),
+		checkList("scopes", d.Scopes, len(delegateScopes), maxNameLength),
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(d.Scopes) == 0 {
+		return fmt.Errorf("%w: the scopes are required", ErrInvalidPayload)
+	}
+	for _, scope := range d.Scopes {
+		if !contains(delegateScopes, scope) {
+			return fmt.Errorf("%w: the scope %s is unknown", ErrInvalidPayload, scope)
+		}
+	}
+
+	if d.ExpiresAt <= now {
+		return fmt.Errorf("%w: the expiresAt is not in the future", ErrInvalidPayload)
+	}
+	if d.ExpiresAt-now > maxDelegateLifetime {
+		return fmt.Errorf("%w: the expiresAt is more than 30 days away", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// AddDelegate grants a delegate to the wallet of its owner. Delegates past their expiry
+// are dropped from the profile at the same time.
+func (s *SmartContract) AddDelegate(ctx contractapi.TransactionContextInterface, payload string) error {
+	delegation := Delegation{}
+	err := decodePayload(payload, &delegation)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.readOwnProfile(ctx, delegation.Wallet)
+	if err != nil {
+		return err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = delegation.Delegate.validate(now)
+	if err != nil {
+		return err
+	}
+
+	var delegates []Delegate
+	for _, delegate := range user.Delegates {
+		if delegate.ID == delegation.ID {
+			return fmt.Errorf("the delegate %s of the wallet %s already exists", delegation.ID, user.Wallet)
+		}
+		if delegate.ExpiresAt > now {
+			delegates = append(delegates, delegate)
+		}
+	}
+	if len(delegates) >= maxDelegates {
+		return fmt.Errorf("the wallet %s may not have more than %d delegates", user.Wallet, maxDelegates)
+	}
+	user.Delegates = append(delegates, delegation.Delegate)
+
+	err = putUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	delegation.Wallet = user.Wallet
+	delegationJSON, _ := json.Marshal(delegation)
+	return ctx.GetStub().SetEvent("AddDelegate", delegationJSON)
+}
+
+// RevokeDelegate removes a delegate from the wallet of its owner. Envelopes the delegate
+// signs are rejected from the next transaction on.
+func (s *SmartContract) RevokeDelegate(ctx contractapi.TransactionContextInterface, payload string) error {
+	revocation := Revocation{}
+	err := decodePayload(payload, &revocation)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.readOwnProfile(ctx, revocation.Wallet)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, delegate := range user.Delegates {
+		if delegate.ID == revocation.ID {
+			user.Delegates = append(user.Delegates[:i], user.Delegates[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("the wallet %s has no delegate %s", user.Wallet, revocation.ID)
+	}
+
+	err = putUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	revocation.Wallet = user.Wallet
+	revocationJSON, _ := json.Marshal(revocation)
+	return ctx.GetStub().SetEvent("RevokeDelegate", revocationJSON)
+}
+
+// readOwnProfile returns the profile of the submitting wallet, rejecting payloads
+// claiming another wallet, wallets rotated away from and clients other than the one
+// the wallet is bound to.
+func (s *SmartContract) readOwnProfile(ctx contractapi.TransactionContextInterface, claimed string) (*Profile, error) {
+	wallet, err := resolveWallet(ctx, claimed)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.ReadUser(ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+	if user.Wallet != wallet {
+		return nil, fmt.Errorf("%w: the wallet %s was rotated to %s", ErrWalletRotated, wallet, user.Wallet)
+	}
+
+	err = checkBound(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// signingKey returns the public key the envelope must be signed with. That is the key
+// of the delegate the envelope names, which must not have expired and must be granted
+// the scope, or else the key of the wallet. Transactions of no scope are only signed
+// with the key of the wallet.
+func signingKey(ctx contractapi.TransactionContextInterface, env *Envelope, user *Profile, scope string) (string, error) {
+	if env.Delegate == "" {
+		if user.PublicKey == "" {
+			return "", fmt.Errorf("%w: the wallet %s has no public key registered", ErrInvalidSignature, env.Wallet)
+		}
+		return user.PublicKey, nil
+	}
+
+	if scope == "" {
+		return "", fmt.Errorf("%w: delegates may not sign %s", ErrInvalidSignature, env.Function)
+	}
+
+	for _, delegate := range user.Delegates {
+		if delegate.ID != env.Delegate {
+			continue
+		}
+
+		now, err := getTxTime(ctx)
+		if err != nil {
+			return "", err
+		}
+		if delegate.ExpiresAt <= now {
+			return "", fmt.Errorf("%w: the delegate %s of the wallet %s has expired", ErrDelegateExpired, delegate.ID, env.Wallet)
+		}
+		if !contains(delegate.Scopes, scope) {
+			return "", fmt.Errorf("%w: the delegate %s of the wallet %s may not sign %s", ErrInvalidSignature, delegate.ID, env.Wallet, env.Function)
+		}
+		return delegate.PublicKey, nil
+	}
+
+	return "", fmt.Errorf("%w: the wallet %s has no delegate %s", ErrInvalidSignature, env.Wallet, env.Delegate)
+}
@@ -0,0 +1,86 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProfileRevision is a version of a profile as written by a transaction. The record is
+// nil when the transaction deleted the profile.
+type ProfileRevision struct {
+	TxID      string   `json:"txId"`
+	Timestamp int64    `json:"timestamp"`
+	IsDelete  bool     `json:"isDelete"`
+	Record    *Profile `json:"record"`
+}
+
+// ProfileHistoryPage is a page of revisions along with the bookmark the next page starts at.
+type ProfileHistoryPage struct {
+	Records             []*ProfileRevision `json:"records"`
+	FetchedRecordsCount int32              `json:"fetchedRecordsCount"`
+	Bookmark            string             `json:"bookmark"`
+}
+
+// GetUserHistory returns a page of at most pageSize revisions of the profile, newest first,
+// starting after the revision whose transaction ID is the bookmark. The history database
+// must be enabled on the peer. The client who made a revision is recorded in its transaction.
+func (s *SmartContract) GetUserHistory(ctx contractapi.TransactionContextInterface, wallet string, pageSize int32, bookmark string) (*ProfileHistoryPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("the page size %d is not positive", pageSize)
+	}
+
+	key, err := profileKey(ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	// the history cannot be read from an offset, so skip the revisions up to the bookmark
+	for bookmark != "" && resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.TxId == bookmark {
+			bookmark = ""
+		}
+	}
+	if bookmark != "" {
+		return nil, fmt.Errorf("the bookmark %s is not a revision of the user %s", bookmark, wallet)
+	}
+
+	page := &ProfileHistoryPage{}
+	for resultsIterator.HasNext() {
+		if page.FetchedRecordsCount == pageSize {
+			page.Bookmark = page.Records[len(page.Records)-1].TxID
+			break
+		}
+
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		revision := &ProfileRevision{
+			TxID:      modification.TxId,
+			Timestamp: modification.Timestamp.AsTime().UnixMilli(),
+			IsDelete:  modification.IsDelete,
+		}
+		if !modification.IsDelete {
+			revision.Record = &Profile{}
+			json.Unmarshal(modification.Value, revision.Record)
+		}
+
+		page.Records = append(page.Records, revision)
+		page.FetchedRecordsCount++
+	}
+
+	return page, nil
+}
@@ -0,0 +1,305 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// walletAttribute is the certificate attribute carrying the wallet of the client.
+	walletAttribute = "wallet"
+	// roleAttribute is the certificate attribute carrying the role of the client.
+	roleAttribute = "role"
+	// enrollmentAttribute is the certificate attribute the Fabric CA records the
+	// enrollment ID of the client in.
+	enrollmentAttribute = "hf.EnrollmentID"
+
+	// enrollmentObjectType keys map the MSP ID and enrollment ID of a certificate to the
+	// wallet registered with it.
+	enrollmentObjectType = "enrollment~mspId~enrollmentId"
+
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+// getSubmittingWallet returns the wallet of the client submitting the transaction.
+// The wallet that signed the envelope of the transaction takes precedence over the
+// wallet certificate attribute, which takes precedence over the client ID.
+func getSubmittingWallet(ctx contractapi.TransactionContextInterface) (string, error) {
+	if wallet, signed := signedWallet(ctx); signed {
+		return wallet, nil
+	}
+
+	wallet, found, err := ctx.GetClientIdentity().GetAttributeValue(walletAttribute)
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+	if found && wallet != "" {
+		return wallet, nil
+	}
+
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to base64 decode client identity: %v", err)
+	}
+
+	return string(decodeID), nil
+}
+
+// resolveWallet returns the submitting wallet, rejecting payloads claiming another wallet.
+func resolveWallet(ctx contractapi.TransactionContextInterface, claimed string) (string, error) {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if claimed != "" && claimed != wallet {
+		return "", fmt.Errorf("the wallet %s does not match the submitting client %s", claimed, wallet)
+	}
+
+	return wallet, nil
+}
+
+// getEnrollment returns the MSP ID and the enrollment ID of the client submitting the
+// transaction. Certificates not issued by the Fabric CA lack the enrollment ID attribute,
+// and the subject and issuer of the certificate stand in for it.
+func getEnrollment(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	enrollmentID, found, err := ctx.GetClientIdentity().GetAttributeValue(enrollmentAttribute)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+	if found && enrollmentID != "" {
+		return mspID, enrollmentID, nil
+	}
+
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to base64 decode client identity: %v", err)
+	}
+
+	return mspID, string(decodeID), nil
+}
+
+// bindWallet binds the profile to the certificate of the submitting client, so that only
+// clients enrolled under the same identity act as its owner. An enrollment is bound to
+// one wallet at most.
+func bindWallet(ctx contractapi.TransactionContextInterface, user *Profile) error {
+	mspID, enrollmentID, err := getEnrollment(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := enrollmentKey(ctx, mspID, enrollmentID)
+	if err != nil {
+		return err
+	}
+
+	bound, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if bound != nil {
+		return fmt.Errorf("the enrollment %s of %s is already bound to the wallet %s", enrollmentID, mspID, bound)
+	}
+
+	err = ctx.GetStub().PutState(key, []byte(user.Wallet))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	user.MSPID, user.EnrollmentID = mspID, enrollmentID
+	return nil
+}
+
+// rebindWallet points the enrollment the profile is bound to at the current wallet of
+// the profile. Profiles that are not bound are left unbound.
+func rebindWallet(ctx contractapi.TransactionContextInterface, user *Profile) error {
+	if user.EnrollmentID == "" {
+		return nil
+	}
+
+	key, err := enrollmentKey(ctx, user.MSPID, user.EnrollmentID)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, []byte(user.Wallet))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// releaseWallet unbinds the profile from its enrollment, so that the enrollment may be
+// bound to another wallet.
+func releaseWallet(ctx contractapi.TransactionContextInterface, user *Profile) error {
+	if user.EnrollmentID == "" {
+		return nil
+	}
+
+	key, err := enrollmentKey(ctx, user.MSPID, user.EnrollmentID)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+
+	user.MSPID, user.EnrollmentID = "", ""
+	return nil
+}
+
+func enrollmentKey(ctx contractapi.TransactionContextInterface, mspID string, enrollmentID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(enrollmentObjectType, []string{mspID, enrollmentID})
+}
+
+// checkBound returns an error unless the profile is bound to the certificate of the
+// submitting client. Profiles registered before wallets were bound are not checked, nor
+// are transactions the wallet signed, as the signature proves the wallet consents.
+func checkBound(ctx contractapi.TransactionContextInterface, user *Profile) error {
+	if _, signed := signedWallet(ctx); signed || user.EnrollmentID == "" {
+		return nil
+	}
+
+	mspID, enrollmentID, err := getEnrollment(ctx)
+	if err != nil {
+		return err
+	}
+
+	if mspID != user.MSPID || enrollmentID != user.EnrollmentID {
+		return fmt.Errorf("the wallet %s is bound to another certificate than the submitting client's", user.Wallet)
+	}
+	return nil
+}
+
+// hasRole returns true when the submitting wallet holds one of the roles, either
+// through the role certificate attribute or the roles assigned to its profile. The
+// certificate of a client submitting a signed envelope confers no roles on the wallet.
+func hasRole(ctx contractapi.TransactionContextInterface, wallet string, roles ...string) (bool, error) {
+	if _, signed := signedWallet(ctx); !signed {
+		role, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client identity: %v", err)
+		}
+		if found && contains(roles, role) {
+			return true, nil
+		}
+	}
+
+	key, err := profileKey(ctx, wallet)
+	if err != nil {
+		return false, err
+	}
+
+	userJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if userJSON == nil {
+		return false, nil
+	}
+
+	var user Profile
+	json.Unmarshal(userJSON, &user)
+
+	for _, assigned := range user.RolesAssigned {
+		if contains(roles, assigned) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// mutableProfileFields returns the fields of the profile the submitting client may
+// change. Owners change their own details, holders of the moderate permission whether
+// the user is muted or banned, and admins the balance and credibility.
+func mutableProfileFields(ctx contractapi.TransactionContextInterface, user *Profile) ([]string, error) {
+	submitter, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutable []string
+	if submitter == user.Wallet {
+		err = checkBound(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range profileFields {
+			// delegates may not replace the key that grants them, nor who may recover it
+			if (field == "publicKey" || field == "guardians") && signedByDelegate(ctx) {
+				continue
+			}
+			mutable = append(mutable, field)
+		}
+	}
+
+	moderator, err := hasPermission(ctx, submitter, permissionModerate)
+	if err != nil {
+		return nil, err
+	}
+	if moderator {
+		mutable = append(mutable, moderatedProfileFields...)
+	}
+
+	admin, err := hasRole(ctx, submitter, roleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if admin {
+		mutable = append(mutable, adminProfileFields...)
+	}
+
+	if len(mutable) == 0 {
+		return nil, fmt.Errorf("the wallet %s does not match the submitting client %s", user.Wallet, submitter)
+	}
+	return mutable, nil
+}
+
+// checkAdmin returns an error unless the submitting wallet is an admin. The action
+// describes the operation in the error message.
+func checkAdmin(ctx contractapi.TransactionContextInterface, action string) error {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return err
+	}
+
+	admin, err := hasRole(ctx, wallet, roleAdmin)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("the client %s is not permitted to %s", wallet, action)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrateKeys moves profiles stored under their bare wallet, as written before composite
+// keys were introduced, to their composite key. It returns the number of profiles moved.
+// The certificate of their owner is not known to the admin migrating them, so moved
+// profiles are left unbound, and bound to the certificate of their owner when the owner
+// submits BindWallet. Enrollments a moved profile names without being bound to them are
+// dropped, as checkBound would otherwise hold the profile to a binding that was never made.
+func (s *SmartContract) MigrateKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := checkAdmin(ctx, "migrate keys")
+	if err != nil {
+		return 0, err
+	}
+
+	// range queries only cover simple keys, so this visits legacy entries only
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var user Profile
+		if json.Unmarshal(queryResponse.Value, &user) != nil || user.Wallet == "" {
+			continue
+		}
+
+		value := queryResponse.Value
+		if user.EnrollmentID != "" {
+			bound, err := isBound(ctx, &user)
+			if err != nil {
+				return 0, err
+			}
+			if !bound {
+				user.MSPID, user.EnrollmentID = "", ""
+				value, _ = json.Marshal(user)
+			}
+		}
+
+		key, err := profileKey(ctx, user.Wallet)
+		if err != nil {
+			return 0, err
+		}
+
+		err = ctx.GetStub().PutState(key, value)
+		if err != nil {
+			return 0, fmt.Errorf("failed to put to world state: %v", err)
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete from world state: %v", err)
+		}
+		migrated++
+	}
+
+	return migrated, ctx.GetStub().SetEvent("MigrateKeys", []byte(strconv.Itoa(migrated)))
+}
+
+// isBound returns true when the enrollment the profile names is bound to its wallet.
+func isBound(ctx contractapi.TransactionContextInterface, user *Profile) (bool, error) {
+	key, err := enrollmentKey(ctx, user.MSPID, user.EnrollmentID)
+	if err != nil {
+		return false, err
+	}
+
+	bound, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return string(bound) == user.Wallet, nil
+}
@@ -0,0 +1,46 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProfilePage is a page of users along with the bookmark the next page starts at.
+type ProfilePage struct {
+	Records             []*Profile `json:"records"`
+	FetchedRecordsCount int32      `json:"fetchedRecordsCount"`
+	Bookmark            string     `json:"bookmark"`
+}
+
+// GetAllUsersWithPagination returns a page of at most pageSize users starting at the bookmark.
+func (s *SmartContract) GetAllUsersWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ProfilePage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("the page size %d is not positive", pageSize)
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(profileObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var users []*Profile
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var user Profile
+		json.Unmarshal(queryResponse.Value, &user)
+		users = append(users, &user)
+	}
+
+	return &ProfilePage{
+		Records:             users,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
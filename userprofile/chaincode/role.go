@@ -0,0 +1,307 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// roleObjectType keys hold the definitions of roles, by name.
+const roleObjectType = "role~name"
+
+// permissionModerate is the permission to hide and lock content and to mute and ban users.
+const permissionModerate = "moderate"
+
+const (
+	maxDescriptionLength = 256
+	maxPermissions       = 32
+)
+
+// Role defines what holding a role permits and who may grant it. Admins hold every
+// permission and may grant every role, whatever the definitions say.
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	// GrantedBy are the roles whose holders may grant and revoke the role besides admins.
+	GrantedBy []string `json:"grantedBy"`
+}
+
+// builtinRoles are defined until UpdateRole stores other definitions for them. The
+// chaincodes check admins by name, and moderators are granted the moderate permission
+// out of the box, so neither can be deleted.
+var builtinRoles = []Role{
+	{Name: roleAdmin, Description: "Holds every permission and grants every role", GrantedBy: []string{roleAdmin}},
+	{Name: roleModerator, Description: "Moderates content and users", Permissions: []string{permissionModerate}, GrantedBy: []string{roleAdmin}},
+}
+
+// validate returns ErrInvalidPayload when the role breaks the rules for its fields.
+func (r *Role) validate() error {
+	return firstError(
+		checkText("name", r.Name, maxNameLength, true),
+		checkText("description", r.Description, maxDescriptionLength, false),
+		checkList("permissions", r.Permissions, maxPermissions, maxNameLength),
+		checkList("grantedBy", r.GrantedBy, maxRoles, maxNameLength),
+	)
+}
+
+// CreateRole defines a new role. Only admins may define roles.
+func (s *SmartContract) CreateRole(ctx contractapi.TransactionContextInterface, payload string) error {
+	role := Role{}
+	err := decodePayload(payload, &role)
+	if err != nil {
+		return err
+	}
+
+	err = checkAdmin(ctx, "manage roles")
+	if err != nil {
+		return err
+	}
+
+	err = role.validate()
+	if err != nil {
+		return err
+	}
+
+	existing, err := readRole(ctx, role.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("the role %s already exists", role.Name)
+	}
+
+	return putRole(ctx, &role, "CreateRole")
+}
+
+// ReadRole returns the definition of the role with the given name.
+func (s *SmartContract) ReadRole(ctx contractapi.TransactionContextInterface, name string) (*Role, error) {
+	role, err := readRole(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("the role %s does not exist", name)
+	}
+	return role, nil
+}
+
+// UpdateRole replaces the definition of an existing role. Only admins may change roles.
+func (s *SmartContract) UpdateRole(ctx contractapi.TransactionContextInterface, payload string) error {
+	role := Role{}
+	err := decodePayload(payload, &role)
+	if err != nil {
+		return err
+	}
+
+	err = checkAdmin(ctx, "manage roles")
+	if err != nil {
+		return err
+	}
+
+	err = role.validate()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ReadRole(ctx, role.Name)
+	if err != nil {
+		return err
+	}
+
+	return putRole(ctx, &role, "UpdateRole")
+}
+
+// DeleteRole removes the definition of a role. Users keep the role in their assigned
+// roles, but it no longer grants them any permission and cannot be assigned again.
+func (s *SmartContract) DeleteRole(ctx contractapi.TransactionContextInterface, name string) error {
+	err := checkAdmin(ctx, "manage roles")
+	if err != nil {
+		return err
+	}
+
+	if builtinRole(name) != nil {
+		return fmt.Errorf("the role %s is built in and cannot be deleted", name)
+	}
+
+	role, err := s.ReadRole(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(roleObjectType, []string{name})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+
+	roleJSON, _ := json.Marshal(role)
+	return ctx.GetStub().SetEvent("DeleteRole", roleJSON)
+}
+
+// GetAllRoles returns the built in roles followed by the roles defined on the ledger.
+func (s *SmartContract) GetAllRoles(ctx contractapi.TransactionContextInterface) ([]*Role, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(roleObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	stored := map[string]*Role{}
+	var defined []*Role
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var role Role
+		json.Unmarshal(queryResponse.Value, &role)
+		if builtinRole(role.Name) != nil {
+			stored[role.Name] = &role
+			continue
+		}
+		defined = append(defined, &role)
+	}
+
+	var roles []*Role
+	for i := range builtinRoles {
+		role := stored[builtinRoles[i].Name]
+		if role == nil {
+			role = &builtinRoles[i]
+		}
+		roles = append(roles, role)
+	}
+
+	return append(roles, defined...), nil
+}
+
+// HasPermission reports whether the wallet holds the permission through the roles
+// assigned to its profile, or through the role certificate attribute when the wallet
+// is the submitting client. Wallets rotated away from hold no permissions.
+func (s *SmartContract) HasPermission(ctx contractapi.TransactionContextInterface, wallet string, permission string) (bool, error) {
+	return hasPermission(ctx, wallet, permission)
+}
+
+func hasPermission(ctx contractapi.TransactionContextInterface, wallet string, permission string) (bool, error) {
+	var roles []string
+
+	if _, signed := signedWallet(ctx); !signed {
+		submitter, err := getSubmittingWallet(ctx)
+		if err != nil {
+			return false, err
+		}
+		role, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client identity: %v", err)
+		}
+		if submitter == wallet && found && role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	key, err := profileKey(ctx, wallet)
+	if err != nil {
+		return false, err
+	}
+
+	userJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if userJSON != nil {
+		var user Profile
+		json.Unmarshal(userJSON, &user)
+		roles = append(roles, user.RolesAssigned...)
+	}
+
+	for _, name := range roles {
+		if name == roleAdmin {
+			return true, nil
+		}
+
+		role, err := readRole(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		if role != nil && contains(role.Permissions, permission) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkGrant returns an error unless the submitting wallet may grant and revoke the
+// role. Roles no longer defined are managed by admins only.
+func checkGrant(ctx contractapi.TransactionContextInterface, role *Role) error {
+	wallet, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return err
+	}
+
+	grantors := []string{roleAdmin}
+	if role != nil {
+		grantors = append(grantors, role.GrantedBy...)
+	}
+
+	allowed, err := hasRole(ctx, wallet, grantors...)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("the client %s is not permitted to manage roles", wallet)
+	}
+	return nil
+}
+
+// readRole returns the definition of the role, falling back to the built in roles, or
+// nil when the role is not defined.
+func readRole(ctx contractapi.TransactionContextInterface, name string) (*Role, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(roleObjectType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	roleJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if roleJSON == nil {
+		return builtinRole(name), nil
+	}
+
+	var role Role
+	json.Unmarshal(roleJSON, &role)
+	return &role, nil
+}
+
+// builtinRole returns a copy of the built in role with the given name, or nil.
+func builtinRole(name string) *Role {
+	for _, role := range builtinRoles {
+		if role.Name == name {
+			return &role
+		}
+	}
+	return nil
+}
+
+func putRole(ctx contractapi.TransactionContextInterface, role *Role, event string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(roleObjectType, []string{role.Name})
+	if err != nil {
+		return err
+	}
+
+	roleJSON, _ := json.Marshal(role)
+	err = ctx.GetStub().PutState(key, roleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	return ctx.GetStub().SetEvent(event, roleJSON)
+}
@@ -0,0 +1,360 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// forwardObjectType keys map a wallet a profile was rotated away from to the wallet
+	// holding the profile now.
+	forwardObjectType = "forward~wallet"
+	// recoveryObjectType keys hold the pending recovery of a wallet.
+	recoveryObjectType = "recovery~wallet"
+
+	maxGuardians = 10
+	// recoveryWindow is how long a recovery collects approvals after the first one, in
+	// milliseconds.
+	recoveryWindow = 7 * 24 * 60 * 60 * 1000
+	// minRecoveryQuorum is the fewest approvals a recovery ever executes with.
+	minRecoveryQuorum = 2
+)
+
+// ErrWalletRotated is returned when a transaction acts as a wallet whose profile was
+// rotated to another wallet.
+var ErrWalletRotated = errors.New("ERR_WALLET_ROTATED")
+
+// Rotation moves the profile of a wallet to a new wallet, along with the public key the
+// new wallet signs envelopes with, if any.
+type Rotation struct {
+	Wallet    string `json:"wallet"`
+	NewWallet string `json:"newWallet"`
+	PublicKey string `json:"publicKey"`
+}
+
+// Recovery is a rotation of a wallet that lost its key, pending until enough guardians
+// and admins approve it.
+type Recovery struct {
+	Rotation
+	Approvals []string `json:"approvals"`
+	// ExpiresAt is the time the recovery lapses at, in milliseconds since the epoch.
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// validate returns ErrInvalidPayload when the rotation breaks the rules for its fields.
+func (r *Rotation) validate() error {
+	return firstError(
+		checkText("wallet", r.Wallet, maxWalletLength, true),
+		checkText("newWallet", r.NewWallet, maxWalletLength, true),
+		checkPublicKey("publicKey", r.PublicKey),
+	)
+}
+
+// RotateWallet moves the profile of the submitting wallet to a new wallet. The old
+// wallet is left with a forwarding record, so that ReadUser still finds the profile
+// by it, and the content it created stays owned by the new wallet. Delegates are
+// dropped, as they belong to the old key, while the certificate binding moves to the
+// new wallet, as the owner still holds the certificate.
+func (s *SmartContract) RotateWallet(ctx contractapi.TransactionContextInterface, payload string) error {
+	rotation := Rotation{}
+	err := decodePayload(payload, &rotation)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.readOwnProfile(ctx, rotation.Wallet)
+	if err != nil {
+		return err
+	}
+	rotation.Wallet = user.Wallet
+
+	err = s.rotate(ctx, user, &rotation, true)
+	if err != nil {
+		return err
+	}
+
+	rotationJSON, _ := json.Marshal(rotation)
+	return ctx.GetStub().SetEvent("RotateWallet", rotationJSON)
+}
+
+// ApproveRecovery approves rotating a wallet that lost its key on behalf of its owner.
+// Guardians of the wallet and admins may approve, and the rotation runs once a
+// majority of the guardians, and at least two approvers, agree on the same new
+// wallet within the recovery window. The certificate binding is released, as the
+// certificate may be lost along with the key, and the owner binds the new wallet
+// with BindWallet.
+func (s *SmartContract) ApproveRecovery(ctx contractapi.TransactionContextInterface, payload string) error {
+	rotation := Rotation{}
+	err := decodePayload(payload, &rotation)
+	if err != nil {
+		return err
+	}
+
+	err = rotation.validate()
+	if err != nil {
+		return err
+	}
+
+	approver, err := getSubmittingWallet(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.ReadUser(ctx, rotation.Wallet)
+	if err != nil {
+		return err
+	}
+	if user.Wallet != rotation.Wallet {
+		return fmt.Errorf("%w: the wallet %s was rotated to %s", ErrWalletRotated, rotation.Wallet, user.Wallet)
+	}
+
+	admin, err := hasRole(ctx, approver, roleAdmin)
+	if err != nil {
+		return err
+	}
+	if approver == user.Wallet || (!admin && !contains(user.Guardians, approver)) {
+		return fmt.Errorf("the client %s is not permitted to recover the wallet %s", approver, user.Wallet)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	recovery, err := readRecovery(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+	if recovery == nil || recovery.ExpiresAt <= now {
+		recovery = &Recovery{Rotation: rotation, ExpiresAt: now + recoveryWindow}
+	}
+	if recovery.Rotation != rotation {
+		return fmt.Errorf("a recovery of the wallet %s to %s is pending", user.Wallet, recovery.NewWallet)
+	}
+	if contains(recovery.Approvals, approver) {
+		return fmt.Errorf("the client %s already approved the recovery of the wallet %s", approver, user.Wallet)
+	}
+	recovery.Approvals = append(recovery.Approvals, approver)
+
+	if len(recovery.Approvals) >= recoveryQuorum(user) {
+		// rotate drops the pending recovery along with the old wallet
+		err = s.rotate(ctx, user, &rotation, false)
+	} else {
+		err = putRecovery(ctx, recovery)
+	}
+	if err != nil {
+		return err
+	}
+
+	recoveryJSON, _ := json.Marshal(recovery)
+	return ctx.GetStub().SetEvent("ApproveRecovery", recoveryJSON)
+}
+
+// CancelRecovery drops the pending recovery of the submitting wallet, so that an owner
+// who still holds the key can stop guardians from rotating it away.
+func (s *SmartContract) CancelRecovery(ctx contractapi.TransactionContextInterface, payload string) error {
+	cancel := struct {
+		Wallet string `json:"wallet"`
+	}{}
+	err := decodePayload(payload, &cancel)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.readOwnProfile(ctx, cancel.Wallet)
+	if err != nil {
+		return err
+	}
+
+	recovery, err := readRecovery(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+	if recovery == nil {
+		return fmt.Errorf("no recovery of the wallet %s is pending", user.Wallet)
+	}
+
+	err = deleteRecovery(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+
+	recoveryJSON, _ := json.Marshal(recovery)
+	return ctx.GetStub().SetEvent("CancelRecovery", recoveryJSON)
+}
+
+// recoveryQuorum returns how many approvals the recovery of the profile needs: a
+// majority of its guardians, but never fewer than minRecoveryQuorum.
+func recoveryQuorum(user *Profile) int {
+	quorum := len(user.Guardians)/2 + 1
+	if quorum < minRecoveryQuorum {
+		return minRecoveryQuorum
+	}
+	return quorum
+}
+
+// rotate moves the profile to the new wallet of the rotation and points every wallet
+// the profile was held by to the new wallet, so that forwards never chain. The
+// enrollment the profile is bound to is bound to the new wallet when keepBinding is
+// true, and released otherwise.
+func (s *SmartContract) rotate(ctx contractapi.TransactionContextInterface, user *Profile, rotation *Rotation, keepBinding bool) error {
+	err := rotation.validate()
+	if err != nil {
+		return err
+	}
+	if rotation.NewWallet == user.Wallet {
+		return fmt.Errorf("%w: the newWallet is the current wallet", ErrInvalidPayload)
+	}
+
+	exists, err := s.UserExists(ctx, rotation.NewWallet)
+	if err != nil {
+		return err
+	}
+	forward, err := readForward(ctx, rotation.NewWallet)
+	if err != nil {
+		return err
+	}
+	if exists || forward != "" {
+		return fmt.Errorf("the user wallet %s already exists", rotation.NewWallet)
+	}
+
+	key, err := profileKey(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+
+	err = deleteRecovery(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+
+	user.PreviousWallets = append(user.PreviousWallets, user.Wallet)
+	for _, previous := range user.PreviousWallets {
+		forwardKey, err := ctx.GetStub().CreateCompositeKey(forwardObjectType, []string{previous})
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(forwardKey, []byte(rotation.NewWallet))
+		if err != nil {
+			return fmt.Errorf("failed to put to world state: %v", err)
+		}
+	}
+
+	user.Wallet, user.PublicKey = rotation.NewWallet, rotation.PublicKey
+	user.Delegates = nil
+
+	if keepBinding {
+		err = rebindWallet(ctx, user)
+	} else {
+		err = releaseWallet(ctx, user)
+	}
+	if err != nil {
+		return err
+	}
+
+	return putUser(ctx, user)
+}
+
+// BindWallet binds the profile of the submitting wallet to the certificate of the
+// submitting client. Profiles are bound when they are created, but those registered
+// before wallets were bound, and those recovered by their guardians, are not bound
+// until their owner submits this transaction.
+func (s *SmartContract) BindWallet(ctx contractapi.TransactionContextInterface, payload string) error {
+	bind := struct {
+		Wallet string `json:"wallet"`
+	}{}
+	err := decodePayload(payload, &bind)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.readOwnProfile(ctx, bind.Wallet)
+	if err != nil {
+		return err
+	}
+	if user.EnrollmentID != "" {
+		return fmt.Errorf("the wallet %s is already bound to the enrollment %s of %s", user.Wallet, user.EnrollmentID, user.MSPID)
+	}
+
+	err = bindWallet(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	err = putUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	userJSON, _ := json.Marshal(user)
+	return ctx.GetStub().SetEvent("BindWallet", userJSON)
+}
+
+// readForward returns the wallet the profile of the wallet was rotated to, or "" when
+// it was never rotated.
+func readForward(ctx contractapi.TransactionContextInterface, wallet string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(forwardObjectType, []string{wallet})
+	if err != nil {
+		return "", err
+	}
+
+	forward, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return string(forward), nil
+}
+
+// readRecovery returns the pending recovery of the wallet, or nil when there is none.
+func readRecovery(ctx contractapi.TransactionContextInterface, wallet string) (*Recovery, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(recoveryObjectType, []string{wallet})
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if recoveryJSON == nil {
+		return nil, nil
+	}
+
+	var recovery Recovery
+	json.Unmarshal(recoveryJSON, &recovery)
+	return &recovery, nil
+}
+
+func putRecovery(ctx contractapi.TransactionContextInterface, recovery *Recovery) error {
+	key, err := ctx.GetStub().CreateCompositeKey(recoveryObjectType, []string{recovery.Wallet})
+	if err != nil {
+		return err
+	}
+
+	recoveryJSON, _ := json.Marshal(recovery)
+	err = ctx.GetStub().PutState(key, recoveryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+func deleteRecovery(ctx contractapi.TransactionContextInterface, wallet string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(recoveryObjectType, []string{wallet})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -20,54 +19,144 @@ type Profile struct {
 	Wallet    string `json:"wallet"`
 	Avatar    string `json:"avatar"`
 	Signature string `json:"signature"`
+	// PublicKey verifies the envelopes the wallet signs, written as the signature scheme
+	// and the base64 encoded key separated by a colon.
+	PublicKey string `json:"publicKey,omitempty"`
 	Muted     bool   `json:"muted"`
 	Banned    bool   `json:"banned"`
 
 	Balance     int  `json:"balance"`
 	Credibility uint `json:"credibility"`
 
+	// MSPID and EnrollmentID identify the certificate the wallet was registered with.
+	MSPID        string `json:"mspId,omitempty"`
+	EnrollmentID string `json:"enrollmentId,omitempty"`
+
 	ActiveRole     string   `json:"activeRole"`
 	RolesAssigned  []string `json:"rolesAssigned"`
 	ActiveBadge    string   `json:"activeBadge"`
 	BadgesReceived []string `json:"badgesReceived"`
+
+	Delegates []Delegate `json:"delegates,omitempty"`
+	// Guardians may approve recovering the profile to a new wallet when its key is lost.
+	Guardians []string `json:"guardians,omitempty"`
+	// PreviousWallets are the wallets the profile was rotated away from, oldest first.
+	PreviousWallets []string `json:"previousWallets,omitempty"`
+
+	Version   uint64 `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
 }
 
+// profileFields are the fields of a profile its owner may change through UpdateUser.
+var profileFields = []string{"username", "avatar", "signature", "publicKey", "guardians", "activeRole", "activeBadge"}
+
+// moderatedProfileFields may also be changed by moderators, adminProfileFields by admins.
+var (
+	moderatedProfileFields = []string{"muted", "banned"}
+	adminProfileFields     = []string{"balance", "credibility"}
+)
+
+// profileKeys are the fields of an UpdateUser payload naming the profile and the
+// version the patch was made against.
+var profileKeys = []string{"wallet", "expectedVersion"}
+
 // CreateUser creates a new user on the ledger with given details.
 func (s *SmartContract) CreateUser(ctx contractapi.TransactionContextInterface, payload string) error {
 
 	user := Profile{}
 
-	err := json.Unmarshal([]byte(payload), &user)
+	err := decodePayload(payload, &user)
 
 	if err != nil {
 		return err
 	}
 
+	user.Wallet, err = resolveWallet(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+
+	err = user.validate()
+	if err != nil {
+		return err
+	}
+
 	exists, err := s.UserExists(ctx, user.Wallet)
 
 	if exists {
 		return fmt.Errorf("the user wallet %s already exists", user.Wallet)
 	}
 
-	// return ctx.GetStub().PutState(userId, userJSON)
-	err = ctx.GetStub().PutState(user.Wallet, []byte(payload))
+	forward, err := readForward(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+	if forward != "" {
+		return fmt.Errorf("%w: the wallet %s was rotated to %s", ErrWalletRotated, user.Wallet, forward)
+	}
 
+	err = bindWallet(ctx, &user)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
+	}
+
+	// roles, badges, standing and moderation flags are granted through their own
+	// transactions, and versions and timestamps kept by the chaincode, never taken
+	// from the client
+	user.ActiveRole, user.RolesAssigned = "", nil
+	user.ActiveBadge, user.BadgesReceived = "", nil
+	user.Balance, user.Credibility = 0, 0
+	user.Muted, user.Banned = false, false
+	user.Delegates = nil
+	// only rotations record the wallets a profile was held by
+	user.PreviousWallets = nil
+	user.Version = 0
+	user.CreatedAt, user.UpdatedAt = 0, 0
+
+	err = putUser(ctx, &user)
+	if err != nil {
+		return err
 	}
 
 	userJson, _ := json.Marshal(user)
+
 	return ctx.GetStub().SetEvent("CreateUser", userJson)
 }
 
-// ReadUser returns the user stored in the world state with given id.
+// ReadUser returns the user stored in the world state with given id. The wallets a
+// profile was rotated away from return the profile under its current wallet.
 func (s *SmartContract) ReadUser(ctx contractapi.TransactionContextInterface, wallet string) (*Profile, error) {
-	userJSON, err := ctx.GetStub().GetState(wallet)
+	key, err := profileKey(ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	userJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
 	if userJSON == nil {
-		return nil, fmt.Errorf("the user %s does not exist", wallet)
+		// rotations point every previous wallet straight at the current one
+		forward, err := readForward(ctx, wallet)
+		if err != nil {
+			return nil, err
+		}
+		if forward == "" {
+			return nil, fmt.Errorf("the user %s does not exist", wallet)
+		}
+
+		key, err = profileKey(ctx, forward)
+		if err != nil {
+			return nil, err
+		}
+		userJSON, err = ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if userJSON == nil {
+			return nil, fmt.Errorf("the user %s does not exist", forward)
+		}
 	}
 
 	var asset Profile
@@ -77,11 +166,22 @@ func (s *SmartContract) ReadUser(ctx contractapi.TransactionContextInterface, wa
 	return &asset, nil
 }
 
+// WhoAmI returns the profile of the client submitting the transaction.
+func (s *SmartContract) WhoAmI(ctx contractapi.TransactionContextInterface) (*Profile, error) {
+	return s.readOwnProfile(ctx, "")
+}
+
+// UpdateUser applies the JSON Merge Patch in the payload to the profile of the wallet
+// in the payload. Only the fields the submitting client may change are applied. The
+// payload may carry the expectedVersion the patch was made against.
 func (s *SmartContract) UpdateUser(ctx contractapi.TransactionContextInterface, payload string) error {
 
-	next := Profile{}
+	next, expectation := Profile{}, Expectation{}
 
-	err := json.Unmarshal([]byte(payload), &next)
+	err := decodePayload(payload, &struct {
+		*Profile
+		*Expectation
+	}{&next, &expectation})
 
 	if err != nil {
 		return err
@@ -99,53 +199,104 @@ func (s *SmartContract) UpdateUser(ctx contractapi.TransactionContextInterface,
 
 	prev, _ := s.ReadUser(ctx, next.Wallet)
 
-	x := reflect.ValueOf(&next).Elem()
-	y := reflect.ValueOf(prev).Elem()
+	mutable, err := mutableProfileFields(ctx, prev)
+	if err != nil {
+		return err
+	}
 
-	// use reflection package to dynamically update non-zero value
-	for i := 0; i < x.NumField(); i++ {
-		name := x.Type().Field(i).Name
-		yf := y.FieldByName(name)
-		xf := x.FieldByName(name)
-		if name != "Wallet" && yf.CanSet() && !xf.IsZero() {
-			yf.Set(xf)
-		}
+	err = expectation.check(prev.Wallet, prev.Version)
+	if err != nil {
+		return err
 	}
 
-	// overwriting original user with new user
+	err = mergePatch(prev, payload, mutable, profileKeys)
+	if err != nil {
+		return err
+	}
 
-	yJSON, _ := json.Marshal(prev)
-	err = ctx.GetStub().PutState(prev.Wallet, yJSON)
+	err = prev.validate()
+	if err != nil {
+		return err
+	}
 
+	// only the patched active role and badge are checked, so that a stale one never
+	// blocks changes to the other fields
+	var patch map[string]json.RawMessage
+	json.Unmarshal([]byte(payload), &patch)
+
+	if _, patched := patch["activeRole"]; patched && prev.ActiveRole != "" && !contains(prev.RolesAssigned, prev.ActiveRole) {
+		return fmt.Errorf("the role %s is not assigned to %s", prev.ActiveRole, prev.Wallet)
+	}
+	if _, patched := patch["activeBadge"]; patched && prev.ActiveBadge != "" && !contains(prev.BadgesReceived, prev.ActiveBadge) {
+		return fmt.Errorf("the badge %s is not received by %s", prev.ActiveBadge, prev.Wallet)
+	}
+
+	// overwriting original user with new user
+
+	err = putUser(ctx, prev)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
 	return ctx.GetStub().SetEvent("UpdateUser", []byte(payload))
 }
 
+// AssignRole assigns a defined role to the user. Admins and the holders of the roles
+// that grant the role may assign it, once per user.
 func (s *SmartContract) AssignRole(ctx contractapi.TransactionContextInterface, wallet string, role string) error {
 
-	user, err := s.ReadUser(ctx, wallet)
+	definition, err := readRole(ctx, role)
 	if err != nil {
 		return err
 	}
 
-	user.RolesAssigned = append(user.RolesAssigned, role)
+	err = checkGrant(ctx, definition)
+	if err != nil {
+		return err
+	}
 
-	userJSON, _ := json.Marshal(user)
+	if definition == nil {
+		return fmt.Errorf("the role %s does not exist", role)
+	}
 
-	err = ctx.GetStub().PutState(wallet, userJSON)
+	user, err := s.ReadUser(ctx, wallet)
+	if err != nil {
+		return err
+	}
+
+	if contains(user.RolesAssigned, role) {
+		return fmt.Errorf("the user %s already has the role %s", user.Wallet, role)
+	}
 
+	user.RolesAssigned = append(user.RolesAssigned, role)
+	err = user.validate()
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
+	}
+
+	err = putUser(ctx, user)
+	if err != nil {
+		return err
 	}
 
+	userJSON, _ := json.Marshal(user)
+
 	return ctx.GetStub().SetEvent("AssignRole", userJSON)
 }
 
+// RemoveRole revokes a role from the user. Whoever may assign the role may revoke it.
 func (s *SmartContract) RemoveRole(ctx contractapi.TransactionContextInterface, wallet string, role string) error {
 
+	definition, err := readRole(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	err = checkGrant(ctx, definition)
+	if err != nil {
+		return err
+	}
+
 	user, err := s.ReadUser(ctx, wallet)
 
 	if err != nil {
@@ -158,41 +309,57 @@ func (s *SmartContract) RemoveRole(ctx contractapi.TransactionContextInterface,
 			break
 		}
 	}
+	if user.ActiveRole == role {
+		user.ActiveRole = ""
+	}
 
-	userJSON, _ := json.Marshal(user)
-
-	// return ctx.GetStub().PutState(userId, userJSON)
-	err = ctx.GetStub().PutState(wallet, userJSON)
-
+	err = putUser(ctx, user)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
+	userJSON, _ := json.Marshal(user)
+
 	return ctx.GetStub().SetEvent("RemoveRole", userJSON)
 
 }
 
+// AssignBadge awards a badge to the user. Only admins may award badges.
 func (s *SmartContract) AssignBadge(ctx contractapi.TransactionContextInterface, wallet string, badge string) error {
 
+	err := checkAdmin(ctx, "manage badges")
+	if err != nil {
+		return err
+	}
+
 	user, err := s.ReadUser(ctx, wallet)
 	if err != nil {
 		return err
 	}
 
 	user.BadgesReceived = append(user.BadgesReceived, badge)
+	err = user.validate()
+	if err != nil {
+		return err
+	}
 
-	userJSON, _ := json.Marshal(user)
-
-	// return ctx.GetStub().PutState(userId, userJSON)
-	err = ctx.GetStub().PutState(wallet, userJSON)
+	err = putUser(ctx, user)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
+	userJSON, _ := json.Marshal(user)
+
 	return ctx.GetStub().SetEvent("AssignBadge", userJSON)
 }
 
+// RemoveBadge takes a badge away from the user. Only admins may take badges away.
 func (s *SmartContract) RemoveBadge(ctx contractapi.TransactionContextInterface, wallet string, badge string) error {
+	err := checkAdmin(ctx, "manage badges")
+	if err != nil {
+		return err
+	}
+
 	user, err := s.ReadUser(ctx, wallet)
 	if err != nil {
 		return err
@@ -204,21 +371,28 @@ func (s *SmartContract) RemoveBadge(ctx contractapi.TransactionContextInterface,
 			break
 		}
 	}
+	if user.ActiveBadge == badge {
+		user.ActiveBadge = ""
+	}
 
-	userJSON, _ := json.Marshal(user)
-
-	// return ctx.GetStub().PutState(userId, userJSON)
-	err = ctx.GetStub().PutState(wallet, userJSON)
+	err = putUser(ctx, user)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state: %v", err)
+		return err
 	}
 
+	userJSON, _ := json.Marshal(user)
+
 	return ctx.GetStub().SetEvent("RemoveBadge", userJSON)
 }
 
 // UserExists returns true when asset with given ID exists in world state
 func (s *SmartContract) UserExists(ctx contractapi.TransactionContextInterface, userId string) (bool, error) {
-	userJSON, err := ctx.GetStub().GetState(userId)
+	key, err := profileKey(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+
+	userJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -228,7 +402,7 @@ func (s *SmartContract) UserExists(ctx contractapi.TransactionContextInterface,
 
 // GetAllUsers returns all users found in world state
 func (s *SmartContract) GetAllUsers(ctx contractapi.TransactionContextInterface) ([]*Profile, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(profileObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -250,3 +424,38 @@ func (s *SmartContract) GetAllUsers(ctx contractapi.TransactionContextInterface)
 	assetsJSON, _ := json.Marshal(assets)
 	return assets, ctx.GetStub().SetEvent("GetAllUsers", assetsJSON)
 }
+
+// profileObjectType namespaces the composite keys profiles are stored under.
+const profileObjectType = "profile~wallet"
+
+// profileKey returns the world state key of the profile with given wallet.
+func profileKey(ctx contractapi.TransactionContextInterface, wallet string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(profileObjectType, []string{wallet})
+}
+
+// putUser writes the profile to the world state under its composite key, moving it to
+// its next version and stamping it as updated, and created when it is new, at the
+// transaction time.
+func putUser(ctx contractapi.TransactionContextInterface, user *Profile) error {
+	key, err := profileKey(ctx, user.Wallet)
+	if err != nil {
+		return err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if user.CreatedAt == 0 {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	user.Version++
+
+	userJSON, _ := json.Marshal(user)
+	err = ctx.GetStub().PutState(key, userJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
@@ -1,19 +1,25 @@
 package chaincode_test
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"userprofile/chaincode"
 	"userprofile/chaincode/mocks"
 
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
 	// _ "github.com/maxbrunsfeld/counterfeiter/v6"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -31,10 +37,23 @@ type stateQueryIterator interface {
 	shim.StateQueryIteratorInterface
 }
 
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/historyqueryiterator.go -fake-name HistoryQueryIterator . historyQueryIterator
+type historyQueryIterator interface {
+	shim.HistoryQueryIteratorInterface
+}
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/clientIdentity.go -fake-name ClientIdentity . clientIdentity
+type clientIdentity interface {
+	cid.ClientIdentity
+}
+
+// sampleAvatar is the CIDv0 of the content 1.
+const sampleAvatar = "QmVaPTddRyjLjMoZnYufWc5M5CjyGNPmFEpp5HtPKEqZFG"
+
 var sampleUser = &chaincode.Profile{
 	Username:  "user1",
 	Wallet:    "wallet1",
-	Avatar:    "avatar1",
+	Avatar:    sampleAvatar,
 	Signature: "signature1",
 	Muted:     false,
 	Banned:    false,
@@ -43,10 +62,11 @@ var sampleUser = &chaincode.Profile{
 
 var sampleInput, _ = json.Marshal(sampleUser)
 
+// samplePatch changes the fields of sampleUser its owner may update to their own values.
+var samplePatch = `{"wallet":"wallet1","username":"user1","avatar":"` + sampleAvatar + `","signature":"signature1"}`
+
 func TestCreateUser(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
 
 	userprofile := chaincode.SmartContract{}
 	err := userprofile.CreateUser(transactionContext, string(sampleInput))
@@ -65,7 +85,487 @@ func TestCreateUser(t *testing.T) {
 
 	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
 	err = userprofile.CreateUser(transactionContext, string(sampleInput))
-	require.EqualError(t, err, "failed to put to world state: failed inserting key")
+	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+}
+
+func TestCreateUserIdentity(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet2")
+	userprofile := chaincode.SmartContract{}
+
+	err := userprofile.CreateUser(transactionContext, string(sampleInput))
+	require.EqualError(t, err, "the wallet wallet1 does not match the submitting client wallet2")
+
+	anonymousUser := &chaincode.Profile{Username: "user2"}
+	bytes, _ := json.Marshal(anonymousUser)
+	err = userprofile.CreateUser(transactionContext, string(bytes))
+	require.NoError(t, err)
+
+	key, _ := chaincodeStub.PutStateArgsForCall(1)
+	require.Equal(t, "\x00profile~wallet\x00wallet2\x00", key)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetIDReturns("", fmt.Errorf("failure"))
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	err = userprofile.CreateUser(transactionContext, string(sampleInput))
+	require.EqualError(t, err, "failed to read client identity: failure")
+}
+
+func TestCreateUserGrantedFields(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("mallory")
+	userprofile := chaincode.SmartContract{}
+
+	err := userprofile.CreateUser(transactionContext, `{"wallet":"mallory","username":"mallory","rolesAssigned":["admin"],"activeRole":"admin","badgesReceived":["founder"],"activeBadge":"founder","balance":999999,"credibility":100,"muted":true,"banned":true,"delegates":[{"id":"d1","publicKey":"ed25519:AAAA","scopes":["write"],"expiresAt":1}]}`)
+	require.NoError(t, err)
+
+	_, userJSON := chaincodeStub.PutStateArgsForCall(1)
+	stored := &chaincode.Profile{}
+	json.Unmarshal(userJSON, stored)
+	require.Equal(t, "mallory", stored.Username)
+	require.Empty(t, stored.RolesAssigned)
+	require.Empty(t, stored.ActiveRole)
+	require.Empty(t, stored.BadgesReceived)
+	require.Empty(t, stored.ActiveBadge)
+	require.Zero(t, stored.Balance)
+	require.Zero(t, stored.Credibility)
+	require.False(t, stored.Muted)
+	require.False(t, stored.Banned)
+	require.Empty(t, stored.Delegates)
+}
+
+func TestWalletBinding(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetMSPIDReturns("Org1MSP", nil)
+	clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+		switch name {
+		case "wallet":
+			return "wallet1", true, nil
+		case "hf.EnrollmentID":
+			return "user1", true, nil
+		}
+		return "", false, nil
+	}
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	userprofile := chaincode.SmartContract{}
+
+	input, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet1", MSPID: "Org2MSP", EnrollmentID: "user2"})
+	err := userprofile.CreateUser(transactionContext, string(input))
+	require.NoError(t, err)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00enrollment~mspId~enrollmentId\x00Org1MSP\x00user1\x00", key)
+	require.Equal(t, "wallet1", string(value))
+	_, userJSON := chaincodeStub.PutStateArgsForCall(1)
+	stored := &chaincode.Profile{}
+	json.Unmarshal(userJSON, stored)
+	require.Equal(t, "Org1MSP", stored.MSPID)
+	require.Equal(t, "user1", stored.EnrollmentID)
+
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "\x00enrollment~") {
+			return []byte("wallet0"), nil
+		}
+		return nil, nil
+	}
+	err = userprofile.CreateUser(transactionContext, string(input))
+	require.EqualError(t, err, "the enrollment user1 of Org1MSP is already bound to the wallet wallet0")
+
+	chaincodeStub.GetStateStub = nil
+	chaincodeStub.GetStateReturns(userJSON, nil)
+	user, err := userprofile.WhoAmI(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, "wallet1", user.Wallet)
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user2"}`)
+	require.NoError(t, err)
+
+	// another certificate carrying the same wallet is not the owner
+	clientIdentity.GetMSPIDReturns("Org2MSP", nil)
+	_, err = userprofile.WhoAmI(transactionContext)
+	require.EqualError(t, err, "the wallet wallet1 is bound to another certificate than the submitting client's")
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user3"}`)
+	require.EqualError(t, err, "the wallet wallet1 is bound to another certificate than the submitting client's")
+
+	chaincodeStub.GetStateReturns(nil, nil)
+	_, err = userprofile.WhoAmI(transactionContext)
+	require.EqualError(t, err, "the user wallet1 does not exist")
+}
+
+func TestSubmitSigned(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet9")
+	userprofile := chaincode.SmartContract{}
+
+	signerKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey := "ed25519:" + base64.StdEncoding.EncodeToString(signerKey.Public().(ed25519.PublicKey))
+	sign := func(function, payload, nonce string) string {
+		message := strings.Join([]string{"", "userprofile", function, "wallet9", "", nonce, payload}, "\x00")
+		envelope, _ := json.Marshal(&chaincode.Envelope{
+			Wallet:    "wallet9",
+			Function:  function,
+			Payload:   payload,
+			Nonce:     nonce,
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signerKey, []byte(message))),
+		})
+		return string(envelope)
+	}
+
+	stored, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet9"})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err := userprofile.UpdateUser(transactionContext, `{"wallet":"wallet9","publicKey":"ed25519:AAAA"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the publicKey is invalid: an ed25519 key must be 32 bytes long")
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet9","publicKey":"secp256k1:AAAA"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the publicKey is invalid: malformed public key: invalid length: 3")
+
+	err = userprofile.SubmitSigned(transactionContext, sign("UpdateUser", `{"wallet":"wallet9","username":"user9"}`, "n1"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the wallet wallet9 has no public key registered")
+
+	// a gateway submits the update the wallet signed
+	transactionContext, chaincodeStub = prepMocks("gateway")
+	stored, _ = json.Marshal(&chaincode.Profile{Wallet: "wallet9", MSPID: "Org1MSP", EnrollmentID: "user9", PublicKey: publicKey})
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "\x00profile~wallet\x00wallet9") {
+			return stored, nil
+		}
+		return nil, nil
+	}
+	err = userprofile.SubmitSigned(transactionContext, sign("UpdateUser", `{"wallet":"wallet9","username":"user9"}`, "n1"))
+	require.NoError(t, err)
+
+	key, _ := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00nonce~wallet~nonce\x00wallet9\x00n1\x00", key)
+	_, userJSON := chaincodeStub.PutStateArgsForCall(1)
+	updated := &chaincode.Profile{}
+	json.Unmarshal(userJSON, updated)
+	require.Equal(t, "user9", updated.Username)
+
+	err = userprofile.SubmitSigned(transactionContext, sign("UpdateUser", `{"wallet":"wallet9","balance":100}`, "n2"))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field balance may not be changed")
+
+	err = userprofile.SubmitSigned(transactionContext, sign("CreateUser", `{"wallet":"wallet9"}`, "n3"))
+	require.EqualError(t, err, "the transaction CreateUser cannot be submitted signed")
+
+	err = userprofile.SubmitSigned(transactionContext, sign("UpdateUser", `{"wallet":"wallet9"}`, "n 4"))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the nonce may only contain letters, digits, '-' and '_'")
+
+	chaincodeStub.GetStateStub = nil
+	chaincodeStub.GetStateReturns(stored, nil)
+	err = userprofile.SubmitSigned(transactionContext, sign("UpdateUser", `{"wallet":"wallet9","username":"user9"}`, "n1"))
+	require.ErrorIs(t, err, chaincode.ErrNonceReused)
+}
+
+func TestDelegates(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet9")
+	userprofile := chaincode.SmartContract{}
+
+	walletKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	sessionKey := ed25519.NewKeyFromSeed([]byte(strings.Repeat("s", ed25519.SeedSize)))
+	encode := func(key ed25519.PrivateKey) string {
+		return "ed25519:" + base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	}
+	sign := func(key ed25519.PrivateKey, delegate, function, payload, nonce string) string {
+		message := strings.Join([]string{"", "userprofile", function, "wallet9", delegate, nonce, payload}, "\x00")
+		envelope, _ := json.Marshal(&chaincode.Envelope{
+			Wallet:    "wallet9",
+			Function:  function,
+			Payload:   payload,
+			Nonce:     nonce,
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(message))),
+			Delegate:  delegate,
+		})
+		return string(envelope)
+	}
+
+	stored, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet9", PublicKey: encode(walletKey)})
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if key == "\x00profile~wallet\x00wallet9\x00" {
+			return stored, nil
+		}
+		return nil, nil
+	}
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+
+	for payload, message := range map[string]string{
+		`{"id":"my phone","publicKey":"` + encode(sessionKey) + `","scopes":["vote"],"expiresAt":2000000}`: "the id may only contain letters, digits, '-' and '_'",
+		`{"id":"phone","scopes":["vote"],"expiresAt":2000000}`:                                             "the publicKey is required",
+		`{"id":"phone","publicKey":"` + encode(sessionKey) + `","scopes":[],"expiresAt":2000000}`:          "the scopes are required",
+		`{"id":"phone","publicKey":"` + encode(sessionKey) + `","scopes":["fly"],"expiresAt":2000000}`:     "the scope fly is unknown",
+		`{"id":"phone","publicKey":"` + encode(sessionKey) + `","scopes":["vote"],"expiresAt":1000000}`:    "the expiresAt is not in the future",
+		`{"id":"phone","publicKey":"` + encode(sessionKey) + `","scopes":["vote"],"expiresAt":2593000001}`: "the expiresAt is more than 30 days away",
+	} {
+		err := userprofile.AddDelegate(transactionContext, payload)
+		require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+		require.EqualError(t, err, "ERR_INVALID_PAYLOAD: "+message)
+	}
+
+	grant := `{"id":"phone","publicKey":"` + encode(sessionKey) + `","scopes":["vote","profile"],"expiresAt":2000000}`
+	err := userprofile.AddDelegate(transactionContext, grant)
+	require.NoError(t, err)
+
+	_, stored = chaincodeStub.PutStateArgsForCall(0)
+	user := &chaincode.Profile{}
+	json.Unmarshal(stored, user)
+	require.Equal(t, []chaincode.Delegate{{ID: "phone", PublicKey: encode(sessionKey), Scopes: []string{"vote", "profile"}, ExpiresAt: 2000000}}, user.Delegates)
+
+	err = userprofile.AddDelegate(transactionContext, grant)
+	require.EqualError(t, err, "the delegate phone of the wallet wallet9 already exists")
+
+	// the delegate is signed, so an envelope of a delegate holding the same key as
+	// another cannot be relayed under the other to borrow its scopes
+	err = userprofile.AddDelegate(transactionContext, `{"id":"laptop","publicKey":"`+encode(sessionKey)+`","scopes":["vote"],"expiresAt":2000000}`)
+	require.NoError(t, err)
+	_, stored = chaincodeStub.PutStateArgsForCall(chaincodeStub.PutStateCallCount() - 1)
+	relabeled := strings.Replace(sign(sessionKey, "laptop", "UpdateUser", `{"wallet":"wallet9"}`, "n6"), `"delegate":"laptop"`, `"delegate":"phone"`, 1)
+	err = userprofile.SubmitSigned(transactionContext, relabeled)
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet9")
+
+	err = userprofile.RevokeDelegate(transactionContext, `{"id":"laptop"}`)
+	require.NoError(t, err)
+	_, stored = chaincodeStub.PutStateArgsForCall(chaincodeStub.PutStateCallCount() - 1)
+
+	err = userprofile.AddDelegate(transactionContext, `{"wallet":"wallet8","id":"tablet"}`)
+	require.EqualError(t, err, "the wallet wallet8 does not match the submitting client wallet9")
+
+	// the delegate signs within its scopes on behalf of the wallet
+	err = userprofile.SubmitSigned(transactionContext, sign(sessionKey, "phone", "UpdateUser", `{"wallet":"wallet9","username":"user9","publicKey":"`+encode(sessionKey)+`"}`, "n1"))
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field publicKey may not be changed")
+
+	err = userprofile.SubmitSigned(transactionContext, sign(sessionKey, "phone", "UpdateUser", `{"wallet":"wallet9","username":"user9"}`, "n5"))
+	require.NoError(t, err)
+	_, userJSON := chaincodeStub.PutStateArgsForCall(chaincodeStub.PutStateCallCount() - 1)
+	user = &chaincode.Profile{}
+	json.Unmarshal(userJSON, user)
+	require.Equal(t, "user9", user.Username)
+	require.Equal(t, encode(walletKey), user.PublicKey)
+
+	err = userprofile.SubmitSigned(transactionContext, sign(sessionKey, "phone", "AddDelegate", `{"id":"tablet"}`, "n2"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: delegates may not sign AddDelegate")
+
+	err = userprofile.SubmitSigned(transactionContext, sign(sessionKey, "tablet", "UpdateUser", `{"wallet":"wallet9"}`, "n2"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the wallet wallet9 has no delegate tablet")
+
+	err = userprofile.SubmitSigned(transactionContext, sign(walletKey, "phone", "UpdateUser", `{"wallet":"wallet9"}`, "n2"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the envelope is not signed by the wallet wallet9")
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	err = userprofile.SubmitSigned(transactionContext, sign(sessionKey, "phone", "UpdateUser", `{"wallet":"wallet9"}`, "n2"))
+	require.ErrorIs(t, err, chaincode.ErrDelegateExpired)
+	require.EqualError(t, err, "ERR_DELEGATE_EXPIRED: the delegate phone of the wallet wallet9 has expired")
+
+	// the wallet key revokes the delegate
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	err = userprofile.SubmitSigned(transactionContext, sign(walletKey, "", "RevokeDelegate", `{"id":"phone"}`, "n3"))
+	require.NoError(t, err)
+	_, stored = chaincodeStub.PutStateArgsForCall(chaincodeStub.PutStateCallCount() - 1)
+	user = &chaincode.Profile{}
+	json.Unmarshal(stored, user)
+	require.Empty(t, user.Delegates)
+
+	err = userprofile.SubmitSigned(transactionContext, sign(sessionKey, "phone", "UpdateUser", `{"wallet":"wallet9"}`, "n4"))
+	require.EqualError(t, err, "ERR_INVALID_SIGNATURE: the wallet wallet9 has no delegate phone")
+
+	err = userprofile.RevokeDelegate(transactionContext, `{"id":"phone"}`)
+	require.EqualError(t, err, "the wallet wallet9 has no delegate phone")
+}
+
+func TestWalletRotation(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet1")
+	userprofile := chaincode.SmartContract{}
+
+	state := map[string][]byte{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		delete(state, key)
+		return nil
+	}
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	actAs := func(wallet, role string) {
+		other, _ := prepMocksWithRole(wallet, role)
+		transactionContext.GetClientIdentityReturns(other.GetClientIdentity())
+	}
+
+	state["\x00profile~wallet\x00wallet1\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "wallet1", Guardians: []string{"guardian1", "guardian2", "guardian3"}})
+	state["\x00profile~wallet\x00wallet5\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "wallet5"})
+
+	err := userprofile.RotateWallet(transactionContext, `{"newWallet":"wallet5"}`)
+	require.EqualError(t, err, "the user wallet wallet5 already exists")
+
+	err = userprofile.RotateWallet(transactionContext, `{"newWallet":"wallet1"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the newWallet is the current wallet")
+
+	err = userprofile.RotateWallet(transactionContext, `{"wallet":"wallet1","newWallet":"wallet2"}`)
+	require.NoError(t, err)
+
+	user, err := userprofile.ReadUser(transactionContext, "wallet1")
+	require.NoError(t, err)
+	require.Equal(t, "wallet2", user.Wallet)
+	require.Equal(t, []string{"wallet1"}, user.PreviousWallets)
+	require.Equal(t, []string{"guardian1", "guardian2", "guardian3"}, user.Guardians)
+
+	// the old wallet may no longer act for the profile
+	_, err = userprofile.WhoAmI(transactionContext)
+	require.ErrorIs(t, err, chaincode.ErrWalletRotated)
+	require.EqualError(t, err, "ERR_WALLET_ROTATED: the wallet wallet1 was rotated to wallet2")
+
+	err = userprofile.CreateUser(transactionContext, string(sampleInput))
+	require.EqualError(t, err, "ERR_WALLET_ROTATED: the wallet wallet1 was rotated to wallet2")
+
+	// every previous wallet forwards straight to the current one
+	actAs("wallet2", "")
+	err = userprofile.RotateWallet(transactionContext, `{"newWallet":"wallet3"}`)
+	require.NoError(t, err)
+	require.Equal(t, "wallet3", string(state["\x00forward~wallet\x00wallet1\x00"]))
+	require.Equal(t, "wallet3", string(state["\x00forward~wallet\x00wallet2\x00"]))
+
+	// guardians and admins recover the wallet by a majority of the guardians
+	actAs("stranger", "")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet4"}`)
+	require.EqualError(t, err, "the client stranger is not permitted to recover the wallet wallet3")
+
+	actAs("guardian1", "")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet4"}`)
+	require.NoError(t, err)
+
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet4"}`)
+	require.EqualError(t, err, "the client guardian1 already approved the recovery of the wallet wallet3")
+
+	actAs("guardian2", "")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet6"}`)
+	require.EqualError(t, err, "a recovery of the wallet wallet3 to wallet4 is pending")
+
+	actAs("wallet3", "")
+	err = userprofile.CancelRecovery(transactionContext, `{}`)
+	require.NoError(t, err)
+
+	err = userprofile.CancelRecovery(transactionContext, `{}`)
+	require.EqualError(t, err, "no recovery of the wallet wallet3 is pending")
+
+	actAs("guardian2", "")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet6"}`)
+	require.NoError(t, err)
+
+	actAs("admin1", "admin")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet6"}`)
+	require.NoError(t, err)
+	require.Nil(t, state["\x00recovery~wallet\x00wallet3\x00"])
+
+	user, err = userprofile.ReadUser(transactionContext, "wallet1")
+	require.NoError(t, err)
+	require.Equal(t, "wallet6", user.Wallet)
+	require.Equal(t, []string{"wallet1", "wallet2", "wallet3"}, user.PreviousWallets)
+
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet3","newWallet":"wallet7"}`)
+	require.EqualError(t, err, "ERR_WALLET_ROTATED: the wallet wallet3 was rotated to wallet6")
+}
+
+func TestForgedPreviousWallets(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("mallory")
+	userprofile := chaincode.SmartContract{}
+
+	err := userprofile.CreateUser(transactionContext, `{"wallet":"mallory","previousWallets":["wallet1"]}`)
+	require.NoError(t, err)
+
+	_, userJSON := chaincodeStub.PutStateArgsForCall(1)
+	stored := &chaincode.Profile{}
+	json.Unmarshal(userJSON, stored)
+	require.Empty(t, stored.PreviousWallets)
+
+	chaincodeStub.GetStateReturns(userJSON, nil)
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"mallory","previousWallets":["wallet1"]}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field previousWallets may not be changed")
+
+	admin, _ := prepMocksWithRole("admin1", "admin")
+	transactionContext.GetClientIdentityReturns(admin.GetClientIdentity())
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"mallory","previousWallets":["wallet1"]}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field previousWallets may not be changed")
+}
+
+func TestRotatedBinding(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet1")
+	userprofile := chaincode.SmartContract{}
+
+	state := map[string][]byte{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		delete(state, key)
+		return nil
+	}
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	enrolled := func(wallet, enrollmentID string) {
+		clientIdentity := &mocks.ClientIdentity{}
+		clientIdentity.GetMSPIDReturns("Org1MSP", nil)
+		clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+			switch name {
+			case "wallet":
+				return wallet, true, nil
+			case "hf.EnrollmentID":
+				return enrollmentID, true, nil
+			}
+			return "", false, nil
+		}
+		transactionContext.GetClientIdentityReturns(clientIdentity)
+	}
+
+	enrolled("wallet1", "user1")
+	err := userprofile.CreateUser(transactionContext, `{"wallet":"wallet1","guardians":["guardian1","guardian2"]}`)
+	require.NoError(t, err)
+
+	// the owner rotating the wallet keeps the enrollment, bound to the new wallet
+	err = userprofile.RotateWallet(transactionContext, `{"newWallet":"wallet2"}`)
+	require.NoError(t, err)
+	require.Equal(t, "wallet2", string(state["\x00enrollment~mspId~enrollmentId\x00Org1MSP\x00user1\x00"]))
+
+	enrolled("wallet2", "user2")
+	_, err = userprofile.WhoAmI(transactionContext)
+	require.EqualError(t, err, "the wallet wallet2 is bound to another certificate than the submitting client's")
+
+	enrolled("wallet2", "user1")
+	user, err := userprofile.WhoAmI(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, "user1", user.EnrollmentID)
+
+	// recovering the wallet releases the enrollment, as the certificate may be lost
+	enrolled("guardian1", "guardian1")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet2","newWallet":"wallet3"}`)
+	require.NoError(t, err)
+	enrolled("guardian2", "guardian2")
+	err = userprofile.ApproveRecovery(transactionContext, `{"wallet":"wallet2","newWallet":"wallet3"}`)
+	require.NoError(t, err)
+	require.Nil(t, state["\x00enrollment~mspId~enrollmentId\x00Org1MSP\x00user1\x00"])
+
+	enrolled("wallet3", "user3")
+	user, err = userprofile.WhoAmI(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, "", user.EnrollmentID)
+
+	err = userprofile.BindWallet(transactionContext, `{}`)
+	require.NoError(t, err)
+	require.Equal(t, "wallet3", string(state["\x00enrollment~mspId~enrollmentId\x00Org1MSP\x00user3\x00"]))
+
+	err = userprofile.BindWallet(transactionContext, `{}`)
+	require.EqualError(t, err, "the wallet wallet3 is already bound to the enrollment user3 of Org1MSP")
+
+	enrolled("wallet3", "user1")
+	err = userprofile.BindWallet(transactionContext, `{}`)
+	require.EqualError(t, err, "the wallet wallet3 is bound to another certificate than the submitting client's")
+
+	// the released enrollment may be bound to another wallet
+	enrolled("wallet9", "user1")
+	err = userprofile.CreateUser(transactionContext, `{"wallet":"wallet9"}`)
+	require.NoError(t, err)
 }
 
 func TestReadUser(t *testing.T) {
@@ -94,44 +594,214 @@ func TestReadUser(t *testing.T) {
 }
 
 func TestUpdateUser(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
 
-	expectedAsset := &chaincode.Profile{Wallet: "user1"}
+	expectedAsset := &chaincode.Profile{Wallet: "wallet1"}
 	bytes, err := json.Marshal(expectedAsset)
 	require.NoError(t, err)
 
 	chaincodeStub.GetStateReturns(bytes, nil)
 	userprofile := chaincode.SmartContract{}
-	err = userprofile.UpdateUser(transactionContext, string(sampleInput))
+	err = userprofile.UpdateUser(transactionContext, samplePatch)
 	require.NoError(t, err)
 
 	err = userprofile.UpdateUser(transactionContext, "sad")
 	require.EqualError(t, err, "invalid character 's' looking for beginning of value")
 
+	spoofedUser := &chaincode.Profile{Wallet: "wallet2"}
+	spoofedBytes, _ := json.Marshal(spoofedUser)
+	chaincodeStub.GetStateReturns(spoofedBytes, nil)
+	err = userprofile.UpdateUser(transactionContext, string(spoofedBytes))
+	require.EqualError(t, err, "the wallet wallet2 does not match the submitting client wallet1")
+	chaincodeStub.GetStateReturns(bytes, nil)
+
 	emptyWalletUser := &chaincode.Profile{Wallet: ""}
 	bytes, err = json.Marshal(emptyWalletUser)
 	err = userprofile.UpdateUser(transactionContext, string(bytes))
 	require.EqualError(t, err, "wallet is required for user updating")
 
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = userprofile.UpdateUser(transactionContext, string(sampleInput))
+	err = userprofile.UpdateUser(transactionContext, samplePatch)
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 
 	chaincodeStub.GetStateReturns(nil, nil)
-	err = userprofile.UpdateUser(transactionContext, string(sampleInput))
+	err = userprofile.UpdateUser(transactionContext, samplePatch)
 	require.EqualError(t, err, "the user wallet1 does not exist")
 
 	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
-	err = userprofile.UpdateUser(transactionContext, string(sampleInput))
+	err = userprofile.UpdateUser(transactionContext, samplePatch)
 	require.EqualError(t, err, "the user wallet1 does not exist")
 }
 
+func TestUpdateUserMergePatch(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
+	userprofile := chaincode.SmartContract{}
+
+	stored, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet1", Username: "user1", Avatar: sampleAvatar, Muted: true, Balance: 5, RolesAssigned: []string{"writer"}})
+	chaincodeStub.GetStateReturns(stored, nil)
+	err := userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","avatar":null,"activeRole":"writer","muted":false,"balance":0}`)
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field balance may not be changed")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","avatar":null,"activeRole":"writer"}`)
+	require.NoError(t, err)
+
+	_, userJSON := chaincodeStub.PutStateArgsForCall(0)
+	updated := &chaincode.Profile{}
+	json.Unmarshal(userJSON, updated)
+	require.Equal(t, "user1", updated.Username)
+	require.Equal(t, "", updated.Avatar)
+	require.Equal(t, "writer", updated.ActiveRole)
+	require.True(t, updated.Muted)
+	require.Equal(t, 5, updated.Balance)
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","activeRole":"admin"}`)
+	require.EqualError(t, err, "the role admin is not assigned to wallet1")
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","activeBadge":"gold"}`)
+	require.EqualError(t, err, "the badge gold is not received by wallet1")
+
+	// a stale active role or badge only blocks patches setting them
+	stale, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet1", ActiveRole: "writer", ActiveBadge: "gold"})
+	chaincodeStub.GetStateReturns(stale, nil)
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user2"}`)
+	require.NoError(t, err)
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","activeRole":"writer"}`)
+	require.EqualError(t, err, "the role writer is not assigned to wallet1")
+	chaincodeStub.GetStateReturns(stored, nil)
+
+	// the moderator role grants its built in permissions while no definition is stored
+	transactionContext, chaincodeStub = prepMocksWithRole("moderator1", "moderator")
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "\x00profile~") {
+			return stored, nil
+		}
+		return nil, nil
+	}
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user2","muted":false}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the field username may not be changed")
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","muted":false}`)
+	require.NoError(t, err)
+
+	_, userJSON = chaincodeStub.PutStateArgsForCall(0)
+	updated = &chaincode.Profile{}
+	json.Unmarshal(userJSON, updated)
+	require.Equal(t, "user1", updated.Username)
+	require.False(t, updated.Muted)
+	require.Equal(t, 5, updated.Balance)
+
+	transactionContext, chaincodeStub = prepMocksWithRole("admin1", "admin")
+	chaincodeStub.GetStateReturns(stored, nil)
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","balance":0}`)
+	require.NoError(t, err)
+
+	_, userJSON = chaincodeStub.PutStateArgsForCall(0)
+	updated = &chaincode.Profile{}
+	json.Unmarshal(userJSON, updated)
+	require.Equal(t, 0, updated.Balance)
+	require.True(t, updated.Muted)
+}
+
+func TestValidateUser(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
+	userprofile := chaincode.SmartContract{}
+
+	err := userprofile.CreateUser(transactionContext, `{"wallet":"wallet1","email":"1"}`)
+	require.EqualError(t, err, `json: unknown field "email"`)
+
+	err = userprofile.CreateUser(transactionContext, `{"wallet":"wallet1"}]`)
+	require.EqualError(t, err, "invalid data after the payload")
+
+	for payload, message := range map[string]string{
+		`{"username":"` + strings.Repeat("a", 33) + `"}`:         "the username is longer than 32 characters",
+		`{"signature":"1\n2"}`:                                   "the signature contains control characters",
+		`{"rolesAssigned":[""]}`:                                 "the rolesAssigned is required",
+		`{"badgesReceived":["` + strings.Repeat("a", 33) + `"]}`: "the badgesReceived is longer than 32 characters",
+	} {
+		err = userprofile.CreateUser(transactionContext, payload)
+		require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+		require.EqualError(t, err, "ERR_INVALID_PAYLOAD: "+message)
+	}
+
+	chaincodeStub.GetStateReturns(sampleInput, nil)
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","avatar":"`+strings.Repeat("a", 129)+`"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the avatar is longer than 128 characters")
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","avatar":"https://example.com/avatar.png"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the avatar is not a valid CID: invalid cid: selected encoding not supported")
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","avatar":"bafkrcfbvnimsw6itwbgfiv2nddbi2rxghfkcrky"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the avatar is not a valid CID: hash function 0x11 is not allowed")
+	require.Zero(t, chaincodeStub.PutStateCallCount())
+}
+
+func TestUserVersions(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
+	userprofile := chaincode.SmartContract{}
+
+	input, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet1", Version: 7})
+	err := userprofile.CreateUser(transactionContext, string(input))
+	require.NoError(t, err)
+
+	_, userJSON := chaincodeStub.PutStateArgsForCall(1)
+	stored := &chaincode.Profile{}
+	json.Unmarshal(userJSON, stored)
+	require.Equal(t, uint64(1), stored.Version)
+
+	chaincodeStub.GetStateReturns(userJSON, nil)
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user2","expectedVersion":1}`)
+	require.NoError(t, err)
+
+	_, userJSON = chaincodeStub.PutStateArgsForCall(2)
+	stored = &chaincode.Profile{}
+	json.Unmarshal(userJSON, stored)
+	require.Equal(t, uint64(2), stored.Version)
+
+	chaincodeStub.GetStateReturns(userJSON, nil)
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user3","expectedVersion":1}`)
+	require.ErrorIs(t, err, chaincode.ErrVersionConflict)
+	require.EqualError(t, err, "ERR_VERSION_CONFLICT: the user wallet1 is at version 2, not 1")
+}
+
+func TestUserTimestamps(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
+	userprofile := chaincode.SmartContract{}
+
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	input, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet1", CreatedAt: 1, UpdatedAt: 1})
+	err := userprofile.CreateUser(transactionContext, string(input))
+	require.NoError(t, err)
+
+	_, userJSON := chaincodeStub.PutStateArgsForCall(1)
+	var created chaincode.Profile
+	json.Unmarshal(userJSON, &created)
+	require.Equal(t, int64(1000000), created.CreatedAt)
+	require.Equal(t, int64(1000000), created.UpdatedAt)
+
+	chaincodeStub.GetStateReturns(userJSON, nil)
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 2000}, nil)
+	err = userprofile.UpdateUser(transactionContext, string(input))
+	require.ErrorIs(t, err, chaincode.ErrInvalidPayload)
+
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user1"}`)
+	require.NoError(t, err)
+
+	_, userJSON = chaincodeStub.PutStateArgsForCall(2)
+	var updated chaincode.Profile
+	json.Unmarshal(userJSON, &updated)
+	require.Equal(t, int64(1000000), updated.CreatedAt)
+	require.Equal(t, int64(2000000), updated.UpdatedAt)
+
+	chaincodeStub.GetTxTimestampReturns(nil, fmt.Errorf("failure"))
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"wallet1","username":"user1"}`)
+	require.EqualError(t, err, "failed to read transaction timestamp: failure")
+}
+
 func TestAssignRole(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
 
 	expectedAsset := &chaincode.Profile{Wallet: "user1"}
 	bytes, err := json.Marshal(expectedAsset)
@@ -139,26 +809,204 @@ func TestAssignRole(t *testing.T) {
 
 	chaincodeStub.GetStateReturns(bytes, nil)
 	userprofile := chaincode.SmartContract{}
-	err = userprofile.AssignRole(transactionContext, "user1", "1")
+	err = userprofile.AssignRole(transactionContext, "user1", "moderator")
 	require.NoError(t, err)
 
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = userprofile.AssignRole(transactionContext, "user1", "0")
+	err = userprofile.AssignRole(transactionContext, "user1", "admin")
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
 
 	chaincodeStub.GetStateReturns(nil, nil)
-  err = userprofile.AssignRole(transactionContext, "user1", "0")
+  err = userprofile.AssignRole(transactionContext, "user1", "moderator")
 	require.EqualError(t, err, "the user user1 does not exist")
 
+	err = userprofile.AssignRole(transactionContext, "user1", "0")
+	require.EqualError(t, err, "the role 0 does not exist")
+
+	expectedAsset = &chaincode.Profile{Wallet: "user1", RolesAssigned: []string{"moderator"}}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		if key == "\x00profile~wallet\x00user1\x00" {
+			return json.Marshal(expectedAsset)
+		}
+		return nil, nil
+	}
+	err = userprofile.AssignRole(transactionContext, "user1", "moderator")
+	require.EqualError(t, err, "the user user1 already has the role moderator")
+	chaincodeStub.GetStateStub = nil
+
 	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
 	err = userprofile.AssignRole(transactionContext, "", "0")
 	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
 }
 
+func TestAssignRolePermission(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("user2")
+	userprofile := chaincode.SmartContract{}
+
+	expectedAsset := &chaincode.Profile{Wallet: "user2"}
+	bytes, _ := json.Marshal(expectedAsset)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	err := userprofile.AssignRole(transactionContext, "user2", "admin")
+	require.EqualError(t, err, "the client user2 is not permitted to manage roles")
+
+	err = userprofile.RemoveRole(transactionContext, "user2", "admin")
+	require.EqualError(t, err, "the client user2 is not permitted to manage roles")
+
+	expectedAsset = &chaincode.Profile{Wallet: "user2", RolesAssigned: []string{"admin"}}
+	bytes, _ = json.Marshal(expectedAsset)
+	chaincodeStub.GetStateReturns(bytes, nil)
+
+	err = userprofile.AssignRole(transactionContext, "user2", "moderator")
+	require.NoError(t, err)
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
+	err = userprofile.AssignRole(transactionContext, "user2", "moderator")
+	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+}
+
+func TestRoles(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
+	userprofile := chaincode.SmartContract{}
+
+	state := map[string][]byte{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		delete(state, key)
+		return nil
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(&mocks.StateQueryIterator{}, nil)
+	state["\x00profile~wallet\x00user1\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "user1"})
+	state["\x00profile~wallet\x00user2\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "user2"})
+
+	roles, err := userprofile.GetAllRoles(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	require.Equal(t, "admin", roles[0].Name)
+	require.Equal(t, []string{"moderate"}, roles[1].Permissions)
+
+	err = userprofile.CreateRole(transactionContext, `{"name":"moderator"}`)
+	require.EqualError(t, err, "the role moderator already exists")
+
+	err = userprofile.CreateRole(transactionContext, `{"description":"Curates"}`)
+	require.EqualError(t, err, "ERR_INVALID_PAYLOAD: the name is required")
+
+	err = userprofile.CreateRole(transactionContext, `{"name":"curator","description":"Curates tags","permissions":["tag"],"grantedBy":["moderator"]}`)
+	require.NoError(t, err)
+
+	role, err := userprofile.ReadRole(transactionContext, "curator")
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.Role{Name: "curator", Description: "Curates tags", Permissions: []string{"tag"}, GrantedBy: []string{"moderator"}}, role)
+
+	err = userprofile.UpdateRole(transactionContext, `{"name":"editor","permissions":["edit"]}`)
+	require.EqualError(t, err, "the role editor does not exist")
+
+	err = userprofile.UpdateRole(transactionContext, `{"name":"moderator","permissions":["moderate","tag"],"grantedBy":["admin"]}`)
+	require.NoError(t, err)
+
+	// moderators grant the roles their role grants, and only those
+	err = userprofile.AssignRole(transactionContext, "user1", "moderator")
+	require.NoError(t, err)
+
+	moderator, _ := prepMocks("user1")
+	transactionContext.GetClientIdentityReturns(moderator.GetClientIdentity())
+	err = userprofile.AssignRole(transactionContext, "user2", "curator")
+	require.NoError(t, err)
+
+	err = userprofile.AssignRole(transactionContext, "user2", "moderator")
+	require.EqualError(t, err, "the client user1 is not permitted to manage roles")
+
+	err = userprofile.CreateRole(transactionContext, `{"name":"editor"}`)
+	require.EqualError(t, err, "the client user1 is not permitted to manage roles")
+
+	for wallet, expected := range map[string]bool{"user1": true, "user2": true, "user3": false} {
+		permitted, err := userprofile.HasPermission(transactionContext, wallet, "tag")
+		require.NoError(t, err)
+		require.Equal(t, expected, permitted, wallet)
+	}
+
+	permitted, err := userprofile.HasPermission(transactionContext, "user2", "moderate")
+	require.NoError(t, err)
+	require.False(t, permitted)
+
+	// admins hold every permission, by certificate or by profile
+	admin, _ := prepMocksWithRole("admin1", "admin")
+	transactionContext.GetClientIdentityReturns(admin.GetClientIdentity())
+	permitted, err = userprofile.HasPermission(transactionContext, "admin1", "anything")
+	require.NoError(t, err)
+	require.True(t, permitted)
+
+	permitted, err = userprofile.HasPermission(transactionContext, "user1", "anything")
+	require.NoError(t, err)
+	require.False(t, permitted)
+
+	err = userprofile.DeleteRole(transactionContext, "admin")
+	require.EqualError(t, err, "the role admin is built in and cannot be deleted")
+
+	err = userprofile.DeleteRole(transactionContext, "curator")
+	require.NoError(t, err)
+
+	permitted, err = userprofile.HasPermission(transactionContext, "user2", "tag")
+	require.NoError(t, err)
+	require.False(t, permitted)
+
+	err = userprofile.AssignRole(transactionContext, "user1", "curator")
+	require.EqualError(t, err, "the role curator does not exist")
+
+	err = userprofile.RemoveRole(transactionContext, "user2", "curator")
+	require.NoError(t, err)
+}
+
+func TestModeratePermission(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
+	userprofile := chaincode.SmartContract{}
+
+	state := map[string][]byte{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+	state["\x00profile~wallet\x00user1\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "user1"})
+	state["\x00profile~wallet\x00user2\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "user2"})
+
+	err := userprofile.CreateRole(transactionContext, `{"name":"warden","permissions":["moderate"]}`)
+	require.NoError(t, err)
+	err = userprofile.AssignRole(transactionContext, "user1", "warden")
+	require.NoError(t, err)
+
+	err = userprofile.UpdateRole(transactionContext, `{"name":"moderator","grantedBy":["admin"]}`)
+	require.NoError(t, err)
+	err = userprofile.AssignRole(transactionContext, "user2", "moderator")
+	require.NoError(t, err)
+
+	// the permission, not the name of the role, lets a user mute others
+	warden, _ := prepMocks("user1")
+	transactionContext.GetClientIdentityReturns(warden.GetClientIdentity())
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"user2","muted":true}`)
+	require.NoError(t, err)
+
+	muted, err := userprofile.ReadUser(transactionContext, "user2")
+	require.NoError(t, err)
+	require.True(t, muted.Muted)
+
+	moderator, _ := prepMocks("user2")
+	transactionContext.GetClientIdentityReturns(moderator.GetClientIdentity())
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"user1","muted":true}`)
+	require.EqualError(t, err, "the wallet user1 does not match the submitting client user2")
+}
+
 func TestRemoveRole(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
 
 	expectedUser := &chaincode.Profile{Wallet: "user1", RolesAssigned: []string{"0", "1"}}
 	bytes, err := json.Marshal(expectedUser)
@@ -182,10 +1030,41 @@ func TestRemoveRole(t *testing.T) {
 	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
 }
 
+func TestRemoveActiveRole(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
+	userprofile := chaincode.SmartContract{}
+
+	state := map[string][]byte{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	state["\x00profile~wallet\x00bob\x00"], _ = json.Marshal(&chaincode.Profile{Wallet: "bob", RolesAssigned: []string{"moderator"}, ActiveRole: "moderator"})
+
+	err := userprofile.RemoveRole(transactionContext, "bob", "moderator")
+	require.NoError(t, err)
+
+	user, err := userprofile.ReadUser(transactionContext, "bob")
+	require.NoError(t, err)
+	require.Empty(t, user.RolesAssigned)
+	require.Empty(t, user.ActiveRole)
+
+	// moderators still act on the profile once its active role is revoked
+	moderator, _ := prepMocksWithRole("moderator1", "moderator")
+	transactionContext.GetClientIdentityReturns(moderator.GetClientIdentity())
+	err = userprofile.UpdateUser(transactionContext, `{"wallet":"bob","banned":true}`)
+	require.NoError(t, err)
+
+	user, err = userprofile.ReadUser(transactionContext, "bob")
+	require.NoError(t, err)
+	require.True(t, user.Banned)
+}
+
 func TestAssignBadge(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
 
 	expectedAsset := &chaincode.Profile{Wallet: "user1"}
 	bytes, err := json.Marshal(expectedAsset)
@@ -210,11 +1089,9 @@ func TestAssignBadge(t *testing.T) {
 }
 
 func TestRemoveBadge(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
 
-  expectedUser := &chaincode.Profile{Wallet: "user1", BadgesReceived: []string{"1", "0"}}
+  expectedUser := &chaincode.Profile{Wallet: "user1", BadgesReceived: []string{"1", "0"}, ActiveBadge: "1"}
 	bytes, err := json.Marshal(expectedUser)
 	require.NoError(t, err)
 
@@ -223,6 +1100,12 @@ func TestRemoveBadge(t *testing.T) {
 	err = userprofile.RemoveBadge(transactionContext, "user1", "1")
 	require.NoError(t, err)
 
+	_, userJSON := chaincodeStub.PutStateArgsForCall(0)
+	removed := &chaincode.Profile{}
+	json.Unmarshal(userJSON, removed)
+	require.Equal(t, []string{"0"}, removed.BadgesReceived)
+	require.Empty(t, removed.ActiveBadge)
+
 	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
 	err = userprofile.RemoveBadge(transactionContext, "user1", "0")
 	require.EqualError(t, err, "failed to put to world state: failed inserting key")
@@ -234,6 +1117,14 @@ func TestRemoveBadge(t *testing.T) {
 	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
 	err = userprofile.RemoveBadge(transactionContext, "", "0")
 	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+
+	transactionContext, chaincodeStub = prepMocks("user1")
+	chaincodeStub.GetStateReturns(bytes, nil)
+	err = userprofile.AssignBadge(transactionContext, "user1", "1")
+	require.EqualError(t, err, "the client user1 is not permitted to manage badges")
+
+	err = userprofile.RemoveBadge(transactionContext, "user1", "1")
+	require.EqualError(t, err, "the client user1 is not permitted to manage badges")
 }
 
 func TestGetAllUsers(t *testing.T) {
@@ -250,7 +1141,7 @@ func TestGetAllUsers(t *testing.T) {
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
 
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(iterator, nil)
 	userprofile := &chaincode.SmartContract{}
 	assets, err := userprofile.GetAllUsers(transactionContext)
 	require.NoError(t, err)
@@ -262,8 +1153,214 @@ func TestGetAllUsers(t *testing.T) {
 	require.EqualError(t, err, "failed retrieving next item")
 	require.Nil(t, assets)
 
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving all assets"))
 	assets, err = userprofile.GetAllUsers(transactionContext)
 	require.EqualError(t, err, "failed retrieving all assets")
 	require.Nil(t, assets)
 }
+
+func TestGetAllUsersWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet1")
+	userprofile := &chaincode.SmartContract{}
+
+	asset := &chaincode.Profile{Wallet: "user1"}
+	bytes, _ := json.Marshal(asset)
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, false)
+	iterator.NextReturns(&queryresult.KV{Value: bytes}, nil)
+	metadata := &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "next"}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(iterator, metadata, nil)
+
+	page, err := userprofile.GetAllUsersWithPagination(transactionContext, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.ProfilePage{Records: []*chaincode.Profile{asset}, FetchedRecordsCount: 1, Bookmark: "next"}, page)
+
+	objectType, _, pageSize, _ := chaincodeStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+	require.Equal(t, "profile~wallet", objectType)
+	require.Equal(t, int32(1), pageSize)
+
+	_, err = userprofile.GetAllUsersWithPagination(transactionContext, 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	iterator.HasNextReturns(true)
+	iterator.NextReturns(nil, fmt.Errorf("failed retrieving next item"))
+	_, err = userprofile.GetAllUsersWithPagination(transactionContext, 1, "next")
+	require.EqualError(t, err, "failed retrieving next item")
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationReturns(nil, nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = userprofile.GetAllUsersWithPagination(transactionContext, 1, "next")
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks("wallet1")
+	userprofile := chaincode.SmartContract{}
+
+	_, err := userprofile.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "the client wallet1 is not permitted to migrate keys")
+
+	transactionContext, chaincodeStub = prepMocksWithRole("admin1", "admin")
+
+	legacy, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet1"})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Key: "wallet1", Value: legacy}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Key: "wallet2", Value: []byte("sad")}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+
+	migrated, err := userprofile.MigrateKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "\x00profile~wallet\x00wallet1\x00", key)
+	require.Equal(t, legacy, value)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+	require.Equal(t, "wallet1", chaincodeStub.DelStateArgsForCall(0))
+
+	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
+	_, err = userprofile.MigrateKeys(transactionContext)
+	require.EqualError(t, err, "failed retrieving all assets")
+}
+
+func TestMigrateKeysBinding(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocksWithRole("admin1", "admin")
+	userprofile := chaincode.SmartContract{}
+
+	state := map[string][]byte{
+		"\x00enrollment~mspId~enrollmentId\x00Org1MSP\x00user4\x00": []byte("wallet4"),
+	}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.GetTxTimestampReturns(&timestamppb.Timestamp{Seconds: 1000}, nil)
+
+	// wallet3 names an enrollment it was never bound to, wallet4 is bound to its own
+	stale, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet3", MSPID: "Org1MSP", EnrollmentID: "user9"})
+	bound, _ := json.Marshal(&chaincode.Profile{Wallet: "wallet4", MSPID: "Org1MSP", EnrollmentID: "user4"})
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, true)
+	iterator.HasNextReturnsOnCall(2, false)
+	iterator.NextReturnsOnCall(0, &queryresult.KV{Key: "wallet3", Value: stale}, nil)
+	iterator.NextReturnsOnCall(1, &queryresult.KV{Key: "wallet4", Value: bound}, nil)
+	chaincodeStub.GetStateByRangeReturns(iterator, nil)
+
+	migrated, err := userprofile.MigrateKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+	require.Equal(t, bound, state["\x00profile~wallet\x00wallet4\x00"])
+
+	user := &chaincode.Profile{}
+	json.Unmarshal(state["\x00profile~wallet\x00wallet3\x00"], user)
+	require.Empty(t, user.MSPID)
+	require.Empty(t, user.EnrollmentID)
+
+	// the owner binds the migrated profile to their certificate
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetMSPIDReturns("Org1MSP", nil)
+	clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+		switch name {
+		case "wallet":
+			return "wallet3", true, nil
+		case "hf.EnrollmentID":
+			return "user3", true, nil
+		}
+		return "", false, nil
+	}
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	_, err = userprofile.WhoAmI(transactionContext)
+	require.NoError(t, err)
+
+	err = userprofile.BindWallet(transactionContext, `{}`)
+	require.NoError(t, err)
+	require.Equal(t, "wallet3", string(state["\x00enrollment~mspId~enrollmentId\x00Org1MSP\x00user3\x00"]))
+	json.Unmarshal(state["\x00profile~wallet\x00wallet3\x00"], user)
+	require.Equal(t, "user3", user.EnrollmentID)
+}
+
+func TestGetUserHistory(t *testing.T) {
+	transactionContext, chaincodeStub := prepMocks(sampleUser.Wallet)
+	userprofile := chaincode.SmartContract{}
+
+	modifications := []*queryresult.KeyModification{
+		{TxId: "tx3", IsDelete: true, Timestamp: &timestamppb.Timestamp{Seconds: 3}},
+		{TxId: "tx2", Value: sampleInput, Timestamp: &timestamppb.Timestamp{Seconds: 2}},
+		{TxId: "tx1", Value: sampleInput, Timestamp: &timestamppb.Timestamp{Seconds: 1}},
+	}
+	chaincodeStub.GetHistoryForKeyCalls(func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		return historyIterator(modifications), nil
+	})
+
+	page, err := userprofile.GetUserHistory(transactionContext, "wallet1", 2, "")
+	require.NoError(t, err)
+	require.Equal(t, "\x00profile~wallet\x00wallet1\x00", chaincodeStub.GetHistoryForKeyArgsForCall(0))
+	require.Equal(t, int32(2), page.FetchedRecordsCount)
+	require.Equal(t, "tx2", page.Bookmark)
+	require.True(t, page.Records[0].IsDelete)
+	require.Nil(t, page.Records[0].Record)
+	require.Equal(t, int64(3000), page.Records[0].Timestamp)
+	require.Equal(t, "user1", page.Records[1].Record.Username)
+
+	page, err = userprofile.GetUserHistory(transactionContext, "wallet1", 2, "tx2")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "tx1", page.Records[0].TxID)
+	require.Equal(t, "", page.Bookmark)
+
+	_, err = userprofile.GetUserHistory(transactionContext, "wallet1", 2, "tx4")
+	require.EqualError(t, err, "the bookmark tx4 is not a revision of the user wallet1")
+
+	_, err = userprofile.GetUserHistory(transactionContext, "wallet1", 0, "")
+	require.EqualError(t, err, "the page size 0 is not positive")
+
+	chaincodeStub.GetHistoryForKeyReturns(nil, fmt.Errorf("history disabled"))
+	chaincodeStub.GetHistoryForKeyStub = nil
+	_, err = userprofile.GetUserHistory(transactionContext, "wallet1", 2, "")
+	require.EqualError(t, err, "failed to read history: history disabled")
+}
+
+// historyIterator returns an iterator over the modifications.
+func historyIterator(modifications []*queryresult.KeyModification) *mocks.HistoryQueryIterator {
+	iterator := &mocks.HistoryQueryIterator{}
+	next := 0
+	iterator.HasNextCalls(func() bool {
+		return next < len(modifications)
+	})
+	iterator.NextCalls(func() (*queryresult.KeyModification, error) {
+		next++
+		return modifications[next-1], nil
+	})
+	return iterator
+}
+
+func prepMocks(wallet string) (*mocks.TransactionContext, *mocks.ChaincodeStub) {
+	return prepMocksWithRole(wallet, "")
+}
+
+func prepMocksWithRole(wallet, role string) (*mocks.TransactionContext, *mocks.ChaincodeStub) {
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetAttributeValueStub = func(name string) (string, bool, error) {
+		switch name {
+		case "wallet":
+			return wallet, true, nil
+		case "role":
+			return role, role != "", nil
+		}
+		return "", false, nil
+	}
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	return transactionContext, chaincodeStub
+}
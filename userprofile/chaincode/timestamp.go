@@ -0,0 +1,17 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// getTxTime returns the time the transaction was proposed in milliseconds since the
+// epoch. Every endorser reads the same time, unlike the clock of the peer.
+func getTxTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime().UnixMilli(), nil
+}
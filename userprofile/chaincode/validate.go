@@ -0,0 +1,114 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidPayload is returned when a payload breaks the rules for the fields of a profile.
+var ErrInvalidPayload = errors.New("ERR_INVALID_PAYLOAD")
+
+// Limits on the fields of a profile.
+const (
+	maxWalletLength    = 256
+	maxUsernameLength  = 32
+	maxSignatureLength = 256
+	maxNameLength      = 32
+	maxRoles           = 16
+	maxBadges          = 100
+	maxIDLength        = 128
+)
+
+// idPattern matches the nonces and delegate IDs envelopes carry, as the topic and post
+// chaincodes accept them.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// decodePayload decodes the JSON payload into v, rejecting fields v does not have and
+// anything following the payload.
+func decodePayload(payload string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after the payload")
+	}
+	return nil
+}
+
+// validate returns ErrInvalidPayload when a field of the profile breaks its rules.
+func (p *Profile) validate() error {
+	return firstError(
+		checkText("wallet", p.Wallet, maxWalletLength, true),
+		checkText("username", p.Username, maxUsernameLength, false),
+		checkCID("avatar", p.Avatar, false),
+		checkText("signature", p.Signature, maxSignatureLength, false),
+		checkPublicKey("publicKey", p.PublicKey),
+		checkText("activeRole", p.ActiveRole, maxNameLength, false),
+		checkText("activeBadge", p.ActiveBadge, maxNameLength, false),
+		checkList("rolesAssigned", p.RolesAssigned, maxRoles, maxNameLength),
+		checkList("badgesReceived", p.BadgesReceived, maxBadges, maxNameLength),
+		checkList("guardians", p.Guardians, maxGuardians, maxWalletLength),
+	)
+}
+
+// checkText checks the value is at most max characters long and free of control characters.
+func checkText(field string, value string, max int, required bool) error {
+	if value == "" && required {
+		return fmt.Errorf("%w: the %s is required", ErrInvalidPayload, field)
+	}
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%w: the %s is longer than %d characters", ErrInvalidPayload, field, max)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: the %s contains control characters", ErrInvalidPayload, field)
+	}
+	return nil
+}
+
+// checkID checks the value is an identifier of at most maxIDLength letters, digits, '-' and '_'.
+func checkID(field string, value string, required bool) error {
+	if value == "" && required {
+		return fmt.Errorf("%w: the %s is required", ErrInvalidPayload, field)
+	}
+	if len(value) > maxIDLength {
+		return fmt.Errorf("%w: the %s is longer than %d characters", ErrInvalidPayload, field, maxIDLength)
+	}
+	if !idPattern.MatchString(value) {
+		return fmt.Errorf("%w: the %s may only contain letters, digits, '-' and '_'", ErrInvalidPayload, field)
+	}
+	return nil
+}
+
+// checkList checks the list holds at most max names, each at most maxLength characters long.
+func checkList(field string, values []string, max int, maxLength int) error {
+	if len(values) > max {
+		return fmt.Errorf("%w: the %s hold more than %d values", ErrInvalidPayload, field, max)
+	}
+	for _, value := range values {
+		err := checkText(field, value, maxLength, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrVersionConflict is returned when a profile changed since the version a client expected.
+var ErrVersionConflict = errors.New("ERR_VERSION_CONFLICT")
+
+// Expectation carries the version of a profile a client last read. Transactions changing
+// the profile fail with ErrVersionConflict when the stored version differs, and skip the
+// check when no version is expected.
+type Expectation struct {
+	ExpectedVersion *uint64 `json:"expectedVersion,omitempty"`
+}
+
+// check returns ErrVersionConflict unless the current version is the expected one.
+func (e *Expectation) check(wallet string, current uint64) error {
+	if e.ExpectedVersion != nil && *e.ExpectedVersion != current {
+		return fmt.Errorf("%w: the user %s is at version %d, not %d", ErrVersionConflict, wallet, current, *e.ExpectedVersion)
+	}
+	return nil
+}